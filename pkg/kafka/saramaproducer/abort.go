@@ -0,0 +1,74 @@
+package saramaproducer
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log/level"
+)
+
+// abortReason is the record produced by SyncProducer.AbortTxnWithReason.
+type abortReason struct {
+	TxnID     string    `json:"txn_id"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (sp *syncProducer) AbortTxnWithReason(ctx context.Context, reason string, reasonTopic string) error {
+	if err := sp.AbortTxn(); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(abortReason{
+		TxnID:     sp.TransactionalID(),
+		Reason:    reason,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	recorder, err := sp.abortReasonProducer()
+	if err != nil {
+		return err
+	}
+	_, _, err = sendMessageWithContext(ctx, recorder, &sarama.ProducerMessage{Topic: reasonTopic, Value: sarama.ByteEncoder(value)})
+	return err
+}
+
+// abortReasonProducer returns the non-transactional producer used to record
+// abort reasons, creating it on first use.
+func (sp *syncProducer) abortReasonProducer() (*syncProducer, error) {
+	sp.abortReasonsLock.Lock()
+	defer sp.abortReasonsLock.Unlock()
+
+	if sp.abortReasons != nil {
+		return sp.abortReasons, nil
+	}
+
+	conf := cloneConfig(sp.conf)
+	conf.Producer.Transaction.ID = ""
+	conf.Producer.Idempotent = false
+
+	p, err := newSyncProducer(&configOverrideClient{Client: sp.client, conf: conf}, false, WithLogger(sp.logger))
+	if err != nil {
+		return nil, err
+	}
+	sp.abortReasons = p
+	return sp.abortReasons, nil
+}
+
+func (sp *syncProducer) closeAbortReasons() {
+	sp.abortReasonsLock.Lock()
+	defer sp.abortReasonsLock.Unlock()
+
+	if sp.abortReasons == nil {
+		return
+	}
+	if err := sp.abortReasons.Close(); err != nil {
+		level.Warn(sp.logger).Log("msg", "failed to close abort reason producer", "err", err)
+	}
+	sp.abortReasons = nil
+}
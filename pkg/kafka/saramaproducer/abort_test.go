@@ -0,0 +1,58 @@
+package saramaproducer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncProducer_AbortTxnWithReason(t *testing.T) {
+	const txnID = "test-txn"
+
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorTransaction, txnID, broker),
+		"InitProducerIDRequest": sarama.NewMockInitProducerIDResponse(t),
+		"ProduceRequest":        sarama.NewMockProduceResponse(t),
+	})
+
+	recorder := &sendRecorder{}
+	config := newTestConfig()
+	config.Version = sarama.V2_0_0_0
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 1
+	config.Producer.Transaction.ID = txnID
+	config.Net.MaxOpenRequests = 1
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	require.NoError(t, producer.BeginTxn())
+	require.NoError(t, producer.AbortTxnWithReason(context.Background(), "invalid order", testTopic))
+	require.Zero(t, producer.TxnStatus()&sarama.ProducerTxnFlagInTransaction)
+
+	sent := recorder.messages()
+	require.Len(t, sent, 1)
+	var reason struct {
+		TxnID  string `json:"txn_id"`
+		Reason string `json:"reason"`
+	}
+	require.NoError(t, json.Unmarshal(consumed(t, sent[0]).Value, &reason))
+	require.Equal(t, txnID, reason.TxnID)
+	require.Equal(t, "invalid order", reason.Reason)
+
+	// nothing to abort
+	require.Error(t, producer.AbortTxnWithReason(context.Background(), "again", testTopic))
+	require.Len(t, recorder.messages(), 1)
+}
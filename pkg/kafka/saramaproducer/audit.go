@@ -0,0 +1,71 @@
+package saramaproducer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Auditor receives a record of every message successfully produced through a
+// SyncProducer created with NewAuditingSyncProducer. Implementations must be
+// safe for concurrent use and should not block for long, as they are called
+// synchronously from the sending goroutine.
+type Auditor interface {
+	RecordProduced(topic, key string, partition int32, offset int64, headers map[string]string, ts time.Time)
+}
+
+type auditingSyncProducer struct {
+	decorator
+	auditor Auditor
+}
+
+// NewAuditingSyncProducer returns a SyncProducer that reports every message
+// acknowledged by inner to auditor. Failed sends are not audited.
+func NewAuditingSyncProducer(inner SyncProducer, auditor Auditor) SyncProducer {
+	ap := &auditingSyncProducer{auditor: auditor}
+	ap.decorator = newDecorator(inner, ap)
+	return ap
+}
+
+func (ap *auditingSyncProducer) record(msg *sarama.ProducerMessage, partition int32, offset int64) {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	ts := msg.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	ap.auditor.RecordProduced(msg.Topic, string(keyBytes(msg)), partition, offset, headers, ts)
+}
+
+func (ap *auditingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	partition, offset, err = ap.SyncProducer.SendMessage(msg)
+	if err == nil {
+		ap.record(msg, partition, offset)
+	}
+	return partition, offset, err
+}
+
+func (ap *auditingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	err := ap.SyncProducer.SendMessages(msgs)
+
+	failed := make(map[*sarama.ProducerMessage]struct{})
+	var pErrs ProducerErrors
+	if errors.As(err, &pErrs) {
+		for _, pErr := range pErrs {
+			failed[pErr.Msg] = struct{}{}
+		}
+	} else if err != nil {
+		// the outcome of individual messages is unknown
+		return err
+	}
+
+	for _, msg := range msgs {
+		if _, ok := failed[msg]; !ok {
+			ap.record(msg, msg.Partition, msg.Offset)
+		}
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package saramaproducer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+type auditRecord struct {
+	topic, key string
+	partition  int32
+	offset     int64
+	headers    map[string]string
+	ts         time.Time
+}
+
+// recordingAuditor records every call to RecordProduced.
+type recordingAuditor struct {
+	lock    sync.Mutex
+	records []auditRecord
+}
+
+func (a *recordingAuditor) RecordProduced(topic, key string, partition int32, offset int64, headers map[string]string, ts time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.records = append(a.records, auditRecord{topic: topic, key: key, partition: partition, offset: offset, headers: headers, ts: ts})
+}
+
+func (a *recordingAuditor) recorded() []auditRecord {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]auditRecord(nil), a.records...)
+}
+
+func TestAuditingSyncProducer_SendMessage(t *testing.T) {
+	auditor := &recordingAuditor{}
+	producer := NewAuditingSyncProducer(newTestSyncProducer(t), auditor)
+
+	msg := &sarama.ProducerMessage{
+		Topic:     testTopic,
+		Key:       sarama.StringEncoder("key"),
+		Value:     sarama.StringEncoder("foo"),
+		Headers:   []sarama.RecordHeader{{Key: []byte("h"), Value: []byte("v")}},
+		Timestamp: time.UnixMilli(1700000000000),
+	}
+	partition, offset, err := producer.SendMessage(msg)
+	require.NoError(t, err)
+	require.Equal(t, []auditRecord{{
+		topic:     testTopic,
+		key:       "key",
+		partition: partition,
+		offset:    offset,
+		headers:   map[string]string{"h": "v"},
+		// as reported by the broker
+		ts: msg.Timestamp,
+	}}, auditor.recorded())
+
+	// sends built on SendMessage are audited too
+	_, _, err = producer.SendMessageZeroCopy(testTopic, 1, nil, []byte("foo"))
+	require.NoError(t, err)
+	records := auditor.recorded()
+	require.Len(t, records, 2)
+	require.Equal(t, int32(1), records[1].partition)
+	require.False(t, records[1].ts.IsZero())
+}
+
+func TestAuditingSyncProducer_FailuresAreNotAudited(t *testing.T) {
+	auditor := &recordingAuditor{}
+	producer := NewAuditingSyncProducer(&stubSyncProducer{err: errors.New("down")}, auditor)
+
+	_, _, err := producer.SendMessage(newTestMessage())
+	require.Error(t, err)
+	require.Error(t, producer.SendMessages([]*sarama.ProducerMessage{newTestMessage()}))
+	require.Empty(t, auditor.recorded())
+
+	msgs := []*sarama.ProducerMessage{newTestMessage(), newTestMessage()}
+	msgs[0].Offset = 7
+	inner := &batchRecordingSyncProducer{fail: map[*sarama.ProducerMessage]error{msgs[1]: errors.New("down")}}
+	producer = NewAuditingSyncProducer(inner, auditor)
+	require.Error(t, producer.SendMessages(msgs))
+	records := auditor.recorded()
+	require.Len(t, records, 1)
+	require.Equal(t, int64(7), records[0].offset)
+}
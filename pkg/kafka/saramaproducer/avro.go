@@ -0,0 +1,138 @@
+package saramaproducer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/hamba/avro/v2"
+)
+
+// AvroSyncProducer is a SyncProducer that can also produce Avro-encoded
+// values framed in the schema registry wire format.
+type AvroSyncProducer struct {
+	SyncProducer
+	registry schemaRegistry
+
+	schemas    sync.Map // schema string -> avro.Schema
+	registered sync.Map // avroSubjectSchema -> avroRegistration
+}
+
+type avroSubjectSchema struct {
+	subject string
+	schema  string
+}
+
+type avroRegistration struct {
+	id      int
+	version int
+}
+
+// NewAvroSyncProducer wraps inner so that Avro messages can be sent with
+// SendMessageAvro, registering their schemas with the schema registry at
+// registryURL. All SyncProducer methods are forwarded to inner unchanged.
+func NewAvroSyncProducer(inner SyncProducer, registryURL string) *AvroSyncProducer {
+	return &AvroSyncProducer{SyncProducer: inner, registry: newSchemaRegistry(registryURL)}
+}
+
+// SendMessageAvro encodes value with the Avro schema and produces it to topic
+// like SendMessageWithSchema. The schema is registered under the
+// "<topic>-value" subject the first time it is sent to a topic, so the
+// registry checks it against the subject's compatibility setting before any
+// value using it is produced; a schema it rejects fails with
+// ErrSchemaIncompatible.
+//
+// value is encoded with github.com/hamba/avro/v2: structs are matched to
+// records by their `avro` field tags, maps with string keys may stand for
+// records and maps, and []byte or byte arrays for bytes and fixed types.
+// Record fields missing from a map take their default, bytes and fixed
+// defaults being read as ISO-8859-1 strings as the specification requires.
+func (ap *AvroSyncProducer) SendMessageAvro(ctx context.Context, topic string, key []byte, value interface{}, schema string) (int32, int64, error) {
+	parsed, err := ap.parse(schema)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	encoded, err := avro.Marshal(parsed, value)
+	if err != nil {
+		return -1, -1, fmt.Errorf("kafka: failed to Avro-encode message value: %w", err)
+	}
+
+	reg, err := ap.register(ctx, topic+"-value", schema)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(encoded)}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	return sendWithContext(ctx, ap.SyncProducer, msg, func(msg *sarama.ProducerMessage) (int32, int64, error) {
+		return ap.SyncProducer.SendMessageWithSchema(msg, reg.id, reg.version)
+	})
+}
+
+func (ap *AvroSyncProducer) parse(schema string) (avro.Schema, error) {
+	if parsed, ok := ap.schemas.Load(schema); ok {
+		return parsed.(avro.Schema), nil
+	}
+
+	// each schema gets its own cache of named types, so that it cannot refer
+	// to types defined by another schema
+	parsed, err := avro.ParseWithCache(schema, "", &avro.SchemaCache{})
+	if err != nil {
+		return nil, sarama.ConfigurationError(fmt.Sprintf("invalid Avro schema: %v", err))
+	}
+	ap.schemas.Store(schema, parsed)
+	return parsed, nil
+}
+
+// register registers schema under subject, which is a no-op if it already
+// is, and returns its ID and version.
+func (ap *AvroSyncProducer) register(ctx context.Context, subject, schema string) (avroRegistration, error) {
+	key := avroSubjectSchema{subject: subject, schema: schema}
+	if reg, ok := ap.registered.Load(key); ok {
+		return reg.(avroRegistration), nil
+	}
+
+	body, err := json.Marshal(struct {
+		Schema string `json:"schema"`
+	}{schema})
+	if err != nil {
+		return avroRegistration{}, err
+	}
+	path := "/subjects/" + url.PathEscape(subject)
+	status, err := ap.registry.do(ctx, http.MethodPost, path+"/versions", body, &struct{}{})
+	if err != nil {
+		return avroRegistration{}, err
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusConflict:
+		return avroRegistration{}, fmt.Errorf("%w: subject %s", ErrSchemaIncompatible, subject)
+	default:
+		return avroRegistration{}, fmt.Errorf("kafka: schema registry returned %d registering a schema for subject %s", status, subject)
+	}
+
+	// registering only returns the ID, looking the schema up also returns
+	// the version
+	var result struct {
+		ID      int `json:"id"`
+		Version int `json:"version"`
+	}
+	status, err = ap.registry.do(ctx, http.MethodPost, path, body, &result)
+	if err != nil {
+		return avroRegistration{}, err
+	}
+	if status != http.StatusOK {
+		return avroRegistration{}, fmt.Errorf("kafka: schema registry returned %d looking up a schema for subject %s", status, subject)
+	}
+
+	reg := avroRegistration{id: result.ID, version: result.Version}
+	ap.registered.Store(key, reg)
+	return reg, nil
+}
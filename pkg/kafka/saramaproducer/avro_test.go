@@ -0,0 +1,56 @@
+package saramaproducer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvroSyncProducer_SendMessageAvro(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"id": 7, "version": 3}`))
+	}))
+	t.Cleanup(registry.Close)
+
+	recorder := &valueRecorder{}
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	inner, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, inner.Close()) })
+
+	// bytes defaults are ISO-8859-1 strings, "ÿ" being the byte 0xff
+	schema := `{
+		"type": "record",
+		"name": "event",
+		"fields": [
+			{"name": "name", "type": "string"},
+			{"name": "payload", "type": "bytes"},
+			{"name": "marker", "type": "bytes", "default": "ÿ"}
+		]
+	}`
+	value := map[string]interface{}{"name": "foo", "payload": []byte{1, 2}}
+	_, _, err = NewAvroSyncProducer(inner, registry.URL).SendMessageAvro(context.Background(), testTopic, nil, value, schema)
+	require.NoError(t, err)
+
+	want := []byte{
+		0, 0, 0, 0, 7, // wire format header with the registered schema ID
+		6, 'f', 'o', 'o',
+		4, 1, 2,
+		2, 0xff,
+	}
+	require.Equal(t, want, recorder.value)
+}
+
+func TestAvroSyncProducer_InvalidSchema(t *testing.T) {
+	producer := NewAvroSyncProducer(&stubSyncProducer{}, "http://localhost")
+
+	_, _, err := producer.SendMessageAvro(context.Background(), testTopic, nil, "foo", `{"type": "unknown"}`)
+	var configErr sarama.ConfigurationError
+	require.ErrorAs(t, err, &configErr)
+}
@@ -0,0 +1,77 @@
+package saramaproducer
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// BarrierHeader is the record header marking the sentinel messages sent by
+// SyncProducer.Barrier. Its value identifies the barrier, so that a consumer
+// can tell when it has seen a given barrier on every partition.
+const BarrierHeader = "x-barrier"
+
+// barrierSeq distinguishes barriers issued within the same millisecond.
+var barrierSeq uint32
+
+// markActive records the partition msg was acknowledged on, and its offset
+// if it is the highest acknowledged there so far.
+func (sp *syncProducer) markActive(msg *sarama.ProducerMessage) {
+	sp.topicsLock.Lock()
+	defer sp.topicsLock.Unlock()
+
+	partitions := sp.activePartitions[msg.Topic]
+	if partitions == nil {
+		partitions = make(map[int32]int64)
+		sp.activePartitions[msg.Topic] = partitions
+	}
+	if highest, ok := partitions[msg.Partition]; !ok || msg.Offset > highest {
+		partitions[msg.Partition] = msg.Offset
+	}
+}
+
+func (sp *syncProducer) Barrier(ctx context.Context) (map[string]map[int32]int64, error) {
+	id := []byte(strconv.FormatInt(time.Now().UnixMilli(), 10) + "-" +
+		strconv.FormatUint(uint64(atomic.AddUint32(&barrierSeq, 1)), 10))
+
+	sp.topicsLock.Lock()
+	var (
+		sentinels []*sarama.ProducerMessage
+		opts      []messageOptions
+	)
+	for topic, partitions := range sp.activePartitions {
+		for partition := range partitions {
+			sentinels = append(sentinels, &sarama.ProducerMessage{
+				Topic:     topic,
+				Partition: partition,
+				Headers:   []sarama.RecordHeader{{Key: []byte(BarrierHeader), Value: id}},
+			})
+			opts = append(opts, messageOptions{manualPartition: true})
+		}
+	}
+	sp.topicsLock.Unlock()
+
+	offsets := make(map[string]map[int32]int64)
+	if len(sentinels) == 0 {
+		return offsets, nil
+	}
+
+	// sentinels skip prepare, so that global headers, the header interceptor
+	// and the key and schema checks leave them as they are
+	err := runWithContext(ctx, func() error {
+		return sp.sendMessages(sentinels, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range sentinels {
+		if offsets[msg.Topic] == nil {
+			offsets[msg.Topic] = make(map[int32]int64)
+		}
+		offsets[msg.Topic][msg.Partition] = msg.Offset
+	}
+	return offsets, nil
+}
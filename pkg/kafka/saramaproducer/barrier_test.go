@@ -0,0 +1,34 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncProducer_BarrierSkipsPrepare(t *testing.T) {
+	recorder := &headerRecorder{}
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+
+	producer.AddHeadersGlobally(sarama.RecordHeader{Key: []byte("global"), Value: []byte("g")})
+	producer.SetHeaderInterceptor(func(_ string, existing []sarama.RecordHeader) []sarama.RecordHeader {
+		return append(existing, sarama.RecordHeader{Key: []byte("intercepted"), Value: []byte("i")})
+	})
+	offsets, err := producer.Barrier(context.Background())
+	require.NoError(t, err)
+	require.Len(t, offsets[testTopic], 1)
+
+	headers := recorder.sent()
+	require.Len(t, headers, 1)
+	require.Contains(t, headers, BarrierHeader)
+}
@@ -0,0 +1,156 @@
+package saramaproducer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func (sp *syncProducer) SendMessageBatch(msgs []*sarama.ProducerMessage) ([]ProducerResult, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	conf := sp.conf
+	if !conf.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, sarama.ConfigurationError("SendMessageBatch requires Kafka at least v0.11")
+	}
+	if conf.Producer.Idempotent {
+		return nil, sarama.ConfigurationError("SendMessageBatch cannot be used with an idempotent producer")
+	}
+
+	topic, partition := msgs[0].Topic, msgs[0].Partition
+	for _, msg := range msgs {
+		if msg.Topic != topic || msg.Partition != partition {
+			return nil, sarama.ConfigurationError("SendMessageBatch requires all messages to have the same topic and partition")
+		}
+		if err := sp.prepare(msg, takeMessageOptions(msg)); err != nil {
+			return nil, err
+		}
+	}
+
+	batch, size, err := newRecordBatch(conf, msgs)
+	if err != nil {
+		return nil, err
+	}
+	if size > conf.Producer.MaxMessageBytes {
+		return nil, fmt.Errorf("%w: batch of %d messages is larger than Producer.MaxMessageBytes", sarama.ErrMessageSizeTooLarge, len(msgs))
+	}
+
+	leader, err := sp.client.Leader(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	request := newProduceRequest(conf)
+	request.AddBatch(topic, partition, batch)
+	response, err := leader.Produce(request)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ProducerResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = ProducerResult{Msg: msg, Partition: partition, Offset: -1, Attempts: 1}
+	}
+	if response == nil {
+		// RequiredAcks is NoResponse
+		return results, nil
+	}
+
+	block := response.GetBlock(topic, partition)
+	if block == nil {
+		return nil, sarama.ErrIncompleteResponse
+	}
+	if !errors.Is(block.Err, sarama.ErrNoError) {
+		for i := range results {
+			results[i].Err = block.Err
+		}
+		return results, block.Err
+	}
+	for i, msg := range msgs {
+		msg.Offset = block.Offset + int64(i)
+		if !block.Timestamp.IsZero() {
+			msg.Timestamp = block.Timestamp
+		}
+		results[i].Offset = msg.Offset
+	}
+	return results, nil
+}
+
+// newRecordBatch encodes msgs into a single v2 RecordBatch compressed as
+// configured, returning it with an estimate of its encoded size.
+func newRecordBatch(conf *sarama.Config, msgs []*sarama.ProducerMessage) (*sarama.RecordBatch, int, error) {
+	now := time.Now().Truncate(time.Millisecond)
+	timestamp := func(msg *sarama.ProducerMessage) time.Time {
+		if msg.Timestamp.IsZero() {
+			return now
+		}
+		return msg.Timestamp.Truncate(time.Millisecond)
+	}
+
+	batch := &sarama.RecordBatch{
+		Version:          2,
+		Codec:            conf.Producer.Compression,
+		CompressionLevel: conf.Producer.CompressionLevel,
+		FirstTimestamp:   timestamp(msgs[0]),
+		ProducerID:       -1,
+		ProducerEpoch:    -1,
+		LastOffsetDelta:  int32(len(msgs) - 1),
+	}
+	batch.MaxTimestamp = batch.FirstTimestamp
+	size := recordBatchOverhead
+	for i, msg := range msgs {
+		var key, value []byte
+		var err error
+		if msg.Key != nil {
+			if key, err = msg.Key.Encode(); err != nil {
+				return nil, 0, err
+			}
+		}
+		if msg.Value != nil {
+			if value, err = msg.Value.Encode(); err != nil {
+				return nil, 0, err
+			}
+		}
+		ts := timestamp(msg)
+		if ts.After(batch.MaxTimestamp) {
+			batch.MaxTimestamp = ts
+		}
+		headers := make([]*sarama.RecordHeader, len(msg.Headers))
+		for j := range msg.Headers {
+			headers[j] = &msg.Headers[j]
+		}
+		batch.Records = append(batch.Records, &sarama.Record{
+			Key:            key,
+			Value:          value,
+			Headers:        headers,
+			TimestampDelta: ts.Sub(batch.FirstTimestamp),
+			OffsetDelta:    int64(i),
+		})
+		size += msg.ByteSize(2)
+	}
+	return batch, size, nil
+}
+
+// newProduceRequest returns an empty produce request of the highest version
+// supported by conf.Version, like the async producer builds.
+func newProduceRequest(conf *sarama.Config) *sarama.ProduceRequest {
+	request := &sarama.ProduceRequest{
+		RequiredAcks: conf.Producer.RequiredAcks,
+		Timeout:      int32(conf.Producer.Timeout / time.Millisecond),
+	}
+	switch {
+	case conf.Version.IsAtLeast(sarama.V2_1_0_0):
+		request.Version = 7
+	case conf.Version.IsAtLeast(sarama.V2_0_0_0):
+		request.Version = 6
+	case conf.Version.IsAtLeast(sarama.V1_0_0_0):
+		request.Version = 5
+	case conf.Version.IsAtLeast(sarama.V0_11_0_0):
+		request.Version = 3
+	case conf.Version.IsAtLeast(sarama.V0_10_0_0):
+		request.Version = 2
+	}
+	return request
+}
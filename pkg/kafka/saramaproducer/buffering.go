@@ -0,0 +1,305 @@
+package saramaproducer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// ErrBufferFull is returned by a SyncProducer created with
+// NewBufferingSyncProducer when a message cannot be produced and its buffer
+// has no room left for it.
+var ErrBufferFull = errors.New("kafka: producer buffer is full")
+
+const (
+	defaultMaxBuffered         = 1024
+	defaultBufferFlushInterval = time.Second
+)
+
+type bufferingSyncProducer struct {
+	decorator
+
+	lock sync.Mutex
+	// ring holds the buffered messages in the order they were sent, starting
+	// at head
+	ring  []*sarama.ProducerMessage
+	head  int
+	count int
+	// flushing is the number of messages taken out of the ring by a flush in
+	// progress, which still count against its capacity
+	flushing int
+	// manual holds the partitions chosen by the caller for buffered messages,
+	// as sent with SendMessageToPartition or SendMessageZeroCopy
+	manual map[*sarama.ProducerMessage]int32
+
+	closing chan struct{}
+	done    chan struct{}
+	// closeOnce runs the shutdown of Close once, closeErr is its result
+	closeOnce sync.Once
+	closeErr  error
+
+	logger log.Logger
+}
+
+// NewBufferingSyncProducer returns a SyncProducer that rides out transient
+// broker outages by buffering messages in memory. A message that inner fails
+// to produce because no broker or leader is available, or because of a
+// network error or timeout, is buffered and reported as sent with partition
+// and offset -1; other errors are returned as usual. Every flushInterval the
+// buffered messages are resent to inner, and those that fail again stay
+// buffered. flushInterval defaults to 1s if it is not positive. While
+// messages are buffered, new messages are buffered behind them so that their
+// order is kept. Buffered messages that fail with any other error are logged
+// and dropped. Up to maxBuffered messages are held, 1024 if it is not
+// positive; beyond that, messages fail with ErrBufferFull. Buffered messages
+// are lost if the process exits; Close makes a last attempt to send them and
+// reports those it could not. Flushes and dropped messages are logged to the
+// logger of inner.
+//
+// Every sending method goes through the buffer, so that the order of the
+// messages is kept whichever method they are sent with; all other methods
+// are forwarded to inner unchanged.
+func NewBufferingSyncProducer(inner SyncProducer, maxBuffered int, flushInterval time.Duration) SyncProducer {
+	if maxBuffered <= 0 {
+		maxBuffered = defaultMaxBuffered
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultBufferFlushInterval
+	}
+	bp := &bufferingSyncProducer{
+		ring:    make([]*sarama.ProducerMessage, maxBuffered),
+		manual:  make(map[*sarama.ProducerMessage]int32),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	bp.decorator = newDecorator(inner, bp)
+	bp.logger = bp.decorator.coreLogger()
+	go bp.flushEvery(flushInterval)
+	return bp
+}
+
+// isTransientProduceError reports whether err suggests that the cluster is
+// temporarily unreachable, so that the message may succeed later.
+func isTransientProduceError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var kErr sarama.KError
+	if errors.As(err, &kErr) {
+		switch kErr {
+		case sarama.ErrLeaderNotAvailable, sarama.ErrNotLeaderForPartition, sarama.ErrRequestTimedOut,
+			sarama.ErrBrokerNotAvailable, sarama.ErrNetworkException, sarama.ErrNotEnoughReplicas,
+			sarama.ErrNotEnoughReplicasAfterAppend, sarama.ErrKafkaStorageError:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, sarama.ErrOutOfBrokers) || errors.Is(err, sarama.ErrNotConnected)
+}
+
+func (bp *bufferingSyncProducer) buffering() bool {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	return bp.count+bp.flushing > 0
+}
+
+// push appends msg to the ring. bp.lock must be held.
+func (bp *bufferingSyncProducer) push(msg *sarama.ProducerMessage) error {
+	if bp.count+bp.flushing == len(bp.ring) {
+		return ErrBufferFull
+	}
+	bp.ring[(bp.head+bp.count)%len(bp.ring)] = msg
+	bp.count++
+	if isManualPartition(msg) {
+		bp.manual[msg] = msg.Partition
+	}
+	msg.Partition, msg.Offset = -1, -1
+	return nil
+}
+
+// takeAll removes and returns all buffered messages. bp.lock must be held.
+func (bp *bufferingSyncProducer) takeAll() []*sarama.ProducerMessage {
+	msgs := make([]*sarama.ProducerMessage, bp.count)
+	for i := range msgs {
+		idx := (bp.head + i) % len(bp.ring)
+		msgs[i] = bp.ring[idx]
+		bp.ring[idx] = nil
+	}
+	bp.head, bp.count = 0, 0
+	return msgs
+}
+
+func (bp *bufferingSyncProducer) flushEvery(interval time.Duration) {
+	defer close(bp.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bp.flush()
+		case <-bp.closing:
+			return
+		}
+	}
+}
+
+// flush resends the buffered messages, keeping those that fail again with a
+// transient error ahead of the messages buffered in the meantime. Messages
+// that fail with any other error would never succeed, so they are dropped.
+func (bp *bufferingSyncProducer) flush() {
+	bp.lock.Lock()
+	msgs := bp.takeAll()
+	bp.flushing = len(msgs)
+	for _, msg := range msgs {
+		if partition, ok := bp.manual[msg]; ok {
+			setManualPartition(msg, partition)
+		}
+	}
+	bp.lock.Unlock()
+	if len(msgs) == 0 {
+		return
+	}
+
+	err := bp.SyncProducer.SendMessages(msgs)
+	for _, msg := range msgs {
+		takeMessageOptions(msg)
+	}
+	var failed []*sarama.ProducerMessage
+	var pErrs ProducerErrors
+	switch {
+	case err == nil:
+		level.Info(bp.logger).Log("msg", "flushed buffered messages", "count", len(msgs))
+	case !errors.As(err, &pErrs):
+		// the batch failed as a whole
+		if !isTransientProduceError(err) {
+			level.Error(bp.logger).Log("msg", "dropping buffered messages that failed to flush", "count", len(msgs), "err", err)
+			break
+		}
+		failed = msgs
+		level.Warn(bp.logger).Log("msg", "failed to flush buffered messages", "count", len(msgs), "err", err)
+	default:
+		byMsg := make(map[*sarama.ProducerMessage]error, len(pErrs))
+		for _, pErr := range pErrs {
+			byMsg[pErr.Msg] = pErr.Err
+		}
+		dropped := 0
+		for _, msg := range msgs {
+			msgErr, ok := byMsg[msg]
+			switch {
+			case !ok:
+			case isTransientProduceError(msgErr):
+				failed = append(failed, msg)
+			default:
+				dropped++
+				level.Error(bp.logger).Log("msg", "dropping buffered message that failed to flush", "topic", msg.Topic, "err", msgErr)
+			}
+		}
+		level.Warn(bp.logger).Log("msg", "flushed some buffered messages", "flushed", len(msgs)-len(failed)-dropped, "dropped", dropped, "count", len(msgs), "err", err)
+	}
+
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.flushing = 0
+	kept := make(map[*sarama.ProducerMessage]struct{}, len(failed))
+	for _, msg := range failed {
+		kept[msg] = struct{}{}
+	}
+	for _, msg := range msgs {
+		if _, ok := kept[msg]; !ok {
+			delete(bp.manual, msg)
+		}
+	}
+	for _, msg := range append(failed, bp.takeAll()...) {
+		_ = bp.push(msg)
+	}
+}
+
+func (bp *bufferingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if !bp.buffering() {
+		partition, offset, err = bp.SyncProducer.SendMessage(msg)
+		if err == nil || !isTransientProduceError(err) {
+			return partition, offset, err
+		}
+		level.Warn(bp.logger).Log("msg", "buffering message after it failed to send", "topic", msg.Topic, "err", err)
+	}
+
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	if err := bp.push(msg); err != nil {
+		return -1, -1, err
+	}
+	return -1, -1, nil
+}
+
+func (bp *bufferingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var pErrs ProducerErrors
+	if !bp.buffering() {
+		err := bp.SyncProducer.SendMessages(msgs)
+		if err == nil {
+			return nil
+		}
+		if !errors.As(err, &pErrs) {
+			if !isTransientProduceError(err) {
+				return err
+			}
+			// the batch failed as a whole
+			pErrs = make(ProducerErrors, len(msgs))
+			for i, msg := range msgs {
+				pErrs[i] = &ProducerError{Msg: msg, Err: err, BatchIndex: i}
+			}
+		}
+	} else {
+		pErrs = make(ProducerErrors, len(msgs))
+		for i, msg := range msgs {
+			pErrs[i] = &ProducerError{Msg: msg, BatchIndex: i}
+		}
+	}
+
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	var remaining ProducerErrors
+	for _, pErr := range pErrs {
+		if pErr.Err != nil && !isTransientProduceError(pErr.Err) {
+			remaining = append(remaining, pErr)
+			continue
+		}
+		if err := bp.push(pErr.Msg); err != nil {
+			remaining = append(remaining, &ProducerError{Msg: pErr.Msg, Err: err, BatchIndex: pErr.BatchIndex})
+		}
+	}
+	if len(remaining) > 0 {
+		return remaining
+	}
+	return nil
+}
+
+func (bp *bufferingSyncProducer) Close() error {
+	bp.closeOnce.Do(func() { bp.closeErr = bp.close() })
+	return bp.closeErr
+}
+
+func (bp *bufferingSyncProducer) close() error {
+	close(bp.closing)
+	<-bp.done
+	bp.flush()
+
+	bp.lock.Lock()
+	lost := bp.count
+	bp.lock.Unlock()
+
+	err := bp.SyncProducer.Close()
+	if lost > 0 {
+		level.Warn(bp.logger).Log("msg", "dropping buffered messages on close", "count", lost)
+		return errors.Join(fmt.Errorf("kafka: %d buffered messages were never produced", lost), err)
+	}
+	return err
+}
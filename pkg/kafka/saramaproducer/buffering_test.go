@@ -0,0 +1,151 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBufferingSyncProducer_DefaultMaxBuffered(t *testing.T) {
+	producer := NewBufferingSyncProducer(&rejectingSyncProducer{}, 0, time.Hour)
+	require.Len(t, producer.(*bufferingSyncProducer).ring, defaultMaxBuffered)
+	require.NoError(t, producer.Close())
+}
+
+// blockingSyncProducer fails SendMessage with a transient error and blocks
+// SendMessages until release is closed, recording the messages sent.
+type blockingSyncProducer struct {
+	SyncProducer
+	entered chan struct{}
+	release chan struct{}
+	sent    []*sarama.ProducerMessage
+}
+
+func (bp *blockingSyncProducer) SendMessage(*sarama.ProducerMessage) (int32, int64, error) {
+	return -1, -1, sarama.ErrOutOfBrokers
+}
+
+func (bp *blockingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	bp.entered <- struct{}{}
+	<-bp.release
+	bp.sent = append(bp.sent, msgs...)
+	return nil
+}
+
+func (bp *blockingSyncProducer) Close() error {
+	return nil
+}
+
+func TestBufferingSyncProducer_SendDuringFlush(t *testing.T) {
+	inner := &blockingSyncProducer{entered: make(chan struct{}, 2), release: make(chan struct{})}
+	producer := NewBufferingSyncProducer(inner, 2, time.Hour)
+	bp := producer.(*bufferingSyncProducer)
+
+	first, second, third := newTestMessage(), newTestMessage(), newTestMessage()
+	_, _, err := producer.SendMessage(first)
+	require.NoError(t, err)
+
+	flushed := make(chan struct{})
+	go func() {
+		bp.flush()
+		close(flushed)
+	}()
+	<-inner.entered
+
+	// the flush holds no lock while inner sends, and its message still counts
+	// against the capacity
+	_, _, err = producer.SendMessage(second)
+	require.NoError(t, err)
+	_, _, err = producer.SendMessage(third)
+	require.ErrorIs(t, err, ErrBufferFull)
+
+	close(inner.release)
+	<-flushed
+	require.NoError(t, producer.Close())
+	require.Equal(t, []*sarama.ProducerMessage{first, second}, inner.sent)
+}
+
+// rejectingSyncProducer fails SendMessage with a transient error and
+// SendMessages with errs, indexed by message.
+type rejectingSyncProducer struct {
+	SyncProducer
+	errs map[*sarama.ProducerMessage]error
+}
+
+func (rp *rejectingSyncProducer) SendMessage(*sarama.ProducerMessage) (int32, int64, error) {
+	return -1, -1, sarama.ErrOutOfBrokers
+}
+
+func (rp *rejectingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var pErrs ProducerErrors
+	for i, msg := range msgs {
+		if err := rp.errs[msg]; err != nil {
+			pErrs = append(pErrs, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+		}
+	}
+	if len(pErrs) > 0 {
+		return pErrs
+	}
+	return nil
+}
+
+func (rp *rejectingSyncProducer) Close() error {
+	return nil
+}
+
+func TestBufferingSyncProducer_FlushDropsPermanentFailures(t *testing.T) {
+	transient, permanent, sent := newTestMessage(), newTestMessage(), newTestMessage()
+	inner := &rejectingSyncProducer{errs: map[*sarama.ProducerMessage]error{
+		transient: sarama.ErrNotEnoughReplicas,
+		permanent: sarama.ErrMessageSizeTooLarge,
+	}}
+	producer := NewBufferingSyncProducer(inner, 3, time.Hour)
+	bp := producer.(*bufferingSyncProducer)
+
+	for _, msg := range []*sarama.ProducerMessage{transient, permanent, sent} {
+		_, _, err := producer.SendMessage(msg)
+		require.NoError(t, err)
+	}
+
+	bp.flush()
+	bp.lock.Lock()
+	require.Equal(t, []*sarama.ProducerMessage{transient}, bp.takeAll())
+	bp.lock.Unlock()
+	require.NoError(t, producer.Close())
+}
+
+func TestBufferingSyncProducer_SendMessageToPartitionIsBuffered(t *testing.T) {
+	producer := NewBufferingSyncProducer(&rejectingSyncProducer{}, 2, time.Hour)
+	bp := producer.(*bufferingSyncProducer)
+
+	_, _, err := producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+	offset, err := producer.SendMessageToPartition(context.Background(), testTopic, 1, nil, []byte("foo"))
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), offset)
+
+	// the partition is kept for when the message is flushed
+	bp.lock.Lock()
+	msgs := bp.takeAll()
+	partition, ok := bp.manual[msgs[1]]
+	bp.lock.Unlock()
+	require.Len(t, msgs, 2)
+	require.True(t, ok)
+	require.Equal(t, int32(1), partition)
+	require.NoError(t, producer.Close())
+}
+
+func TestBufferingSyncProducer_CloseTwice(t *testing.T) {
+	msg := newTestMessage()
+	inner := &rejectingSyncProducer{errs: map[*sarama.ProducerMessage]error{msg: sarama.ErrNotEnoughReplicas}}
+	producer := NewBufferingSyncProducer(inner, 2, time.Hour)
+	_, _, err := producer.SendMessage(msg)
+	require.NoError(t, err)
+
+	err = producer.Close()
+	require.ErrorContains(t, err, "1 buffered messages were never produced")
+	require.Equal(t, err, producer.Close())
+}
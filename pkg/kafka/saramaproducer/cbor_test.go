@@ -0,0 +1,36 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCBORSyncProducer_SendMessageCBOR(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewCBORSyncProducer(inner)
+
+	type reading struct {
+		Sensor string  `cbor:"sensor"`
+		Value  float64 `cbor:"value"`
+	}
+	_, _, err := producer.SendMessageCBOR(context.Background(), testTopic, []byte("key"), reading{Sensor: "t1", Value: 21.5})
+	require.NoError(t, err)
+
+	sent := recorder.messages()
+	require.Len(t, sent, 1)
+	msg := consumed(t, sent[0])
+	require.Equal(t, []byte("key"), msg.Key)
+	require.Equal(t, []*sarama.RecordHeader{{Key: []byte(ContentTypeHeader), Value: []byte("application/cbor")}}, msg.Headers)
+	var decoded reading
+	require.NoError(t, cbor.Unmarshal(msg.Value, &decoded))
+	require.Equal(t, reading{Sensor: "t1", Value: 21.5}, decoded)
+
+	// channels have no CBOR encoding
+	_, _, err = producer.SendMessageCBOR(context.Background(), testTopic, nil, make(chan int))
+	require.ErrorContains(t, err, "failed to CBOR-encode message value")
+	require.Len(t, recorder.messages(), 1)
+}
@@ -0,0 +1,109 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/IBM/sarama"
+)
+
+// ChecksumHeader is the record header in which a SyncProducer created with
+// NewChecksumSyncProducer stores the CRC32C checksum of the message value, as
+// eight lowercase hex digits.
+const ChecksumHeader = "x-payload-checksum"
+
+// ErrChecksumMismatch is returned by VerifyChecksumHeader when a message's
+// value does not match its checksum header, or the header is missing.
+var ErrChecksumMismatch = errors.New("kafka: payload checksum mismatch")
+
+type checksumSyncProducer struct {
+	decorator
+}
+
+// NewChecksumSyncProducer returns a SyncProducer that computes the CRC32C
+// (Castagnoli) checksum of each message value and stores it in the
+// ChecksumHeader header before handing the message to inner, replacing any
+// existing header of that name. Consumers can check it with
+// VerifyChecksumHeader. Unlike the batch CRC verified by the broker, this
+// checksum covers the payload end to end, including any transformation by
+// proxies or mirroring tools.
+func NewChecksumSyncProducer(inner SyncProducer) SyncProducer {
+	cp := &checksumSyncProducer{}
+	cp.decorator = newPreSendDecorator(inner, cp)
+	return cp
+}
+
+func payloadChecksum(value []byte) []byte {
+	sum := crc32.Checksum(value, castagnoliTable)
+	return []byte(fmt.Sprintf("%08x", sum))
+}
+
+func (cp *checksumSyncProducer) checksum(msg *sarama.ProducerMessage) error {
+	var value []byte
+	if msg.Value != nil {
+		var err error
+		if value, err = msg.Value.Encode(); err != nil {
+			return err
+		}
+	}
+	sum := payloadChecksum(value)
+
+	for i, h := range msg.Headers {
+		if string(h.Key) == ChecksumHeader {
+			msg.Headers[i].Value = sum
+			return nil
+		}
+	}
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(ChecksumHeader), Value: sum})
+	return nil
+}
+
+func (cp *checksumSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if err := cp.checksum(msg); err != nil {
+		return -1, -1, err
+	}
+	return cp.SyncProducer.SendMessage(msg)
+}
+
+func (cp *checksumSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var failed ProducerErrors
+	prepared := make([]*sarama.ProducerMessage, 0, len(msgs))
+	positions := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		if err := cp.checksum(msg); err != nil {
+			failed = append(failed, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+			continue
+		}
+		prepared = append(prepared, msg)
+		positions = append(positions, i)
+	}
+
+	var err error
+	if len(prepared) > 0 {
+		err = cp.SyncProducer.SendMessages(prepared)
+	}
+	return mergeProducerErrors(failed, err, positions)
+}
+
+// VerifyChecksumHeader recomputes the CRC32C checksum of msg.Value and
+// compares it with the ChecksumHeader header added by a SyncProducer created
+// with NewChecksumSyncProducer. It returns ErrChecksumMismatch if they differ
+// or the header is missing.
+func VerifyChecksumHeader(msg *sarama.ConsumerMessage) error {
+	for _, h := range msg.Headers {
+		if h == nil || string(h.Key) != ChecksumHeader {
+			continue
+		}
+		if _, err := hex.DecodeString(string(h.Value)); err != nil || len(h.Value) != 8 {
+			return fmt.Errorf("%w: malformed %s header %q", ErrChecksumMismatch, ChecksumHeader, h.Value)
+		}
+		if sum := payloadChecksum(msg.Value); !bytes.Equal(bytes.ToLower(h.Value), sum) {
+			return fmt.Errorf("%w: %s/%d at offset %d has checksum %s, header says %s", ErrChecksumMismatch, msg.Topic, msg.Partition, msg.Offset, sum, h.Value)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s header missing", ErrChecksumMismatch, ChecksumHeader)
+}
@@ -0,0 +1,51 @@
+package saramaproducer
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumSyncProducer_SendMessagesSendsChecksummedMessages(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewChecksumSyncProducer(inner)
+
+	msgs := []*sarama.ProducerMessage{newTestMessage(), newTestMessage(), newTestMessage()}
+	// fails to encode, so no checksum can be computed
+	msgs[1].Value = TypedKey{}
+	err := producer.SendMessages(msgs)
+
+	var pErrs ProducerErrors
+	require.ErrorAs(t, err, &pErrs)
+	require.Len(t, pErrs, 1)
+	require.Same(t, msgs[1], pErrs[0].Msg)
+	require.Equal(t, 1, pErrs[0].BatchIndex)
+	require.ErrorIs(t, pErrs[0], ErrKeyNotSerialized)
+	for _, msg := range []*sarama.ProducerMessage{msgs[0], msgs[2]} {
+		require.True(t, recorder.wasSent(msg))
+		require.True(t, hasHeader(msg.Headers, []byte(ChecksumHeader)))
+	}
+}
+
+func TestVerifyChecksumHeader(t *testing.T) {
+	inner, _ := newRecordingTestSyncProducer(t)
+	producer := NewChecksumSyncProducer(inner)
+
+	msg := newTestMessage()
+	_, _, err := producer.SendMessage(msg)
+	require.NoError(t, err)
+	require.NoError(t, VerifyChecksumHeader(consumed(t, msg)))
+
+	tampered := consumed(t, msg)
+	tampered.Value = append(tampered.Value, '!')
+	require.ErrorIs(t, VerifyChecksumHeader(tampered), ErrChecksumMismatch)
+
+	malformed := consumed(t, msg)
+	malformed.Headers[0].Value = []byte("not hex!")
+	require.ErrorIs(t, VerifyChecksumHeader(malformed), ErrChecksumMismatch)
+
+	missing := consumed(t, msg)
+	missing.Headers = nil
+	require.ErrorIs(t, VerifyChecksumHeader(missing), ErrChecksumMismatch)
+}
@@ -0,0 +1,142 @@
+package saramaproducer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+type coalescedSend struct {
+	msg  *sarama.ProducerMessage
+	done chan error
+}
+
+type coalescingSyncProducer struct {
+	decorator
+	window time.Duration
+
+	lock    sync.Mutex
+	pending map[string][]*coalescedSend // by topic
+	timers  map[string]*time.Timer      // flushing pending, by topic
+	closed  bool
+	// flushes counts the scheduled and running flushes, Close waits for them
+	flushes sync.WaitGroup
+}
+
+// NewCoalescingPartitionSyncProducer returns a SyncProducer that holds each
+// message sent with SendMessage for up to window and hands all messages
+// collected for the same topic in that time to inner in a single
+// SendMessages call. inner partitions them as usual, so messages for the same
+// topic-partition end up in one RecordBatch instead of one batch per caller,
+// while every caller still waits only for its own message's acknowledgement.
+// Each send is delayed by up to window; a window of zero or less disables
+// coalescing.
+//
+// SendMessages calls already form a batch and are forwarded directly. Close
+// flushes the collected messages right away and waits for every flush to
+// return before closing inner.
+func NewCoalescingPartitionSyncProducer(inner SyncProducer, window time.Duration) SyncProducer {
+	cp := &coalescingSyncProducer{
+		window:  window,
+		pending: make(map[string][]*coalescedSend),
+		timers:  make(map[string]*time.Timer),
+	}
+	cp.decorator = newDecorator(inner, cp)
+	return cp
+}
+
+func (cp *coalescingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if cp.window <= 0 {
+		return cp.SyncProducer.SendMessage(msg)
+	}
+
+	send := &coalescedSend{msg: msg, done: make(chan error, 1)}
+	cp.lock.Lock()
+	if cp.closed {
+		cp.lock.Unlock()
+		return -1, -1, sarama.ErrShuttingDown
+	}
+	sends, ok := cp.pending[msg.Topic]
+	cp.pending[msg.Topic] = append(sends, send)
+	if !ok {
+		topic := msg.Topic
+		cp.flushes.Add(1)
+		cp.timers[topic] = time.AfterFunc(cp.window, func() { cp.flush(topic) })
+	}
+	cp.lock.Unlock()
+
+	if err := <-send.done; err != nil {
+		return -1, -1, err
+	}
+	return msg.Partition, msg.Offset, nil
+}
+
+// flush sends the messages collected for topic and reports the outcome to
+// each of their senders.
+func (cp *coalescingSyncProducer) flush(topic string) {
+	defer cp.flushes.Done()
+
+	cp.lock.Lock()
+	sends := cp.pending[topic]
+	delete(cp.pending, topic)
+	delete(cp.timers, topic)
+	cp.lock.Unlock()
+
+	msgs := make([]*sarama.ProducerMessage, len(sends))
+	positions := make(map[*sarama.ProducerMessage]int, len(sends))
+	for i, send := range sends {
+		msgs[i] = send.msg
+		positions[send.msg] = i
+	}
+
+	errs := make([]error, len(sends))
+	if err := cp.SyncProducer.SendMessages(msgs); err != nil {
+		// errors are matched to their senders by message, or by batch index
+		// if they carry none; an error matching no sender is reported to
+		// every sender not failed otherwise
+		var pErrs ProducerErrors
+		matched := errors.As(err, &pErrs)
+		for _, pErr := range pErrs {
+			i, ok := positions[pErr.Msg]
+			if !ok && pErr.Msg == nil && pErr.BatchIndex >= 0 && pErr.BatchIndex < len(errs) {
+				i, ok = pErr.BatchIndex, true
+			}
+			if !ok {
+				matched = false
+				continue
+			}
+			errs[i] = pErr.Err
+		}
+		if !matched {
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = err
+				}
+			}
+		}
+	}
+	for i, send := range sends {
+		send.done <- errs[i]
+	}
+}
+
+func (cp *coalescingSyncProducer) Close() error {
+	cp.lock.Lock()
+	cp.closed = true
+	var topics []string
+	for topic, timer := range cp.timers {
+		// a timer that cannot be stopped has fired and flushes on its own
+		if timer.Stop() {
+			topics = append(topics, topic)
+		}
+	}
+	cp.lock.Unlock()
+
+	for _, topic := range topics {
+		cp.flush(topic)
+	}
+	cp.flushes.Wait()
+	return cp.SyncProducer.Close()
+}
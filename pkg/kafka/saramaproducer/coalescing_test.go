@@ -0,0 +1,108 @@
+package saramaproducer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// batchRecordingSyncProducer records the batches handed to SendMessages and
+// fails the messages in fail, reporting them at a bogus batch index.
+type batchRecordingSyncProducer struct {
+	SyncProducer
+	fail map[*sarama.ProducerMessage]error
+
+	lock    sync.Mutex
+	batches [][]*sarama.ProducerMessage
+	closed  bool
+}
+
+func (bp *batchRecordingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	bp.lock.Lock()
+	bp.batches = append(bp.batches, msgs)
+	bp.lock.Unlock()
+
+	var errs ProducerErrors
+	for _, msg := range msgs {
+		if err, ok := bp.fail[msg]; ok {
+			errs = append(errs, &ProducerError{Msg: msg, Err: err, BatchIndex: len(msgs)})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (bp *batchRecordingSyncProducer) Close() error {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.closed = true
+	return nil
+}
+
+func TestCoalescingPartitionSyncProducer_ConcurrentSends(t *testing.T) {
+	const senders = 10
+
+	msgs := make([]*sarama.ProducerMessage, senders)
+	for i := range msgs {
+		msgs[i] = newTestMessage()
+	}
+	inner := &batchRecordingSyncProducer{
+		fail: map[*sarama.ProducerMessage]error{msgs[3]: sarama.ErrMessageSizeTooLarge},
+	}
+	producer := NewCoalescingPartitionSyncProducer(inner, 50*time.Millisecond)
+
+	errs := make([]error, senders)
+	var wg sync.WaitGroup
+	for i, msg := range msgs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, errs[i] = producer.SendMessage(msg)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if i == 3 {
+			require.ErrorIs(t, err, sarama.ErrMessageSizeTooLarge)
+		} else {
+			require.NoError(t, err, "message %d", i)
+		}
+	}
+	var sent int
+	for _, batch := range inner.batches {
+		sent += len(batch)
+	}
+	require.Equal(t, senders, sent)
+	require.Less(t, len(inner.batches), senders)
+}
+
+func TestCoalescingPartitionSyncProducer_CloseFlushesPending(t *testing.T) {
+	inner := &batchRecordingSyncProducer{}
+	producer := NewCoalescingPartitionSyncProducer(inner, time.Hour)
+
+	sent := make(chan error, 1)
+	go func() {
+		_, _, err := producer.SendMessage(newTestMessage())
+		sent <- err
+	}()
+	require.Eventually(t, func() bool {
+		cp := producer.(*coalescingSyncProducer)
+		cp.lock.Lock()
+		defer cp.lock.Unlock()
+		return len(cp.pending) == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, producer.Close())
+	require.Len(t, inner.batches, 1)
+	require.True(t, inner.closed)
+	require.NoError(t, <-sent)
+
+	_, _, err := producer.SendMessage(newTestMessage())
+	require.ErrorIs(t, err, sarama.ErrShuttingDown)
+}
@@ -0,0 +1,50 @@
+package saramaproducer
+
+import (
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+// CompressedProducerMessage is a ProducerMessage compressed with Codec
+// instead of Producer.Compression, for use with
+// CodecSyncProducer.SendCompressedMessage.
+type CompressedProducerMessage struct {
+	sarama.ProducerMessage
+	Codec sarama.CompressionCodec
+}
+
+// CodecSyncProducer is a SyncProducer that can also produce messages with
+// their own compression codec, e.g. to compress large payloads with zstd
+// while small ones are sent uncompressed.
+type CodecSyncProducer struct {
+	SyncProducer
+	version sarama.KafkaVersion
+}
+
+// NewCodecSyncProducer wraps inner so that messages with their own codec can
+// be sent with SendCompressedMessage. All SyncProducer methods are forwarded
+// to inner unchanged.
+func NewCodecSyncProducer(inner SyncProducer) *CodecSyncProducer {
+	return &CodecSyncProducer{SyncProducer: inner, version: inner.ConfigSnapshot().Version}
+}
+
+// SendCompressedMessage produces msg compressed with msg.Codec, at its default
+// compression level unless msg.Codec is also Producer.Compression. As a
+// record batch has a single codec, messages with a codec other than
+// Producer.Compression are batched by a separate async producer sharing the
+// client, so their order relative to messages with another codec is not
+// guaranteed. The producer's other
+// settings, including the codec of messages sent by other means, are not
+// changed. The partition and offset are also set on msg.ProducerMessage.
+func (cp *CodecSyncProducer) SendCompressedMessage(msg *CompressedProducerMessage) (partition int32, offset int64, err error) {
+	if msg.Codec == sarama.CompressionZSTD && !cp.version.IsAtLeast(sarama.V2_1_0_0) {
+		return -1, -1, sarama.ConfigurationError("zstd compression requires Version >= V2_1_0_0")
+	}
+	if msg.Codec < sarama.CompressionNone || msg.Codec > sarama.CompressionZSTD {
+		return -1, -1, sarama.ConfigurationError(fmt.Sprintf("unknown compression codec %d", msg.Codec))
+	}
+	setMessageCodec(&msg.ProducerMessage, msg.Codec)
+	defer takeMessageOptions(&msg.ProducerMessage)
+	return cp.SyncProducer.SendMessage(&msg.ProducerMessage)
+}
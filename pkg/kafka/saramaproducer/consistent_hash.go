@@ -0,0 +1,167 @@
+package saramaproducer
+
+import (
+	"hash"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+type consistentHashPartitioner struct {
+	random       sarama.Partitioner
+	hasher       hash.Hash64
+	virtualNodes int
+
+	// the ring is rebuilt whenever the partition count changes
+	numPartitions int32
+	points        []uint64
+	owners        []int32
+}
+
+// NewConsistentHashPartitioner returns a PartitionerConstructor placing
+// virtualNodes points per partition on a hash ring. A message with a key is
+// assigned to the partition owning the first point at or after the FNV-1a
+// hash of its encoded key; a message without one goes to a random partition.
+//
+// Unlike NewHashPartitioner, which takes the hash modulo the partition count,
+// adding partitions only moves the keys taken over by the new partitions'
+// points, roughly 1/numPartitions of them per partition added. More virtual
+// nodes spread keys more evenly at the cost of a larger ring.
+func NewConsistentHashPartitioner(virtualNodes int) sarama.PartitionerConstructor {
+	if virtualNodes < 1 {
+		virtualNodes = 1
+	}
+	return func(topic string) sarama.Partitioner {
+		return &consistentHashPartitioner{
+			random:       sarama.NewRandomPartitioner(topic),
+			hasher:       fnv.New64a(),
+			virtualNodes: virtualNodes,
+		}
+	}
+}
+
+func (p *consistentHashPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return p.random.Partition(message, numPartitions)
+	}
+	bytes, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	if numPartitions != p.numPartitions {
+		p.buildRing(numPartitions)
+	}
+
+	h := p.hash(bytes)
+	i := sort.Search(len(p.points), func(i int) bool { return p.points[i] >= h })
+	if i == len(p.points) {
+		i = 0
+	}
+	return p.owners[i], nil
+}
+
+func (p *consistentHashPartitioner) buildRing(numPartitions int32) {
+	type point struct {
+		hash      uint64
+		partition int32
+	}
+	points := make([]point, 0, int(numPartitions)*p.virtualNodes)
+	var buf []byte
+	for partition := int32(0); partition < numPartitions; partition++ {
+		for node := 0; node < p.virtualNodes; node++ {
+			buf = strconv.AppendInt(buf[:0], int64(partition), 10)
+			buf = append(buf, '-')
+			buf = strconv.AppendInt(buf, int64(node), 10)
+			points = append(points, point{hash: p.hash(buf), partition: partition})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	p.numPartitions = numPartitions
+	p.points = make([]uint64, len(points))
+	p.owners = make([]int32, len(points))
+	for i, pt := range points {
+		p.points[i] = pt.hash
+		p.owners[i] = pt.partition
+	}
+}
+
+// hash returns the FNV-1a hash of b, passed through a finalizer so that
+// similar inputs such as the virtual node names land far apart on the ring.
+func (p *consistentHashPartitioner) hash(b []byte) uint64 {
+	p.hasher.Reset()
+	_, _ = p.hasher.Write(b)
+	h := p.hasher.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (p *consistentHashPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (p *consistentHashPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	return message.Key != nil
+}
+
+type consistentHashSyncProducer struct {
+	decorator
+	partitioner sarama.PartitionerConstructor
+	topics      sync.Map // topic -> struct{}, for topics whose partitioner is set
+}
+
+// NewConsistentHashSyncProducer returns a SyncProducer that partitions
+// messages with NewConsistentHashPartitioner(virtualNodes) instead of the
+// configured Producer.Partitioner. The partitioner is installed on inner
+// with SetTopicPartitioner the first time a topic is sent to, so it also
+// applies to messages sent to that topic directly through inner.
+func NewConsistentHashSyncProducer(inner SyncProducer, virtualNodes int) SyncProducer {
+	cp := &consistentHashSyncProducer{partitioner: NewConsistentHashPartitioner(virtualNodes)}
+	cp.decorator = newPreSendDecorator(inner, cp)
+	return cp
+}
+
+func (cp *consistentHashSyncProducer) usePartitioner(topic string) error {
+	if _, ok := cp.topics.Load(topic); ok {
+		return nil
+	}
+	if err := cp.SyncProducer.SetTopicPartitioner(topic, cp.partitioner); err != nil {
+		return err
+	}
+	cp.topics.Store(topic, struct{}{})
+	return nil
+}
+
+func (cp *consistentHashSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if err := cp.usePartitioner(msg.Topic); err != nil {
+		return -1, -1, err
+	}
+	return cp.SyncProducer.SendMessage(msg)
+}
+
+func (cp *consistentHashSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var failed ProducerErrors
+	prepared := make([]*sarama.ProducerMessage, 0, len(msgs))
+	positions := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		if err := cp.usePartitioner(msg.Topic); err != nil {
+			failed = append(failed, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+			continue
+		}
+		prepared = append(prepared, msg)
+		positions = append(positions, i)
+	}
+
+	var err error
+	if len(prepared) > 0 {
+		err = cp.SyncProducer.SendMessages(prepared)
+	}
+	return mergeProducerErrors(failed, err, positions)
+}
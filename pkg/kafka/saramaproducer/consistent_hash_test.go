@@ -0,0 +1,28 @@
+package saramaproducer
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsistentHashSyncProducer_SendMessagesSendsPartitionedMessages(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewConsistentHashSyncProducer(inner, 16)
+
+	msgs := []*sarama.ProducerMessage{newTestMessage(), newTestMessage(), newTestMessage()}
+	// no partitioner can be set without a topic
+	msgs[1].Topic = ""
+	err := producer.SendMessages(msgs)
+
+	var pErrs ProducerErrors
+	require.ErrorAs(t, err, &pErrs)
+	require.Len(t, pErrs, 1)
+	require.Same(t, msgs[1], pErrs[0].Msg)
+	require.Equal(t, 1, pErrs[0].BatchIndex)
+	require.ErrorAs(t, pErrs[0], new(sarama.ConfigurationError))
+	for _, msg := range []*sarama.ProducerMessage{msgs[0], msgs[2]} {
+		require.True(t, recorder.wasSent(msg))
+	}
+}
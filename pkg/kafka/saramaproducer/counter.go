@@ -0,0 +1,201 @@
+package saramaproducer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log/level"
+)
+
+// atomicCounterIdleTimeout bounds how long AtomicCounter.Value waits for
+// further records once it has caught up with everything but trailing
+// transaction markers, which are never delivered to consumers.
+const atomicCounterIdleTimeout = time.Second
+
+// AtomicCounter is a named int64 counter stored in a compacted topic. Every
+// increment produces the new total, as a decimal string, keyed by the
+// counter's key, so the latest record for the key is the current value.
+// Totals are written rather than deltas because compaction keeps only the
+// latest record for each key, which would lose all but the last delta.
+//
+// Increments from a single AtomicCounter are serialized. To keep the counter
+// consistent across processes, give every process the same transactional
+// producer: Kafka fences all but the most recent instance of a transactional
+// ID, so only one of them can write at a time.
+type AtomicCounter struct {
+	producer SyncProducer
+	sp       *syncProducer
+	topic    string
+	key      string
+
+	lock   sync.Mutex
+	value  int64
+	loaded bool
+}
+
+// NewAtomicCounter returns the counter stored under key in topic, which
+// should have cleanup.policy=compact. Increment wraps each write in a
+// transaction when producer is transactional.
+//
+// producer must have been created by NewSyncProducer or
+// NewSyncProducerFromClient, or be one of the decorators of this package
+// wrapping such a producer; NewAtomicCounter returns ErrNotSupported for any
+// other implementation.
+func NewAtomicCounter(producer SyncProducer, topic string, key string) (*AtomicCounter, error) {
+	sp, err := coreOf(producer, "NewAtomicCounter")
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicCounter{producer: producer, sp: sp, topic: topic, key: key}, nil
+}
+
+// Increment adds one to the counter and returns the new value once the
+// broker has acknowledged it. The current value is read from the topic on
+// first use, and again after a failed write.
+func (c *AtomicCounter) Increment(ctx context.Context) (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.loaded {
+		if err := c.load(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	// the write is not abandoned on cancellation, as the cached value must
+	// reflect whether it reached the topic
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	next := c.value + 1
+	if err := c.write(next); err != nil {
+		// the broker may have appended the record before failing, so the
+		// value is read again from the topic on the next increment
+		c.loaded = false
+		return 0, err
+	}
+	c.value = next
+	return next, nil
+}
+
+// Value reads the latest value of the counter from the topic. It returns 0
+// when the counter has never been incremented.
+func (c *AtomicCounter) Value(ctx context.Context) (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.load(ctx); err != nil {
+		return 0, err
+	}
+	return c.value, nil
+}
+
+// partition returns the partition holding the counter, chosen by hashing its
+// key the same way the default partitioner does.
+func (c *AtomicCounter) partition() (int32, error) {
+	partitions, err := c.sp.client.Partitions(c.topic)
+	if err != nil {
+		return -1, err
+	}
+	if len(partitions) == 0 {
+		return -1, ErrTopicNotFound
+	}
+	msg := &sarama.ProducerMessage{Topic: c.topic, Key: sarama.StringEncoder(c.key)}
+	choice, err := sarama.NewHashPartitioner(c.topic).Partition(msg, int32(len(partitions)))
+	if err != nil {
+		return -1, err
+	}
+	return partitions[choice], nil
+}
+
+func (c *AtomicCounter) write(value int64) error {
+	partition, err := c.partition()
+	if err != nil {
+		return err
+	}
+	key, payload := []byte(c.key), []byte(strconv.FormatInt(value, 10))
+
+	if !c.producer.IsTransactional() {
+		_, _, err = c.producer.SendMessageZeroCopy(c.topic, partition, key, payload)
+		return err
+	}
+
+	if err := c.producer.BeginTxn(); err != nil {
+		return err
+	}
+	if _, _, err := c.producer.SendMessageZeroCopy(c.topic, partition, key, payload); err != nil {
+		if abortErr := c.producer.AbortTxn(); abortErr != nil {
+			level.Warn(c.sp.logger).Log("msg", "failed to abort counter transaction", "topic", c.topic, "key", c.key, "err", abortErr)
+		}
+		return err
+	}
+	return c.producer.CommitTxn()
+}
+
+// load scans the counter's partition for the latest committed record with
+// the counter's key and caches its value.
+func (c *AtomicCounter) load(ctx context.Context) error {
+	client := c.sp.client
+	partition, err := c.partition()
+	if err != nil {
+		return err
+	}
+	oldest, err := client.GetOffset(c.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	newest, err := client.GetOffset(c.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	if newest <= oldest {
+		c.value, c.loaded = 0, true
+		return nil
+	}
+
+	conf := cloneConfig(client.Config())
+	conf.Consumer.IsolationLevel = sarama.ReadCommitted
+	conf.Consumer.Return.Errors = false
+	consumer, err := sarama.NewConsumerFromClient(&configOverrideClient{Client: client, conf: conf})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = consumer.Close() }()
+
+	pc, err := consumer.ConsumePartition(c.topic, partition, oldest)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = pc.Close() }()
+
+	var value int64
+	idle := time.NewTimer(atomicCounterIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if string(msg.Key) == c.key {
+				if value, err = strconv.ParseInt(string(msg.Value), 10, 64); err != nil {
+					return fmt.Errorf("kafka: invalid counter value at %s/%d offset %d: %w", c.topic, partition, msg.Offset, err)
+				}
+			}
+			if msg.Offset+1 >= newest {
+				c.value, c.loaded = value, true
+				return nil
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(atomicCounterIdleTimeout)
+		case <-idle.C:
+			c.value, c.loaded = value, true
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package saramaproducer
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+const counterTestTopic = "counters"
+
+// newCounterTestSyncProducer returns a producer connected to a mock broker
+// serving fetch as the single partition of counterTestTopic, holding the
+// records up to newest, along with a recorder of the values it sends.
+func newCounterTestSyncProducer(t *testing.T, fetch *sarama.MockFetchResponse, newest int64) (SyncProducer, *valueRecorder) {
+	t.Helper()
+
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(counterTestTopic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(counterTestTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(counterTestTopic, 0, sarama.OffsetNewest, newest),
+		"FetchRequest": fetch.SetHighWaterMark(counterTestTopic, 0, newest),
+	})
+
+	recorder := &valueRecorder{}
+	config := newTestConfig()
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	return producer, recorder
+}
+
+func TestAtomicCounter_Increment(t *testing.T) {
+	fetch := sarama.NewMockFetchResponse(t, 1).
+		SetMessageWithKey(counterTestTopic, 0, 0, sarama.StringEncoder("hits"), sarama.StringEncoder("4")).
+		SetMessageWithKey(counterTestTopic, 0, 1, sarama.StringEncoder("misses"), sarama.StringEncoder("9"))
+	producer, recorder := newCounterTestSyncProducer(t, fetch, 2)
+
+	counter, err := NewAtomicCounter(producer, counterTestTopic, "hits")
+	require.NoError(t, err)
+
+	value, err := counter.Value(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(4), value)
+
+	for _, want := range []int64{5, 6} {
+		value, err = counter.Increment(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, want, value)
+		require.Equal(t, strconv.FormatInt(want, 10), string(recorder.value))
+	}
+}
+
+func TestAtomicCounter_EmptyTopic(t *testing.T) {
+	producer, recorder := newCounterTestSyncProducer(t, sarama.NewMockFetchResponse(t, 1), 0)
+
+	counter, err := NewAtomicCounter(producer, counterTestTopic, "hits")
+	require.NoError(t, err)
+
+	value, err := counter.Value(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, value)
+
+	value, err = counter.Increment(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), value)
+	require.Equal(t, "1", string(recorder.value))
+}
+
+func TestAtomicCounter_InvalidValue(t *testing.T) {
+	fetch := sarama.NewMockFetchResponse(t, 1).
+		SetMessageWithKey(counterTestTopic, 0, 0, sarama.StringEncoder("hits"), sarama.StringEncoder("four"))
+	producer, _ := newCounterTestSyncProducer(t, fetch, 1)
+
+	counter, err := NewAtomicCounter(producer, counterTestTopic, "hits")
+	require.NoError(t, err)
+
+	_, err = counter.Increment(context.Background())
+	require.ErrorContains(t, err, "invalid counter value")
+}
+
+func TestNewAtomicCounter_RequiresCoreProducer(t *testing.T) {
+	_, err := NewAtomicCounter(&stubSyncProducer{}, counterTestTopic, "hits")
+	require.ErrorIs(t, err, ErrNotSupported)
+}
+
+// failingZeroCopySyncProducer fails SendMessageZeroCopy while fail is set.
+type failingZeroCopySyncProducer struct {
+	SyncProducer
+	fail bool
+}
+
+func (fp *failingZeroCopySyncProducer) SendMessageZeroCopy(topic string, partition int32, key, value []byte) (int32, int64, error) {
+	if fp.fail {
+		return -1, -1, sarama.ErrRequestTimedOut
+	}
+	return fp.SyncProducer.SendMessageZeroCopy(topic, partition, key, value)
+}
+
+func TestAtomicCounter_IncrementFailureReloads(t *testing.T) {
+	fetch := sarama.NewMockFetchResponse(t, 1).
+		SetMessageWithKey(counterTestTopic, 0, 0, sarama.StringEncoder("hits"), sarama.StringEncoder("4"))
+	producer, _ := newCounterTestSyncProducer(t, fetch, 1)
+	sp, err := coreOf(producer, "test")
+	require.NoError(t, err)
+	failing := &failingZeroCopySyncProducer{SyncProducer: producer}
+	counter := &AtomicCounter{producer: failing, sp: sp, topic: counterTestTopic, key: "hits"}
+
+	value, err := counter.Increment(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(5), value)
+
+	failing.fail = true
+	_, err = counter.Increment(context.Background())
+	require.ErrorIs(t, err, sarama.ErrRequestTimedOut)
+	require.False(t, counter.loaded)
+
+	// the value is read again rather than trusting the cached one, which
+	// the topic still holds as 4
+	failing.fail = false
+	value, err = counter.Increment(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(5), value)
+}
@@ -0,0 +1,75 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVSyncProducer_SendMessageCSV(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewCSVSyncProducer(inner)
+
+	_, _, err := producer.SendMessageCSV(context.Background(), testTopic, []byte("key"), []string{"a", "b,c"})
+	require.NoError(t, err)
+
+	sent := recorder.messages()
+	require.Len(t, sent, 1)
+	msg := consumed(t, sent[0])
+	require.Equal(t, []byte("key"), msg.Key)
+	require.Equal(t, "a,\"b,c\"\n", string(msg.Value))
+	require.Equal(t, []*sarama.RecordHeader{{Key: []byte(ContentTypeHeader), Value: []byte("text/csv")}}, msg.Headers)
+}
+
+func TestCSVSyncProducer_SendMessageBulkCSV(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewCSVSyncProducer(inner)
+
+	// "a,1\n" is 4 bytes, so two rows fit in a batch of 10 bytes and the
+	// last batch holds a single row
+	records := [][]string{{"a", "1"}, {"b", "2"}, {"c", "3"}, {"d", "4"}, {"e", "5"}}
+	sent, err := producer.SendMessageBulkCSV(context.Background(), testTopic, records, 10)
+	require.NoError(t, err)
+	require.Equal(t, len(records), sent)
+
+	var values []string
+	for _, msg := range recorder.messages() {
+		require.Equal(t, []sarama.RecordHeader{{Key: []byte(ContentTypeHeader), Value: []byte("text/csv; batch=true")}}, msg.Headers)
+		value, err := msg.Value.Encode()
+		require.NoError(t, err)
+		values = append(values, string(value))
+	}
+	require.Equal(t, []string{"a,1\nb,2\n", "c,3\nd,4\n", "e,5\n"}, values)
+}
+
+func TestCSVSyncProducer_SendMessageBulkCSVQuotesRows(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewCSVSyncProducer(inner)
+
+	// separators, quotes and line breaks in fields are quoted, so the row
+	// stays whole even though it is longer than a batch
+	records := [][]string{{"a,b", `say "hi"`, "two\nlines"}, {"c"}}
+	sent, err := producer.SendMessageBulkCSV(context.Background(), testTopic, records, 8)
+	require.NoError(t, err)
+	require.Equal(t, 2, sent)
+
+	msgs := recorder.messages()
+	require.Len(t, msgs, 2)
+	value, err := msgs[0].Value.Encode()
+	require.NoError(t, err)
+	rows, err := csv.NewReader(bytes.NewReader(value)).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, records[:1], rows)
+}
+
+func TestCSVSyncProducer_SendMessageBulkCSVInvalidBatchSize(t *testing.T) {
+	producer := NewCSVSyncProducer(&stubSyncProducer{})
+
+	sent, err := producer.SendMessageBulkCSV(context.Background(), testTopic, [][]string{{"a"}}, 0)
+	require.ErrorAs(t, err, new(sarama.ConfigurationError))
+	require.Zero(t, sent)
+}
@@ -0,0 +1,236 @@
+package saramaproducer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+)
+
+// producerCore is implemented by the SyncProducers of this package that
+// produce through a *syncProducer, returning it so that decorators can reach
+// its client and caches.
+type producerCore interface {
+	core() *syncProducer
+}
+
+func (sp *syncProducer) core() *syncProducer {
+	return sp
+}
+
+// decorator is embedded by the SyncProducers of this package that add
+// behaviour to the messages sent through another SyncProducer. The embedding
+// type implements SendMessage and SendMessages; decorator implements every
+// other method taking a ProducerMessage on top of those two, in the same way
+// as the core producer does, so that the behaviour applies whichever method
+// is called. The remaining methods, including ProduceRawBatch and
+// SendMessagesBinary, which take pre-encoded records, and Barrier, which
+// carries no message of the caller, are forwarded to the decorated producer.
+//
+// Methods that need the state of the core producer, such as a topic's
+// retention for SendMessageWithExpiry, return ErrNotSupported if the
+// decorated producer was not created by this package. SendMessageBatch always
+// does, as SendMessages cannot guarantee that the messages end up in a
+// single batch.
+type decorator struct {
+	SyncProducer
+
+	// outer is the SyncProducer embedding the decorator
+	outer SyncProducer
+	// preSend is set if outer acts on messages only before handing them to
+	// the decorated producer, never on their outcome
+	preSend bool
+}
+
+// newDecorator returns a decorator for outer, which decorates inner.
+func newDecorator(inner, outer SyncProducer) decorator {
+	return decorator{SyncProducer: inner, outer: outer}
+}
+
+// newPreSendDecorator returns a decorator for outer, which decorates inner
+// and acts on messages only before handing them to it.
+func newPreSendDecorator(inner, outer SyncProducer) decorator {
+	return decorator{SyncProducer: inner, outer: outer, preSend: true}
+}
+
+func (d *decorator) base() *decorator {
+	return d
+}
+
+// passesCallbacks reports whether d and the decorators it wraps all act on
+// messages only before handing them on, down to a core producer.
+func (d *decorator) passesCallbacks() bool {
+	for cur := d; cur.preSend; {
+		switch inner := cur.SyncProducer.(type) {
+		case *syncProducer:
+			return true
+		case interface{ base() *decorator }:
+			cur = inner.base()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// reachesCore reports whether messages sent through p reach a core producer
+// only through decorators that act on them before handing them on, so that
+// the options attached to a message are handled by the core producer.
+func reachesCore(p SyncProducer) bool {
+	switch p := p.(type) {
+	case *syncProducer:
+		return true
+	case interface{ base() *decorator }:
+		return p.base().passesCallbacks()
+	}
+	return false
+}
+
+func (d *decorator) core() *syncProducer {
+	if c, ok := d.SyncProducer.(producerCore); ok {
+		return c.core()
+	}
+	return nil
+}
+
+// coreOf returns the core producer of p, which must have been created or
+// decorated by this package, or ErrNotSupported naming what needs it.
+func coreOf(p SyncProducer, what string) (*syncProducer, error) {
+	if c, ok := p.(producerCore); ok {
+		if sp := c.core(); sp != nil {
+			return sp, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s requires a producer created by this package", ErrNotSupported, what)
+}
+
+// coreFor returns the core producer of the embedding type, which may
+// override core, or ErrNotSupported naming method if there is none.
+func (d *decorator) coreFor(method string) (*syncProducer, error) {
+	return coreOf(d.outer, method)
+}
+
+// coreLogger returns the core producer's logger, if any.
+func (d *decorator) coreLogger() log.Logger {
+	if sp := d.outer.(producerCore).core(); sp != nil {
+		return sp.logger
+	}
+	return log.NewNopLogger()
+}
+
+func (d *decorator) SendMessageZeroCopy(topic string, partition int32, key, value []byte) (int32, int64, error) {
+	return sendMessageZeroCopy(d.outer, topic, partition, key, value)
+}
+
+func (d *decorator) SendMessageToPartition(ctx context.Context, topic string, partition int32, key, value []byte) (int64, error) {
+	return sendMessageToPartition(ctx, d.outer, topic, partition, key, value)
+}
+
+func (d *decorator) SendMessageWithExpiry(msg *sarama.ProducerMessage, ttl time.Duration) (int32, int64, error) {
+	sp, err := d.coreFor("SendMessageWithExpiry")
+	if err != nil {
+		return -1, -1, err
+	}
+	return sp.sendMessageWithExpiry(d.outer, msg, ttl)
+}
+
+func (d *decorator) SendMessageWithMetadata(msg *sarama.ProducerMessage) (RecordMetadata, error) {
+	return sendMessageWithMetadata(d.outer, msg)
+}
+
+func (d *decorator) SendMessageWithSchema(msg *sarama.ProducerMessage, schemaID int, schemaVersion int) (partition int32, offset int64, err error) {
+	return sendMessageWithSchema(d.outer, msg, schemaID, schemaVersion)
+}
+
+func (d *decorator) SendMessageWithTimestamp(msg *sarama.ProducerMessage, ts time.Time) (partition int32, offset int64, err error) {
+	msg.Timestamp = ts
+	return d.outer.SendMessage(msg)
+}
+
+func (d *decorator) SendMessageWithCorrelationID(ctx context.Context, msg *sarama.ProducerMessage, correlationID string) (partition int32, offset int64, err error) {
+	sp, err := d.coreFor("SendMessageWithCorrelationID")
+	if err != nil {
+		return -1, -1, err
+	}
+	return sp.sendMessageWithCorrelationID(ctx, d.outer, msg, correlationID)
+}
+
+func (d *decorator) SendMessageWithSLA(msg *sarama.ProducerMessage, maxLatency time.Duration) (partition int32, offset int64, err error) {
+	return sendMessageWithSLA(d.outer, msg, maxLatency, d.coreLogger())
+}
+
+// SendMessageWithCallback passes onComplete down to the core producer with
+// msg if no decorator on the way waits for the outcome, so that it is called
+// when the message is resolved without a goroutine waiting for it. Otherwise
+// msg is sent from a goroutine.
+func (d *decorator) SendMessageWithCallback(msg *sarama.ProducerMessage, onComplete func(partition int32, offset int64, err error)) {
+	if !d.passesCallbacks() {
+		go func() {
+			onComplete(d.outer.SendMessage(msg))
+		}()
+		return
+	}
+
+	callback := &pendingCallback{onComplete: onComplete}
+	updateMessageOptions(msg, func(opts *messageOptions) { opts.callback = callback })
+	partition, offset, err := d.outer.SendMessage(msg)
+	takeMessageOptions(msg)
+	if !callback.taken {
+		// a decorator returned before msg reached the core producer
+		onComplete(partition, offset, err)
+	}
+}
+
+func (d *decorator) SendMessageWithFallback(primary *sarama.ProducerMessage, fallback *sarama.ProducerMessage) (int32, int64, bool, error) {
+	return sendMessageWithFallback(d.outer, primary, fallback, d.coreLogger())
+}
+
+func (d *decorator) SendTombstone(ctx context.Context, topic string, key []byte) (partition int32, offset int64, err error) {
+	sp, err := d.coreFor("SendTombstone")
+	if err != nil {
+		return -1, -1, err
+	}
+	return sp.sendTombstone(ctx, d.outer, topic, key)
+}
+
+func (d *decorator) SendMessageBatch([]*sarama.ProducerMessage) ([]ProducerResult, error) {
+	return nil, fmt.Errorf("%w: SendMessageBatch cannot be decorated", ErrNotSupported)
+}
+
+func (d *decorator) SendMessagesWithPartialRetry(msgs []*sarama.ProducerMessage, retryPolicy RetryPolicy) (ProducerResults, error) {
+	return sendMessagesWithPartialRetry(d.outer, msgs, retryPolicy)
+}
+
+func (d *decorator) SendMessagesSequential(msgs []*sarama.ProducerMessage) error {
+	return sendMessagesSequential(d.outer, msgs)
+}
+
+func (d *decorator) SendMessagesBatched(ctx context.Context, msgs <-chan *sarama.ProducerMessage, batchSize int, maxDelay time.Duration) error {
+	return sendMessagesBatched(ctx, d.outer, msgs, batchSize, maxDelay)
+}
+
+func (d *decorator) StartTransaction(ctx context.Context) (*Transaction, error) {
+	return startTransaction(ctx, d.outer)
+}
+
+func (d *decorator) ConsumeAndProduce(ctx context.Context, input *sarama.ConsumerMessage, output *sarama.ProducerMessage, groupID string) error {
+	return consumeAndProduce(ctx, d.outer, input, output, groupID)
+}
+
+func (d *decorator) SendMessageAndConsume(msg *sarama.ProducerMessage, consumerConfig *sarama.Config) (int32, int64, *sarama.ConsumerMessage, error) {
+	sp, err := d.coreFor("SendMessageAndConsume")
+	if err != nil {
+		return -1, -1, nil, err
+	}
+	return sp.sendMessageAndConsume(d.outer, msg, consumerConfig)
+}
+
+func (d *decorator) MigrateMessages(ctx context.Context, fromTopic, toTopic string, transform func(*sarama.ConsumerMessage) (*sarama.ProducerMessage, error)) (int64, error) {
+	sp, err := d.coreFor("MigrateMessages")
+	if err != nil {
+		return 0, err
+	}
+	return sp.migrateMessages(ctx, d.outer, fromTopic, toTopic, transform)
+}
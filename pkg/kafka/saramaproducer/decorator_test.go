@@ -0,0 +1,252 @@
+package saramaproducer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+const otherTestTopic = "other_topic"
+
+// countingSyncProducer counts the messages passing through its hooks.
+type countingSyncProducer struct {
+	decorator
+	sent atomic.Int64
+}
+
+func newCountingSyncProducer(inner SyncProducer) *countingSyncProducer {
+	cp := &countingSyncProducer{}
+	cp.decorator = newDecorator(inner, cp)
+	return cp
+}
+
+func (cp *countingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	cp.sent.Add(1)
+	return cp.SyncProducer.SendMessage(msg)
+}
+
+func (cp *countingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	cp.sent.Add(int64(len(msgs)))
+	return cp.SyncProducer.SendMessages(msgs)
+}
+
+// newDecoratorTestSyncProducer returns a producer connected to a mock broker
+// that also serves topic configs and the record at offset 0 of each
+// partition of testTopic, closed at the end of the test.
+func newDecoratorTestSyncProducer(t *testing.T) SyncProducer {
+	t.Helper()
+
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	offsets := sarama.NewMockOffsetResponse(t)
+	fetch := sarama.NewMockFetchResponse(t, 1)
+	for partition := int32(0); partition < 2; partition++ {
+		offsets.SetOffset(testTopic, partition, sarama.OffsetOldest, 0).SetOffset(testTopic, partition, sarama.OffsetNewest, 1)
+		fetch.SetMessage(testTopic, partition, 0, sarama.StringEncoder("foo")).SetHighWaterMark(testTopic, partition, 1)
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()).
+			SetLeader(testTopic, 1, broker.BrokerID()).
+			SetLeader(otherTestTopic, 0, broker.BrokerID()),
+		"ProduceRequest":         sarama.NewMockProduceResponse(t),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+		"OffsetRequest":          offsets,
+		"FetchRequest":           fetch,
+	})
+
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	return producer
+}
+
+func newTestMessage() *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foo")}
+}
+
+func TestDecorator_SendMethodsGoThroughHooks(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		send func(p SyncProducer) error
+		want int64
+	}{
+		{"SendMessage", func(p SyncProducer) error {
+			_, _, err := p.SendMessage(newTestMessage())
+			return err
+		}, 1},
+		{"SendMessageZeroCopy", func(p SyncProducer) error {
+			_, _, err := p.SendMessageZeroCopy(testTopic, 0, nil, []byte("foo"))
+			return err
+		}, 1},
+		{"SendMessageToPartition", func(p SyncProducer) error {
+			_, err := p.SendMessageToPartition(context.Background(), testTopic, 1, nil, []byte("foo"))
+			return err
+		}, 1},
+		{"SendMessageWithExpiry", func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithExpiry(newTestMessage(), time.Second)
+			return err
+		}, 1},
+		{"SendMessageWithMetadata", func(p SyncProducer) error {
+			_, err := p.SendMessageWithMetadata(newTestMessage())
+			return err
+		}, 1},
+		{"SendMessageWithSchema", func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithSchema(newTestMessage(), 1, 1)
+			return err
+		}, 1},
+		{"SendMessageWithTimestamp", func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithTimestamp(newTestMessage(), time.Now())
+			return err
+		}, 1},
+		{"SendMessageWithCorrelationID", func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithCorrelationID(context.Background(), newTestMessage(), "id")
+			return err
+		}, 1},
+		{"SendMessageWithSLA", func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithSLA(newTestMessage(), 5*time.Second)
+			return err
+		}, 1},
+		{"SendMessageWithCallback", func(p SyncProducer) error {
+			done := make(chan error, 1)
+			p.SendMessageWithCallback(newTestMessage(), func(_ int32, _ int64, err error) { done <- err })
+			return <-done
+		}, 1},
+		{"SendMessageWithFallback", func(p SyncProducer) error {
+			_, _, _, err := p.SendMessageWithFallback(newTestMessage(), newTestMessage())
+			return err
+		}, 1},
+		{"SendTombstone", func(p SyncProducer) error {
+			_, _, err := p.SendTombstone(context.Background(), testTopic, []byte("key"))
+			return err
+		}, 1},
+		{"SendMessages", func(p SyncProducer) error {
+			return p.SendMessages([]*sarama.ProducerMessage{newTestMessage(), newTestMessage()})
+		}, 2},
+		{"SendMessagesWithPartialRetry", func(p SyncProducer) error {
+			_, err := p.SendMessagesWithPartialRetry([]*sarama.ProducerMessage{newTestMessage(), newTestMessage()}, RetryPolicy{})
+			return err
+		}, 2},
+		{"SendMessagesSequential", func(p SyncProducer) error {
+			return p.SendMessagesSequential([]*sarama.ProducerMessage{newTestMessage(), newTestMessage()})
+		}, 2},
+		{"SendMessagesBatched", func(p SyncProducer) error {
+			msgs := make(chan *sarama.ProducerMessage, 2)
+			msgs <- newTestMessage()
+			msgs <- newTestMessage()
+			close(msgs)
+			return p.SendMessagesBatched(context.Background(), msgs, 10, time.Millisecond)
+		}, 2},
+		{"SendMessageAndConsume", func(p SyncProducer) error {
+			_, _, _, err := p.SendMessageAndConsume(newTestMessage(), nil)
+			return err
+		}, 1},
+		{"MigrateMessages", func(p SyncProducer) error {
+			_, err := p.MigrateMessages(context.Background(), testTopic, otherTestTopic, nil)
+			return err
+		}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			producer := newCountingSyncProducer(newDecoratorTestSyncProducer(t))
+			require.NoError(t, tc.send(producer))
+			require.Equal(t, tc.want, producer.sent.Load())
+		})
+	}
+}
+
+func TestDecorator_SendMessageBatchNotSupported(t *testing.T) {
+	producer := newCountingSyncProducer(newTestSyncProducer(t))
+
+	_, err := producer.SendMessageBatch([]*sarama.ProducerMessage{newTestMessage()})
+	require.ErrorIs(t, err, ErrNotSupported)
+	require.Zero(t, producer.sent.Load())
+}
+
+// txnSyncProducer is a transactional SyncProducer whose transactions always
+// succeed. Its other methods panic.
+type txnSyncProducer struct {
+	SyncProducer
+}
+
+func (txnSyncProducer) SendMessage(*sarama.ProducerMessage) (int32, int64, error) { return 0, 0, nil }
+func (txnSyncProducer) IsTransactional() bool                                     { return true }
+func (txnSyncProducer) BeginTxn() error                                           { return nil }
+func (txnSyncProducer) BeginTxnWithTimeout(context.Context) error                 { return nil }
+func (txnSyncProducer) CommitTxn() error                                          { return nil }
+func (txnSyncProducer) AbortTxn() error                                           { return nil }
+func (txnSyncProducer) AddMessageToTxn(*sarama.ConsumerMessage, string, *string) error {
+	return nil
+}
+
+func TestDecorator_TransactionsGoThroughHooks(t *testing.T) {
+	producer := newCountingSyncProducer(txnSyncProducer{})
+
+	txn, err := producer.StartTransaction(context.Background())
+	require.NoError(t, err)
+	_, _, err = txn.SendMessage(newTestMessage())
+	require.NoError(t, err)
+	require.NoError(t, txn.Commit())
+	require.Equal(t, int64(1), producer.sent.Load())
+
+	require.NoError(t, producer.ConsumeAndProduce(context.Background(), &sarama.ConsumerMessage{Topic: testTopic}, newTestMessage(), "group"))
+	require.Equal(t, int64(2), producer.sent.Load())
+}
+
+func TestDecorator_SendMessageWithCallbackPassesCallback(t *testing.T) {
+	core := newTestSyncProducer(t)
+	producer := NewTaggingSyncProducer(NewRestrictedSyncProducer(core, Allowlist(testTopic)), map[string]string{"k": "v"})
+	require.True(t, producer.(*taggingSyncProducer).passesCallbacks())
+	require.False(t, newCountingSyncProducer(producer).passesCallbacks())
+
+	done := make(chan error, 1)
+	msg := newTestMessage()
+	producer.SendMessageWithCallback(msg, func(_ int32, _ int64, err error) { done <- err })
+	require.NoError(t, <-done)
+	require.Equal(t, "k", string(msg.Headers[0].Key))
+
+	// the restricted producer rejects the message before it reaches the core
+	msg = newTestMessage()
+	msg.Topic = otherTestTopic
+	producer.SendMessageWithCallback(msg, func(_ int32, _ int64, err error) { done <- err })
+	require.ErrorIs(t, <-done, ErrTopicNotPermitted)
+	_, ok := pendingOptions.Load(msg)
+	require.False(t, ok)
+}
+
+func TestDecorator_CoreRequired(t *testing.T) {
+	producer := newCountingSyncProducer(txnSyncProducer{})
+
+	_, _, err := producer.SendMessageWithExpiry(newTestMessage(), time.Second)
+	require.ErrorIs(t, err, ErrNotSupported)
+	require.Zero(t, producer.sent.Load())
+}
+
+func TestCoreOf_ConstructorsRequireCore(t *testing.T) {
+	for name, construct := range map[string]func(SyncProducer) error{
+		"NewAtomicCounter": func(p SyncProducer) error {
+			_, err := NewAtomicCounter(p, testTopic, "key")
+			return err
+		},
+		"NewPrometheusExporter": func(p SyncProducer) error {
+			_, err := NewPrometheusExporter(p)
+			return err
+		},
+		"NewRebalanceAwareSyncProducer": func(p SyncProducer) error {
+			_, err := NewRebalanceAwareSyncProducer(p, "group")
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.ErrorIs(t, construct(&stubSyncProducer{}), ErrNotSupported)
+		})
+	}
+
+	_, err := NewPrometheusExporter(newCountingSyncProducer(newTestSyncProducer(t)))
+	require.NoError(t, err)
+}
@@ -0,0 +1,197 @@
+package saramaproducer
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log/level"
+)
+
+// topicDrain tracks a DrainTopic call in progress.
+type topicDrain struct {
+	// idle is closed once the topic has no pending messages
+	idle chan struct{}
+	// done is closed when the drain completes or is abandoned
+	done chan struct{}
+}
+
+// input hands msg to the async producer, waiting for any drain of its topic
+// to complete and for the number of messages in flight to drop below the
+// limit set by SetMaxInflight first. Every message passed to input must later
+// be passed to resolved, unless its topic has been closed in the meantime, in
+// which case input fails it with ErrTopicClosed itself.
+func (sp *syncProducer) input(msg *sarama.ProducerMessage, f *flight) {
+	sp.topicsLock.Lock()
+	for {
+		if _, closed := sp.closedTopics[msg.Topic]; closed {
+			sp.topicsLock.Unlock()
+			sp.reject(msg, f, ErrTopicClosed)
+			return
+		}
+		if drain, draining := sp.topicDrains[msg.Topic]; draining {
+			sp.topicsLock.Unlock()
+			<-drain.done
+			sp.topicsLock.Lock()
+			continue
+		}
+		if sp.maxInflight > 0 && sp.inflight >= sp.maxInflight {
+			sp.inflightCond.Wait()
+			continue
+		}
+		break
+	}
+	sp.topicPending[msg.Topic]++
+	msgs := sp.topicInflight[msg.Topic]
+	if msgs == nil {
+		msgs = make(map[*sarama.ProducerMessage]struct{})
+		sp.topicInflight[msg.Topic] = msgs
+	}
+	msgs[msg] = struct{}{}
+	sp.inflight++
+	sp.topicsLock.Unlock()
+
+	atomic.AddInt64(&sp.pending, 1)
+	sp.addFlight(msg, f)
+	if err := sp.handOver(msg, sp.keyFor(msg, f.opts)); err != nil {
+		sp.takeFlight(msg)
+		sp.resolved(msg)
+		sp.reject(msg, f, err)
+	}
+}
+
+// resolved records that msg has been acknowledged or has failed. It must be
+// called before the sender is notified, as msg may be reused afterwards.
+func (sp *syncProducer) resolved(msg *sarama.ProducerMessage) {
+	atomic.AddInt64(&sp.pending, -1)
+
+	sp.topicsLock.Lock()
+	defer sp.topicsLock.Unlock()
+
+	sp.inflight--
+	sp.inflightCond.Broadcast()
+
+	sp.topicPending[msg.Topic]--
+	delete(sp.topicInflight[msg.Topic], msg)
+	if sp.topicPending[msg.Topic] > 0 {
+		return
+	}
+	delete(sp.topicPending, msg.Topic)
+	delete(sp.topicInflight, msg.Topic)
+	// sends block while draining, so the count reaches zero at most once per drain
+	if drain, draining := sp.topicDrains[msg.Topic]; draining {
+		close(drain.idle)
+	}
+}
+
+// reject fails msg with err without handing it to the async producer.
+func (sp *syncProducer) reject(msg *sarama.ProducerMessage, f *flight, err error) {
+	if f.callback != nil {
+		f.callback(-1, -1, err)
+		return
+	}
+	f.expectation <- &ProducerError{Msg: msg, Err: err}
+}
+
+func (sp *syncProducer) DrainTopic(ctx context.Context, topic string) error {
+	drain := &topicDrain{idle: make(chan struct{}), done: make(chan struct{})}
+
+	sp.topicsLock.Lock()
+	for {
+		other, draining := sp.topicDrains[topic]
+		if !draining {
+			break
+		}
+		sp.topicsLock.Unlock()
+		select {
+		case <-other.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		sp.topicsLock.Lock()
+	}
+	sp.topicDrains[topic] = drain
+	if sp.topicPending[topic] == 0 {
+		close(drain.idle)
+	}
+	sp.topicsLock.Unlock()
+
+	defer func() {
+		sp.topicsLock.Lock()
+		delete(sp.topicDrains, topic)
+		sp.topicsLock.Unlock()
+		close(drain.done)
+	}()
+
+	select {
+	case <-drain.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (sp *syncProducer) FlushPartition(ctx context.Context, topic string, partition int32) (int64, error) {
+	sp.topicsLock.Lock()
+	defer sp.topicsLock.Unlock()
+
+	waiting := make([]*sarama.ProducerMessage, 0, len(sp.topicInflight[topic]))
+	for msg := range sp.topicInflight[topic] {
+		waiting = append(waiting, msg)
+	}
+
+	// resolved broadcasts inflightCond; wake up on ctx too
+	stop := context.AfterFunc(ctx, func() {
+		sp.topicsLock.Lock()
+		defer sp.topicsLock.Unlock()
+		sp.inflightCond.Broadcast()
+	})
+	defer stop()
+
+	for len(waiting) > 0 {
+		if _, ok := sp.topicInflight[topic][waiting[len(waiting)-1]]; !ok {
+			waiting = waiting[:len(waiting)-1]
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return -1, err
+		}
+		sp.inflightCond.Wait()
+	}
+
+	if offset, ok := sp.activePartitions[topic][partition]; ok {
+		return offset, nil
+	}
+	return -1, nil
+}
+
+func (sp *syncProducer) CloseTopicProducer(topic string) error {
+	sp.topicsLock.Lock()
+	if _, closed := sp.closedTopics[topic]; closed {
+		sp.topicsLock.Unlock()
+		return nil
+	}
+	sp.closedTopics[topic] = struct{}{}
+	// senders waiting for room in flight must notice the topic is closed
+	sp.inflightCond.Broadcast()
+	for sp.topicPending[topic] > 0 {
+		sp.inflightCond.Wait()
+	}
+	delete(sp.activePartitions, topic)
+	sp.topicsLock.Unlock()
+
+	sp.topicsConfig.Lock()
+	delete(sp.topicConfigs, topic)
+	delete(sp.topicPartitioners, topic)
+	sp.topicsConfig.Unlock()
+
+	sp.maxMessageBytesLock.Lock()
+	delete(sp.maxMessageBytes, topic)
+	sp.maxMessageBytesLock.Unlock()
+	sp.valueSizeLimits.Delete(topic)
+	sp.compactionChecked.Delete(topic)
+	sp.retentions.Delete(topic)
+
+	level.Info(sp.logger).Log("msg", "closed topic producer", "topic", topic)
+	return nil
+}
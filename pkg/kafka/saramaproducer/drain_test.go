@@ -0,0 +1,169 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncProducer_CloseTopicProducer(t *testing.T) {
+	producer := newTestSyncProducer(t)
+	sp := producer.(*syncProducer)
+
+	require.NoError(t, producer.SetTopicConfig(testTopic, TopicProducerConfig{RequiredAcks: sarama.WaitForLocal}))
+	_, _, err := producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+
+	require.NoError(t, producer.CloseTopicProducer(testTopic))
+	require.NoError(t, producer.CloseTopicProducer(testTopic))
+	sp.topicsConfig.RLock()
+	require.NotContains(t, sp.topicConfigs, testTopic)
+	require.NotContains(t, sp.topicPartitioners, testTopic)
+	sp.topicsConfig.RUnlock()
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.ErrorIs(t, err, ErrTopicClosed)
+}
+
+// pendingFor returns the number of messages in flight to topic.
+func pendingFor(sp *syncProducer, topic string) int {
+	sp.topicsLock.Lock()
+	defer sp.topicsLock.Unlock()
+	return sp.topicPending[topic]
+}
+
+// sendWithCallback sends msg with SendMessageWithCallback, returning a
+// channel receiving its error.
+func sendWithCallback(producer SyncProducer, msg *sarama.ProducerMessage) <-chan error {
+	done := make(chan error, 1)
+	producer.SendMessageWithCallback(msg, func(_ int32, _ int64, err error) { done <- err })
+	return done
+}
+
+func TestSyncProducer_DrainTopic(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	sp := producer.(*syncProducer)
+
+	require.NoError(t, producer.DrainTopic(context.Background(), testTopic))
+
+	broker.SetLatency(latency)
+	done := sendWithCallback(producer, newTestMessage())
+	require.Equal(t, 1, pendingFor(sp, testTopic))
+
+	start := time.Now()
+	require.NoError(t, producer.DrainTopic(context.Background(), testTopic))
+	require.GreaterOrEqual(t, time.Since(start), latency/2)
+	require.Zero(t, pendingFor(sp, testTopic))
+	require.NoError(t, <-done)
+	require.NotContains(t, sp.topicDrains, testTopic)
+}
+
+func TestSyncProducer_DrainTopic_BlocksSends(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	sp := producer.(*syncProducer)
+
+	broker.SetLatency(50 * time.Millisecond)
+	first := sendWithCallback(producer, newTestMessage())
+	drained := make(chan error, 1)
+	go func() { drained <- producer.DrainTopic(context.Background(), testTopic) }()
+	require.Eventually(t, func() bool {
+		sp.topicsLock.Lock()
+		defer sp.topicsLock.Unlock()
+		_, draining := sp.topicDrains[testTopic]
+		return draining
+	}, time.Second, time.Millisecond)
+
+	// the second send waits for the drain, so it is never counted with the first
+	second := make(chan error, 1)
+	go func() {
+		_, _, err := producer.SendMessage(newTestMessage())
+		second <- err
+	}()
+	require.NoError(t, <-drained)
+	require.NoError(t, <-first)
+	require.NoError(t, <-second)
+}
+
+func TestSyncProducer_DrainTopic_ContextDone(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	sp := producer.(*syncProducer)
+
+	broker.SetLatency(200 * time.Millisecond)
+	done := sendWithCallback(producer, newTestMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, producer.DrainTopic(ctx, testTopic), context.DeadlineExceeded)
+	sp.topicsLock.Lock()
+	require.NotContains(t, sp.topicDrains, testTopic)
+	sp.topicsLock.Unlock()
+	require.NoError(t, <-done)
+}
+
+func TestSyncProducer_FlushPartition(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	sp := producer.(*syncProducer)
+
+	offset, err := producer.FlushPartition(context.Background(), testTopic, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), offset)
+
+	broker.SetLatency(50 * time.Millisecond)
+	type result struct {
+		partition int32
+		offset    int64
+	}
+	done := make(chan result, 1)
+	producer.SendMessageWithCallback(newTestMessage(), func(partition int32, offset int64, err error) {
+		require.NoError(t, err)
+		done <- result{partition, offset}
+	})
+	// the message is waited for whichever partition it goes to
+	_, err = producer.FlushPartition(context.Background(), testTopic, 0)
+	require.NoError(t, err)
+	require.Zero(t, pendingFor(sp, testTopic))
+	sent := <-done
+
+	for _, partition := range []int32{0, 1} {
+		offset, err := producer.FlushPartition(context.Background(), testTopic, partition)
+		require.NoError(t, err)
+		if partition == sent.partition {
+			require.Equal(t, sent.offset, offset)
+		} else {
+			require.Equal(t, int64(-1), offset)
+		}
+	}
+}
+
+func TestSyncProducer_FlushPartition_ContextDone(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	broker.SetLatency(200 * time.Millisecond)
+	done := sendWithCallback(producer, newTestMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = producer.FlushPartition(ctx, testTopic, 0)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,193 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// HeaderCipher encrypts and decrypts record header values. Implementations
+// must be safe for concurrent use.
+type HeaderCipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+type encryptingHeaderSyncProducer struct {
+	decorator
+	headerKeys map[string]struct{}
+	cipher     HeaderCipher
+	// coreEncrypts is set if the messages handed to inner reach a core
+	// producer straight away, which then encrypts the headers it adds
+	coreEncrypts bool
+}
+
+// NewEncryptingHeaderSyncProducer returns a SyncProducer that encrypts the
+// values of the headers named in headerKeys with cipher. Use a
+// DecryptingConsumerInterceptor with the same keys and cipher to restore the
+// plaintext on the consumer side.
+//
+// Each message is handed to inner as a copy with its headers encrypted, so
+// the caller's message keeps its plaintext headers and can be resent as is,
+// and a copy kept by inner, e.g. by a buffering or fallback producer, is
+// still encrypted when it is sent later. If inner hands messages straight to
+// a core producer, through decorators of this package that act on them only
+// before handing them on, the global headers and those added by the header
+// interceptor are encrypted too; otherwise they are sent as they are.
+func NewEncryptingHeaderSyncProducer(inner SyncProducer, headerKeys []string, cipher HeaderCipher) SyncProducer {
+	ep := &encryptingHeaderSyncProducer{
+		headerKeys:   headerKeySet(headerKeys),
+		cipher:       cipher,
+		coreEncrypts: reachesCore(inner),
+	}
+	ep.decorator = newPreSendDecorator(inner, ep)
+	return ep
+}
+
+func headerKeySet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set
+}
+
+// encrypt returns a copy of headers with the values of the configured keys
+// encrypted, except for the headers that are also at the same position in
+// done, which are encrypted already.
+func (ep *encryptingHeaderSyncProducer) encrypt(headers, done []sarama.RecordHeader) ([]sarama.RecordHeader, error) {
+	encrypted := make([]sarama.RecordHeader, len(headers))
+	copy(encrypted, headers)
+	for i, h := range encrypted {
+		if _, ok := ep.headerKeys[string(h.Key)]; !ok {
+			continue
+		}
+		if i < len(done) && bytes.Equal(h.Key, done[i].Key) && bytes.Equal(h.Value, done[i].Value) {
+			continue
+		}
+		value, err := ep.cipher.Encrypt(h.Value)
+		if err != nil {
+			return nil, err
+		}
+		encrypted[i].Value = value
+	}
+	return encrypted, nil
+}
+
+// seal returns the copy of msg to hand to inner, with its headers encrypted
+// and the options attached to msg.
+func (ep *encryptingHeaderSyncProducer) seal(msg *sarama.ProducerMessage) (*sarama.ProducerMessage, error) {
+	headers, err := ep.encrypt(msg.Headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	sealed := *msg
+	sealed.Headers = headers
+	moveMessageOptions(msg, &sealed)
+	if ep.coreEncrypts {
+		addHeaderEncryption(&sealed, func(added []sarama.RecordHeader) ([]sarama.RecordHeader, error) {
+			return ep.encrypt(added, headers)
+		})
+	}
+	return &sealed, nil
+}
+
+// unseal reports the outcome of sending sealed on msg.
+func unseal(msg, sealed *sarama.ProducerMessage) {
+	msg.Partition = sealed.Partition
+	msg.Offset = sealed.Offset
+	takeMessageOptions(sealed)
+}
+
+func (ep *encryptingHeaderSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	sealed, err := ep.seal(msg)
+	if err != nil {
+		return -1, -1, err
+	}
+	defer unseal(msg, sealed)
+	return ep.SyncProducer.SendMessage(sealed)
+}
+
+func (ep *encryptingHeaderSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var failed ProducerErrors
+	sealed := make([]*sarama.ProducerMessage, 0, len(msgs))
+	positions := make(map[*sarama.ProducerMessage]int, len(msgs))
+	for i, msg := range msgs {
+		s, err := ep.seal(msg)
+		if err != nil {
+			failed = append(failed, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+			continue
+		}
+		sealed = append(sealed, s)
+		positions[s] = i
+	}
+	defer func() {
+		for _, s := range sealed {
+			unseal(msgs[positions[s]], s)
+		}
+	}()
+	var err error
+	if len(sealed) > 0 {
+		err = ep.SyncProducer.SendMessages(sealed)
+	}
+	if err != nil {
+		var pErrs ProducerErrors
+		if !errors.As(err, &pErrs) {
+			return err
+		}
+		// report the caller's messages rather than their sealed copies
+		for _, pErr := range pErrs {
+			i, ok := positions[pErr.Msg]
+			if !ok && pErr.BatchIndex >= 0 && pErr.BatchIndex < len(sealed) {
+				i, ok = positions[sealed[pErr.BatchIndex]]
+			}
+			if ok {
+				failed = append(failed, &ProducerError{Msg: msgs[i], Err: pErr.Err, BatchIndex: i})
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// DecryptingConsumerInterceptor is a ConsumerInterceptor that decrypts the
+// header values encrypted by a SyncProducer created with
+// NewEncryptingHeaderSyncProducer. Headers that fail to decrypt are left as
+// they are and the failure is logged.
+type DecryptingConsumerInterceptor struct {
+	headerKeys map[string]struct{}
+	cipher     HeaderCipher
+	logger     log.Logger
+}
+
+// NewDecryptingConsumerInterceptor returns an interceptor decrypting the
+// headers named in headerKeys with cipher, logging failures to logger. Add
+// it to Config.Consumer.Interceptors.
+func NewDecryptingConsumerInterceptor(headerKeys []string, cipher HeaderCipher, logger log.Logger) *DecryptingConsumerInterceptor {
+	return &DecryptingConsumerInterceptor{
+		headerKeys: headerKeySet(headerKeys),
+		cipher:     cipher,
+		logger:     logger,
+	}
+}
+
+var _ sarama.ConsumerInterceptor = (*DecryptingConsumerInterceptor)(nil)
+
+func (di *DecryptingConsumerInterceptor) OnConsume(msg *sarama.ConsumerMessage) {
+	for _, h := range msg.Headers {
+		if _, ok := di.headerKeys[string(h.Key)]; !ok {
+			continue
+		}
+		value, err := di.cipher.Decrypt(h.Value)
+		if err != nil {
+			level.Warn(di.logger).Log("msg", "failed to decrypt header", "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset, "header", string(h.Key), "err", err)
+			continue
+		}
+		h.Value = value
+	}
+}
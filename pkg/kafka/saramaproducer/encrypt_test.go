@@ -0,0 +1,141 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// prefixCipher "encrypts" values by prefixing them with "enc:".
+type prefixCipher struct{}
+
+func (prefixCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	return append([]byte("enc:"), plaintext...), nil
+}
+
+func (prefixCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte("enc:")) {
+		return nil, errors.New("not encrypted")
+	}
+	return ciphertext[len("enc:"):], nil
+}
+
+// headerRecorder records the headers of every message handed to the async
+// producer.
+type headerRecorder struct {
+	lock    sync.Mutex
+	headers map[string]string
+}
+
+func (hr *headerRecorder) OnSend(msg *sarama.ProducerMessage) {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	hr.headers = make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		hr.headers[string(h.Key)] = string(h.Value)
+	}
+}
+
+func (hr *headerRecorder) sent() map[string]string {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	return hr.headers
+}
+
+func newEncryptingTestSyncProducer(t *testing.T) (SyncProducer, *headerRecorder) {
+	t.Helper()
+
+	recorder := &headerRecorder{}
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	inner, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, inner.Close()) })
+
+	inner.AddHeadersGlobally(sarama.RecordHeader{Key: []byte("global"), Value: []byte("g")})
+	inner.SetHeaderInterceptor(func(_ string, existing []sarama.RecordHeader) []sarama.RecordHeader {
+		return append(existing, sarama.RecordHeader{Key: []byte("intercepted"), Value: []byte("i")})
+	})
+	return NewEncryptingHeaderSyncProducer(inner, []string{"secret", "global", "intercepted"}, prefixCipher{}), recorder
+}
+
+func TestEncryptingHeaderSyncProducer_EncryptsAddedHeaders(t *testing.T) {
+	producer, recorder := newEncryptingTestSyncProducer(t)
+
+	msg := newTestMessage()
+	msg.Headers = []sarama.RecordHeader{
+		{Key: []byte("secret"), Value: []byte("s")},
+		{Key: []byte("public"), Value: []byte("p")},
+	}
+	_, _, err := producer.SendMessageWithSchema(msg, 1, 1)
+	require.NoError(t, err)
+
+	sent := recorder.sent()
+	require.Equal(t, "enc:s", sent["secret"])
+	require.Equal(t, "p", sent["public"])
+	require.Equal(t, "enc:g", sent["global"])
+	require.Equal(t, "enc:i", sent["intercepted"])
+}
+
+func TestEncryptingHeaderSyncProducer_Resend(t *testing.T) {
+	producer, recorder := newEncryptingTestSyncProducer(t)
+
+	msg := newTestMessage()
+	msg.Headers = []sarama.RecordHeader{{Key: []byte("secret"), Value: []byte("s")}}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, producer.SendMessages([]*sarama.ProducerMessage{msg}))
+		require.Equal(t, "enc:s", recorder.sent()["secret"])
+		require.Equal(t, "s", string(msg.Headers[0].Value))
+	}
+}
+
+func TestEncryptingHeaderSyncProducer_BufferedMessagesStayEncrypted(t *testing.T) {
+	inner := &blockingSyncProducer{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	close(inner.release)
+	buffering := NewBufferingSyncProducer(inner, 1, time.Hour)
+	producer := NewEncryptingHeaderSyncProducer(buffering, []string{"secret"}, prefixCipher{})
+
+	msg := newTestMessage()
+	msg.Headers = []sarama.RecordHeader{{Key: []byte("secret"), Value: []byte("s")}}
+	// inner fails the message, which is buffered
+	_, _, err := producer.SendMessage(msg)
+	require.NoError(t, err)
+	require.Equal(t, "s", string(msg.Headers[0].Value))
+
+	buffering.(*bufferingSyncProducer).flush()
+	require.Len(t, inner.sent, 1)
+	require.NotSame(t, msg, inner.sent[0])
+	require.Equal(t, "enc:s", string(inner.sent[0].Headers[0].Value))
+	require.NoError(t, producer.Close())
+}
+
+func TestEncryptingHeaderSyncProducer_ForeignInner(t *testing.T) {
+	inner := &blockingSyncProducer{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	close(inner.release)
+	producer := NewEncryptingHeaderSyncProducer(inner, []string{"secret"}, prefixCipher{})
+
+	msg := newTestMessage()
+	msg.Headers = []sarama.RecordHeader{{Key: []byte("secret"), Value: []byte("s")}}
+	require.NoError(t, producer.SendMessages([]*sarama.ProducerMessage{msg}))
+	require.Equal(t, "enc:s", string(inner.sent[0].Headers[0].Value))
+	require.Equal(t, "s", string(msg.Headers[0].Value))
+}
+
+func TestDecryptingConsumerInterceptor(t *testing.T) {
+	interceptor := NewDecryptingConsumerInterceptor([]string{"secret"}, prefixCipher{}, log.NewNopLogger())
+
+	msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("secret"), Value: []byte("enc:s")},
+		{Key: []byte("public"), Value: []byte("enc:p")},
+	}}
+	interceptor.OnConsume(msg)
+	require.Equal(t, "s", string(msg.Headers[0].Value))
+	require.Equal(t, "enc:p", string(msg.Headers[1].Value))
+}
@@ -0,0 +1,97 @@
+package saramaproducer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+var (
+	// ErrTxnAlreadyStarted is returned by BeginTxn while a transaction is
+	// already in progress.
+	ErrTxnAlreadyStarted = errors.New("transaction manager: transaction already started")
+
+	// ErrTxnDone is returned when a method is called on a Transaction that has
+	// already been committed or aborted.
+	ErrTxnDone = errors.New("transaction manager: transaction has already been committed or aborted")
+
+	// ErrNotSupported is returned when a requested operation or setting change
+	// is not supported by the producer at runtime.
+	ErrNotSupported = errors.New("kafka: operation not supported")
+
+	// ErrBothFailed is returned by SyncProducer.SendMessageWithFallback when
+	// both the primary and the fallback message failed to be produced.
+	ErrBothFailed = errors.New("kafka: both the primary and the fallback message failed to produce")
+
+	// ErrAdminAPINotSupported is returned when an admin operation needs an API
+	// that the configured Version or the brokers do not support.
+	ErrAdminAPINotSupported = errors.New("kafka: admin API not supported by the broker")
+
+	// ErrTopicClosed is returned when a message is sent to a topic that has
+	// been closed with SyncProducer.CloseTopicProducer.
+	ErrTopicClosed = errors.New("kafka: topic closed for production")
+
+	// ErrNoBrokerForPartition is returned by SyncProducer.BrokerFor when no
+	// leader is known for the requested partition.
+	ErrNoBrokerForPartition = errors.New("kafka: no leader broker found for partition")
+
+	// ErrCannotReduce is returned by SyncProducer.SetMaxInflight when more
+	// messages are already in flight than the requested limit.
+	ErrCannotReduce = errors.New("kafka: cannot reduce the in-flight limit below the number of messages in flight")
+
+	// ErrMessageNotConsumed is returned by SyncProducer.SendMessageAndConsume
+	// when the produced record could not be consumed back in time.
+	ErrMessageNotConsumed = errors.New("kafka: produced message could not be consumed back")
+
+	// ErrTopicNotFound is returned by SyncProducer.DeleteTopic when the topic
+	// does not exist.
+	ErrTopicNotFound = errors.New("kafka: topic not found")
+
+	// ErrDeleteTopicNotEnabled is returned by SyncProducer.DeleteTopic when the
+	// brokers are configured with delete.topic.enable=false.
+	ErrDeleteTopicNotEnabled = errors.New("kafka: topic deletion is disabled on the brokers")
+
+	// ErrSLAViolated is returned by SyncProducer.SendMessageWithSLA when a
+	// message was not acknowledged within the requested latency.
+	ErrSLAViolated = errors.New("kafka: produce did not complete within the SLA")
+)
+
+// ProducerError is the error returned for a message that failed to be
+// produced. It mirrors sarama.ProducerError with the position of the message
+// in the batch it was sent with.
+type ProducerError struct {
+	Msg *sarama.ProducerMessage
+	Err error
+	// BatchIndex is the position of Msg in the slice passed to
+	// SyncProducer.SendMessages, so errors can be correlated with the input
+	// without comparing messages. It is 0 for messages sent on their own.
+	BatchIndex int
+}
+
+func (pe ProducerError) Error() string {
+	return fmt.Sprintf("kafka: Failed to produce message to topic %s: %s", pe.Msg.Topic, pe.Err)
+}
+
+func (pe ProducerError) Unwrap() error {
+	return pe.Err
+}
+
+// ProducerErrors is the error returned by SyncProducer.SendMessages and the
+// other batch methods when some of the messages failed. It replaces
+// sarama.ProducerErrors, which SendMessages returned before BatchIndex was
+// added: callers matching that type with errors.As must match this one
+// instead. errors.Is and errors.As also look into the error of each message.
+type ProducerErrors []*ProducerError
+
+func (pe ProducerErrors) Error() string {
+	return fmt.Sprintf("kafka: Failed to deliver %d messages.", len(pe))
+}
+
+func (pe ProducerErrors) Unwrap() []error {
+	errs := make([]error, len(pe))
+	for i, pErr := range pe {
+		errs[i] = pErr
+	}
+	return errs
+}
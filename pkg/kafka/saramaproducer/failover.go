@@ -0,0 +1,397 @@
+package saramaproducer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// FailoverStrategy selects the producer a FailoverSyncProducer switches to
+// once the active producer is considered unhealthy.
+type FailoverStrategy int
+
+const (
+	// FailoverPriority treats the producers as a priority list: on failure
+	// the first producer in the list that has not failed within
+	// FailoverPolicy.FailbackInterval becomes active, or the first one other
+	// than the failed producer if all of them have. Once the interval has
+	// elapsed, the active producer is given up for a higher-priority one
+	// again.
+	FailoverPriority FailoverStrategy = iota
+	// FailoverRoundRobin cycles through the producers in order, switching to
+	// the producer after the failed one and never failing back.
+	FailoverRoundRobin
+)
+
+// FailoverPolicy controls when a FailoverSyncProducer switches to another
+// producer and which producer it switches to.
+type FailoverPolicy struct {
+	// The number of consecutive failed sends after which the active producer
+	// is abandoned (defaults to 1 when zero or negative).
+	MaxConsecutiveErrors int
+	// Sends that take longer than this are counted as failures even if they
+	// succeeded. Zero disables the latency check.
+	LatencyThreshold time.Duration
+	// How the next producer is selected (defaults to FailoverPriority).
+	Strategy FailoverStrategy
+	// With FailoverPriority, how long a producer that failed is passed over
+	// before it is preferred again. Zero disables failback.
+	FailbackInterval time.Duration
+	// OnFailover, if set, is called every time the active producer changes,
+	// with the indexes of the previous and new producers and the error that
+	// triggered the switch (ErrSlowProduce for latency violations, nil for
+	// failbacks). It is called synchronously from the calling goroutine, once
+	// the switch is done and no lock is held, so it may use the producer.
+	// Switches made by concurrent sends may be reported concurrently.
+	OnFailover func(from, to int, err error)
+}
+
+// ErrSlowProduce is passed to FailoverPolicy.OnFailover when a failover was
+// triggered by FailoverPolicy.LatencyThreshold rather than a produce error.
+var ErrSlowProduce = errors.New("kafka: produce exceeded the failover latency threshold")
+
+type failoverSyncProducer struct {
+	// the primary producer serves all methods that are not failover-aware
+	decorator
+
+	producers []SyncProducer
+	policy    FailoverPolicy
+	logger    log.Logger
+
+	lock     sync.Mutex
+	active   int
+	failures int
+	// failedAt holds when each producer was last failed over from
+	failedAt []time.Time
+	// txn is the index of the producer a transaction was begun on, or -1.
+	// The active producer does not change while it is set.
+	txn int
+
+	// txnLock serializes beginning and ending transactions
+	txnLock sync.Mutex
+}
+
+// NewFailoverSyncProducer returns a SyncProducer that sends through the first of
+// producers until policy decides it is unhealthy, and then fails over to the
+// others. A message whose send triggers a failover is not resent; its error is
+// returned to the caller and subsequent sends use the new producer.
+//
+// Every send method and the transaction methods go to the active producer,
+// and Close closes all of them; every other method is served by the first
+// producer. A transaction stays on the producer it was begun on: the active
+// producer does not change until it is committed or aborted, and a failover
+// due in the meantime happens once it has ended. Failovers and failbacks are
+// logged to the logger of the first producer. NewFailoverSyncProducer returns
+// a ConfigurationError if producers is empty.
+func NewFailoverSyncProducer(producers []SyncProducer, policy FailoverPolicy) (SyncProducer, error) {
+	if len(producers) == 0 {
+		return nil, sarama.ConfigurationError("NewFailoverSyncProducer requires at least one producer")
+	}
+	if policy.MaxConsecutiveErrors <= 0 {
+		policy.MaxConsecutiveErrors = 1
+	}
+	fp := &failoverSyncProducer{
+		producers: producers,
+		policy:    policy,
+		failedAt:  make([]time.Time, len(producers)),
+		txn:       -1,
+	}
+	fp.decorator = newDecorator(producers[0], fp)
+	fp.logger = fp.decorator.coreLogger()
+	return fp, nil
+}
+
+// current returns the producer to use, failing back first if the policy says
+// so.
+func (fp *failoverSyncProducer) current() (int, SyncProducer) {
+	fp.lock.Lock()
+	switched := fp.failbackLocked()
+	idx, p := fp.active, fp.producers[fp.active]
+	fp.lock.Unlock()
+
+	fp.notify(switched)
+	return idx, p
+}
+
+// failbackLocked switches to the highest-priority producer whose
+// FailbackInterval has elapsed, if it comes before the active one. It must be
+// called with fp.lock held.
+func (fp *failoverSyncProducer) failbackLocked() *failover {
+	if fp.policy.Strategy != FailoverPriority || fp.policy.FailbackInterval <= 0 || fp.txn >= 0 {
+		return nil
+	}
+	for idx := 0; idx < fp.active; idx++ {
+		if time.Since(fp.failedAt[idx]) >= fp.policy.FailbackInterval {
+			level.Info(fp.logger).Log("msg", "failing back to a higher-priority producer", "from", fp.active, "to", idx)
+			return fp.switchTo(idx, nil)
+		}
+	}
+	return nil
+}
+
+// core returns the core producer of the active producer, so that methods
+// needing one use the cluster messages are currently sent to.
+func (fp *failoverSyncProducer) core() *syncProducer {
+	_, p := fp.current()
+	if c, ok := p.(producerCore); ok {
+		return c.core()
+	}
+	return nil
+}
+
+// record updates the health of producer idx after a send that took elapsed and
+// returned err, failing over if the policy says so.
+func (fp *failoverSyncProducer) record(idx int, elapsed time.Duration, err error) {
+	if err == nil && fp.policy.LatencyThreshold > 0 && elapsed > fp.policy.LatencyThreshold {
+		err = ErrSlowProduce
+	}
+	fp.notify(fp.updateHealth(idx, err))
+}
+
+func (fp *failoverSyncProducer) updateHealth(idx int, err error) *failover {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+
+	if idx != fp.active {
+		// another goroutine already failed over
+		return nil
+	}
+	if err == nil {
+		fp.failures = 0
+		return nil
+	}
+
+	fp.failures++
+	return fp.failoverLocked(err)
+}
+
+// failoverLocked switches to the next producer if the active one has failed
+// often enough and no transaction holds it. It must be called with fp.lock
+// held.
+func (fp *failoverSyncProducer) failoverLocked(err error) *failover {
+	if fp.failures < fp.policy.MaxConsecutiveErrors || len(fp.producers) == 1 || fp.txn >= 0 {
+		return nil
+	}
+
+	fp.failedAt[fp.active] = time.Now()
+	next := fp.nextLocked()
+	level.Warn(fp.logger).Log("msg", "failing over to another producer", "from", fp.active, "to", next, "err", err)
+	return fp.switchTo(next, err)
+}
+
+// nextLocked returns the producer to fail over to from the active one. It
+// must be called with fp.lock held.
+func (fp *failoverSyncProducer) nextLocked() int {
+	if fp.policy.Strategy == FailoverRoundRobin {
+		return (fp.active + 1) % len(fp.producers)
+	}
+
+	fallback := -1
+	for idx := range fp.producers {
+		if idx == fp.active {
+			continue
+		}
+		if fp.failedAt[idx].IsZero() || (fp.policy.FailbackInterval > 0 && time.Since(fp.failedAt[idx]) >= fp.policy.FailbackInterval) {
+			return idx
+		}
+		if fallback < 0 {
+			fallback = idx
+		}
+	}
+	return fallback
+}
+
+// failover is a change of the active producer, reported to
+// FailoverPolicy.OnFailover by notify once fp.lock has been released.
+type failover struct {
+	from, to int
+	err      error
+}
+
+// switchTo must be called with fp.lock held.
+func (fp *failoverSyncProducer) switchTo(idx int, err error) *failover {
+	prev := fp.active
+	fp.active = idx
+	fp.failures = 0
+	return &failover{from: prev, to: idx, err: err}
+}
+
+// notify reports switched to FailoverPolicy.OnFailover, if both are set. It
+// must be called without fp.lock held.
+func (fp *failoverSyncProducer) notify(switched *failover) {
+	if switched != nil && fp.policy.OnFailover != nil {
+		fp.policy.OnFailover(switched.from, switched.to, switched.err)
+	}
+}
+
+func (fp *failoverSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	idx, p := fp.current()
+	start := time.Now()
+	partition, offset, err = p.SendMessage(msg)
+	fp.record(idx, time.Since(start), err)
+	return partition, offset, err
+}
+
+func (fp *failoverSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	idx, p := fp.current()
+	start := time.Now()
+	err := p.SendMessages(msgs)
+	fp.record(idx, time.Since(start), err)
+	return err
+}
+
+// The methods below do not send through SendMessage or SendMessages, so they
+// pick the active producer themselves.
+
+func (fp *failoverSyncProducer) SendMessageBatch(msgs []*sarama.ProducerMessage) ([]ProducerResult, error) {
+	idx, p := fp.current()
+	start := time.Now()
+	results, err := p.SendMessageBatch(msgs)
+	fp.record(idx, time.Since(start), err)
+	return results, err
+}
+
+func (fp *failoverSyncProducer) ProduceRawBatch(topic string, partition int32, batch []byte) error {
+	idx, p := fp.current()
+	start := time.Now()
+	err := p.ProduceRawBatch(topic, partition, batch)
+	fp.record(idx, time.Since(start), err)
+	return err
+}
+
+func (fp *failoverSyncProducer) SendMessagesBinary(rawMessages [][]byte, topic string, partition int32) ([]int64, error) {
+	idx, p := fp.current()
+	start := time.Now()
+	offsets, err := p.SendMessagesBinary(rawMessages, topic, partition)
+	fp.record(idx, time.Since(start), err)
+	return offsets, err
+}
+
+func (fp *failoverSyncProducer) Barrier(ctx context.Context) (map[string]map[int32]int64, error) {
+	_, p := fp.current()
+	return p.Barrier(ctx)
+}
+
+func (fp *failoverSyncProducer) Close() error {
+	var errs []error
+	for _, p := range fp.producers {
+		if err := p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// txnProducer returns the producer the open transaction was begun on, or
+// the active producer if there is none.
+func (fp *failoverSyncProducer) txnProducer() SyncProducer {
+	fp.lock.Lock()
+	txn := fp.txn
+	fp.lock.Unlock()
+	if txn >= 0 {
+		return fp.producers[txn]
+	}
+	_, p := fp.current()
+	return p
+}
+
+func (fp *failoverSyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return fp.txnProducer().TxnStatus()
+}
+
+func (fp *failoverSyncProducer) IsTransactional() bool {
+	return fp.txnProducer().IsTransactional()
+}
+
+func (fp *failoverSyncProducer) TransactionalID() string {
+	return fp.txnProducer().TransactionalID()
+}
+
+// beginTxn begins a transaction with begin on the active producer and binds
+// it to that producer.
+func (fp *failoverSyncProducer) beginTxn(begin func(SyncProducer) error) error {
+	fp.txnLock.Lock()
+	defer fp.txnLock.Unlock()
+
+	fp.lock.Lock()
+	if fp.txn >= 0 {
+		// the producer reports the transaction already in progress
+		p := fp.producers[fp.txn]
+		fp.lock.Unlock()
+		return begin(p)
+	}
+	switched := fp.failbackLocked()
+	fp.txn = fp.active
+	p := fp.producers[fp.txn]
+	fp.lock.Unlock()
+	fp.notify(switched)
+
+	if err := begin(p); err != nil {
+		fp.unbindTxn()
+		return err
+	}
+	return nil
+}
+
+// endTxn ends the open transaction with end on the producer it is bound to,
+// unbinding it once that producer is no longer in a transaction.
+func (fp *failoverSyncProducer) endTxn(end func(SyncProducer) error) error {
+	fp.txnLock.Lock()
+	defer fp.txnLock.Unlock()
+
+	p := fp.txnProducer()
+	err := end(p)
+	if err != nil {
+		status := p.TxnStatus()
+		if status&sarama.ProducerTxnFlagFatalError == 0 &&
+			status&(sarama.ProducerTxnFlagInTransaction|sarama.ProducerTxnFlagAbortableError) != 0 {
+			// the transaction is still open and must be aborted on p
+			return err
+		}
+	}
+	fp.unbindTxn()
+	return err
+}
+
+// unbindTxn releases the active producer from the transaction, failing over
+// if sends failed while it was held.
+func (fp *failoverSyncProducer) unbindTxn() {
+	fp.lock.Lock()
+	fp.txn = -1
+	switched := fp.failoverLocked(errors.New("failover deferred until the end of a transaction"))
+	fp.lock.Unlock()
+	fp.notify(switched)
+}
+
+func (fp *failoverSyncProducer) BeginTxn() error {
+	return fp.beginTxn(func(p SyncProducer) error { return p.BeginTxn() })
+}
+
+func (fp *failoverSyncProducer) BeginTxnWithTimeout(ctx context.Context) error {
+	return fp.beginTxn(func(p SyncProducer) error { return p.BeginTxnWithTimeout(ctx) })
+}
+
+func (fp *failoverSyncProducer) CommitTxn() error {
+	return fp.endTxn(func(p SyncProducer) error { return p.CommitTxn() })
+}
+
+func (fp *failoverSyncProducer) AbortTxn() error {
+	return fp.endTxn(func(p SyncProducer) error { return p.AbortTxn() })
+}
+
+func (fp *failoverSyncProducer) AbortTxnWithReason(ctx context.Context, reason string, reasonTopic string) error {
+	return fp.endTxn(func(p SyncProducer) error { return p.AbortTxnWithReason(ctx, reason, reasonTopic) })
+}
+
+func (fp *failoverSyncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
+	return fp.txnProducer().AddOffsetsToTxn(offsets, groupId)
+}
+
+func (fp *failoverSyncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error {
+	return fp.txnProducer().AddMessageToTxn(msg, groupId, metadata)
+}
@@ -0,0 +1,197 @@
+package saramaproducer
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// stubSyncProducer answers SendMessage and SendMessages with err, counting
+// the messages, and tracks transactions begun and committed on it. Its other
+// methods, except TransactionalID and Close, panic.
+type stubSyncProducer struct {
+	SyncProducer
+	err    error
+	sent   atomic.Int64
+	closed atomic.Int64
+
+	inTxn     atomic.Bool
+	committed atomic.Int64
+}
+
+func (sp *stubSyncProducer) SendMessage(*sarama.ProducerMessage) (int32, int64, error) {
+	sp.sent.Add(1)
+	if sp.err != nil {
+		return -1, -1, sp.err
+	}
+	return 0, 0, nil
+}
+
+func (sp *stubSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	sp.sent.Add(int64(len(msgs)))
+	return sp.err
+}
+
+func (sp *stubSyncProducer) BeginTxn() error {
+	if !sp.inTxn.CompareAndSwap(false, true) {
+		return ErrTxnAlreadyStarted
+	}
+	return nil
+}
+
+func (sp *stubSyncProducer) CommitTxn() error {
+	if !sp.inTxn.CompareAndSwap(true, false) {
+		return sarama.ErrTransactionNotReady
+	}
+	sp.committed.Add(1)
+	return nil
+}
+
+func (sp *stubSyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	if sp.inTxn.Load() {
+		return sarama.ProducerTxnFlagInTransaction
+	}
+	return sarama.ProducerTxnFlagReady
+}
+
+func (sp *stubSyncProducer) TransactionalID() string {
+	return ""
+}
+
+func (sp *stubSyncProducer) Close() error {
+	sp.closed.Add(1)
+	return nil
+}
+
+func TestNewFailoverSyncProducer_NoProducers(t *testing.T) {
+	_, err := NewFailoverSyncProducer(nil, FailoverPolicy{})
+	require.ErrorAs(t, err, new(sarama.ConfigurationError))
+}
+
+func TestFailoverSyncProducer_SendMethodsUseActiveProducer(t *testing.T) {
+	primary := &stubSyncProducer{err: errors.New("down")}
+	secondary := &stubSyncProducer{}
+	producer, err := NewFailoverSyncProducer([]SyncProducer{primary, secondary}, FailoverPolicy{})
+	require.NoError(t, err)
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.Error(t, err)
+
+	_, _, err = producer.SendMessageWithSchema(newTestMessage(), 1, 1)
+	require.NoError(t, err)
+	_, _, err = producer.SendMessageWithSLA(newTestMessage(), time.Second)
+	require.NoError(t, err)
+	require.NoError(t, producer.SendMessagesSequential([]*sarama.ProducerMessage{newTestMessage(), newTestMessage()}))
+	require.Equal(t, int64(1), primary.sent.Load())
+	require.Equal(t, int64(4), secondary.sent.Load())
+}
+
+func TestFailoverSyncProducer_OnFailoverMayUseProducer(t *testing.T) {
+	var producer SyncProducer
+	switched := make(chan int, 1)
+	policy := FailoverPolicy{OnFailover: func(_, to int, _ error) {
+		// would deadlock if called with the producer's lock held
+		_ = producer.TransactionalID()
+		switched <- to
+	}}
+	producer, err := NewFailoverSyncProducer([]SyncProducer{&stubSyncProducer{err: errors.New("down")}, &stubSyncProducer{}}, policy)
+	require.NoError(t, err)
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.Error(t, err)
+	select {
+	case to := <-switched:
+		require.Equal(t, 1, to)
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnFailover was not called")
+	}
+}
+
+func TestFailoverSyncProducer_Strategies(t *testing.T) {
+	for _, tc := range []struct {
+		strategy FailoverStrategy
+		want     int
+	}{
+		// producer 1 failed recently, so the next one in the list is used
+		{FailoverPriority, 2},
+		// the producer after the failed one is used regardless
+		{FailoverRoundRobin, 1},
+	} {
+		producers := []SyncProducer{&stubSyncProducer{err: errors.New("down")}, &stubSyncProducer{}, &stubSyncProducer{}}
+		switched := -1
+		policy := FailoverPolicy{
+			Strategy:         tc.strategy,
+			FailbackInterval: time.Hour,
+			OnFailover:       func(_, to int, _ error) { switched = to },
+		}
+		producer, err := NewFailoverSyncProducer(producers, policy)
+		require.NoError(t, err)
+		producer.(*failoverSyncProducer).failedAt[1] = time.Now()
+
+		_, _, err = producer.SendMessage(newTestMessage())
+		require.Error(t, err)
+		require.Equal(t, tc.want, switched, "strategy %d", tc.strategy)
+	}
+}
+
+func TestFailoverSyncProducer_PriorityFailsBack(t *testing.T) {
+	primary := &stubSyncProducer{err: errors.New("down")}
+	secondary := &stubSyncProducer{}
+	policy := FailoverPolicy{FailbackInterval: 50 * time.Millisecond}
+	producer, err := NewFailoverSyncProducer([]SyncProducer{primary, secondary}, policy)
+	require.NoError(t, err)
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.Error(t, err)
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), secondary.sent.Load())
+
+	primary.err = nil
+	time.Sleep(policy.FailbackInterval)
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), primary.sent.Load())
+
+	// round-robin never fails back
+	primary.err = errors.New("down")
+	policy.Strategy = FailoverRoundRobin
+	producer, err = NewFailoverSyncProducer([]SyncProducer{primary, secondary}, policy)
+	require.NoError(t, err)
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.Error(t, err)
+	primary.err = nil
+	time.Sleep(policy.FailbackInterval)
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), secondary.sent.Load())
+}
+
+func TestFailoverSyncProducer_TransactionStaysOnItsProducer(t *testing.T) {
+	primary := &stubSyncProducer{}
+	secondary := &stubSyncProducer{}
+	producer, err := NewFailoverSyncProducer([]SyncProducer{primary, secondary}, FailoverPolicy{})
+	require.NoError(t, err)
+
+	require.NoError(t, producer.BeginTxn())
+	primary.err = errors.New("down")
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.Error(t, err)
+	// the failover waits for the transaction to end
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.Error(t, err)
+	require.Equal(t, int64(2), primary.sent.Load())
+	require.ErrorIs(t, producer.BeginTxn(), ErrTxnAlreadyStarted)
+
+	require.NoError(t, producer.CommitTxn())
+	require.Equal(t, int64(1), primary.committed.Load())
+	require.False(t, secondary.inTxn.Load())
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), secondary.sent.Load())
+}
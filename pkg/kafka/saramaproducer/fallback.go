@@ -0,0 +1,95 @@
+package saramaproducer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// FallbackStore keeps messages that could not be produced so that they can be
+// resent later. Implementations must be safe for concurrent use.
+type FallbackStore interface {
+	// Store persists msg. Once it returns nil, the message must survive until
+	// it is replayed.
+	Store(msg *sarama.ProducerMessage) error
+
+	// Replay resends the stored messages through producer, removing each
+	// one once it has been produced, and returns the first error
+	// encountered or ctx's error if ctx is done.
+	Replay(ctx context.Context, producer SyncProducer) error
+}
+
+type fallbackSyncProducer struct {
+	decorator
+	fallback FallbackStore
+	logger   log.Logger
+}
+
+// NewFallbackSyncProducer returns a SyncProducer that hands messages that
+// primary fails to produce to fallback instead of returning the error. A
+// message stored this way is reported as sent with partition and offset -1;
+// the error is only returned if fallback fails to store it too. Call
+// fallback.Replay with primary once the cluster is reachable again to resend
+// the stored messages. Every stored message is logged to the logger of
+// primary.
+func NewFallbackSyncProducer(primary SyncProducer, fallback FallbackStore) SyncProducer {
+	fp := &fallbackSyncProducer{fallback: fallback}
+	fp.decorator = newDecorator(primary, fp)
+	fp.logger = fp.decorator.coreLogger()
+	return fp
+}
+
+// store hands msg to the fallback store, returning the primary's error
+// joined with the store's if both fail.
+func (fp *fallbackSyncProducer) store(msg *sarama.ProducerMessage, err error) error {
+	storeErr := fp.fallback.Store(msg)
+	if storeErr != nil {
+		return errors.Join(err, storeErr)
+	}
+	level.Warn(fp.logger).Log("msg", "stored message in fallback store after it failed to send", "topic", msg.Topic, "err", err)
+	return nil
+}
+
+func (fp *fallbackSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	partition, offset, err = fp.SyncProducer.SendMessage(msg)
+	if err == nil {
+		return partition, offset, nil
+	}
+	if err := fp.store(msg, err); err != nil {
+		return -1, -1, err
+	}
+	msg.Partition, msg.Offset = -1, -1
+	return -1, -1, nil
+}
+
+func (fp *fallbackSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	err := fp.SyncProducer.SendMessages(msgs)
+	if err == nil {
+		return nil
+	}
+
+	var pErrs ProducerErrors
+	if !errors.As(err, &pErrs) {
+		// the batch failed as a whole
+		pErrs = make(ProducerErrors, len(msgs))
+		for i, msg := range msgs {
+			pErrs[i] = &ProducerError{Msg: msg, Err: err, BatchIndex: i}
+		}
+	}
+
+	var remaining ProducerErrors
+	for _, pErr := range pErrs {
+		if storeErr := fp.store(pErr.Msg, pErr.Err); storeErr != nil {
+			remaining = append(remaining, &ProducerError{Msg: pErr.Msg, Err: storeErr, BatchIndex: pErr.BatchIndex})
+			continue
+		}
+		pErr.Msg.Partition, pErr.Msg.Offset = -1, -1
+	}
+	if len(remaining) > 0 {
+		return remaining
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipSyncProducer_SendMessageGzipBody(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewGzipSyncProducer(inner)
+
+	value := bytes.Repeat([]byte("a long log line "), 64)
+	_, _, err := producer.SendMessageGzipBody(testTopic, []byte("key"), value)
+	require.NoError(t, err)
+
+	sent := recorder.messages()
+	require.Len(t, sent, 1)
+	msg := consumed(t, sent[0])
+	require.Equal(t, []byte("key"), msg.Key)
+	require.Less(t, len(msg.Value), len(value))
+	decompressed, err := DecompressConsumerMessage(msg)
+	require.NoError(t, err)
+	require.Equal(t, value, decompressed)
+}
+
+func TestDecompressConsumerMessage(t *testing.T) {
+	plain := &sarama.ConsumerMessage{Value: []byte("foo")}
+	value, err := DecompressConsumerMessage(plain)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), value)
+
+	unsupported := &sarama.ConsumerMessage{
+		Value:   []byte("foo"),
+		Headers: []*sarama.RecordHeader{{Key: []byte(ContentEncodingHeader), Value: []byte("br")}},
+	}
+	_, err = DecompressConsumerMessage(unsupported)
+	require.ErrorContains(t, err, "unsupported content-encoding")
+}
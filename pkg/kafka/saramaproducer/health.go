@@ -0,0 +1,106 @@
+package saramaproducer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const defaultBrokerHealthInterval = 10 * time.Second
+
+// BrokerHealthEvent reports a change in the availability of a broker, see
+// SyncProducer.WatchBrokerHealth.
+type BrokerHealthEvent struct {
+	BrokerAddr string
+	Available  bool
+	// Latency is the round-trip time of the probe that detected the change,
+	// including the time taken to connect if needed.
+	Latency time.Duration
+	// Err is the error of the failed probe when Available is false.
+	Err error
+}
+
+// WithBrokerHealthInterval sets how often SyncProducer.WatchBrokerHealth
+// probes the brokers (default 10s).
+func WithBrokerHealthInterval(interval time.Duration) SyncProducerOption {
+	return func(sp *syncProducer) {
+		sp.brokerHealthInterval = interval
+	}
+}
+
+func (sp *syncProducer) WatchBrokerHealth(ctx context.Context) <-chan BrokerHealthEvent {
+	interval := sp.brokerHealthInterval
+	if interval <= 0 {
+		interval = defaultBrokerHealthInterval
+	}
+
+	events := make(chan BrokerHealthEvent)
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		available := make(map[string]bool)
+		for {
+			for _, event := range sp.probeBrokers() {
+				if was, known := available[event.BrokerAddr]; known && was == event.Available {
+					continue
+				}
+				available[event.BrokerAddr] = event.Available
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				case <-sp.closing:
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			case <-sp.closing:
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// probeBrokers sends a metadata request for no topics to every broker known
+// to the client, concurrently, and reports the outcome for each.
+func (sp *syncProducer) probeBrokers() []BrokerHealthEvent {
+	conf := sp.conf
+	brokers := sp.client.Brokers()
+	events := make([]BrokerHealthEvent, len(brokers))
+
+	var wg sync.WaitGroup
+	for i, broker := range brokers {
+		i, broker := i, broker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			_ = broker.Open(conf) // ErrAlreadyConnected if already open
+			_, err := broker.GetMetadata(sarama.NewMetadataRequest(conf.Version, []string{}))
+			if err != nil {
+				// a connection left waiting for a lost response would fail
+				// every later probe, so the next one reconnects
+				_ = broker.Close()
+			}
+			events[i] = BrokerHealthEvent{
+				BrokerAddr: broker.Addr(),
+				Available:  err == nil,
+				Latency:    time.Since(start),
+				Err:        err,
+			}
+		}()
+	}
+	wg.Wait()
+	return events
+}
@@ -0,0 +1,45 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncProducer_WatchBrokerHealth(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.Net.ReadTimeout = 50 * time.Millisecond
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config, WithBrokerHealthInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := producer.WatchBrokerHealth(ctx)
+
+	event := <-events
+	require.Equal(t, broker.Addr(), event.BrokerAddr)
+	require.True(t, event.Available)
+	require.NoError(t, event.Err)
+
+	// the broker stops answering metadata requests, and then again
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{})
+	event = <-events
+	require.False(t, event.Available)
+	require.Error(t, event.Err)
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{"MetadataRequest": metadata})
+	event = <-events
+	require.True(t, event.Available)
+
+	// only changes are reported, and the channel is closed with ctx
+	cancel()
+	for range events {
+		t.Fatal("event reported while the broker stayed available")
+	}
+}
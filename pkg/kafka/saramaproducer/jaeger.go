@@ -0,0 +1,60 @@
+package saramaproducer
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// WithJaegerPropagator makes SendMessage and SendMessages start a producer
+// span with tracer for every message, named "<topic> publish", like
+// WithOTelTracing does for OpenTelemetry. The span is a child of the span of
+// the context set with SetMessageContext or, failing that, of the span
+// already carried in the message headers in tracer's TextMap format, e.g.
+// the span of the context passed to SendMessageWithCorrelationID. It is
+// itself injected into the headers for consumers to continue the trace; a
+// Jaeger tracer writes the uber-trace-id header and a uberctx- header per
+// baggage item. The span is finished once the message is acknowledged or
+// has failed.
+func WithJaegerPropagator(tracer opentracing.Tracer) SyncProducerOption {
+	return func(sp *syncProducer) {
+		sp.jaegerTracer = tracer
+	}
+}
+
+func (sp *syncProducer) startJaegerSpan(ctx context.Context, msg *sarama.ProducerMessage) opentracing.Span {
+	carrier := producerMessageCarrier{msg: msg}
+	opts := []opentracing.StartSpanOption{ext.SpanKindProducer, opentracing.Tag{Key: string(ext.MessageBusDestination), Value: msg.Topic}}
+	var parent opentracing.SpanContext
+	if ctx != nil {
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			parent = span.Context()
+		}
+	}
+	if parent == nil {
+		if extracted, err := sp.jaegerTracer.Extract(opentracing.TextMap, carrier); err == nil {
+			parent = extracted
+		}
+	}
+	if parent != nil {
+		opts = append(opts, opentracing.ChildOf(parent))
+	}
+	span := sp.jaegerTracer.StartSpan(msg.Topic+" publish", opts...)
+	if err := sp.jaegerTracer.Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+		level.Debug(sp.logger).Log("msg", "failed to inject span into message", "topic", msg.Topic, "err", err)
+	}
+	return span
+}
+
+func endJaegerSpan(span opentracing.Span, msg *sarama.ProducerMessage, err error) {
+	if err != nil {
+		ext.LogError(span, err)
+	} else {
+		span.SetTag("kafka.partition", msg.Partition)
+		span.SetTag("kafka.offset", msg.Offset)
+	}
+	span.Finish()
+}
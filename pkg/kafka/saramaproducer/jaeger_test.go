@@ -0,0 +1,33 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithJaegerPropagator_ParentFromMessageContext(t *testing.T) {
+	tracer := mocktracer.New()
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	inner, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig(), WithJaegerPropagator(tracer))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, inner.Close()) })
+	// the context must reach the core through decorators
+	producer := newCountingSyncProducer(inner)
+
+	parent := tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	msg := newTestMessage()
+	defer SetMessageContext(ctx, msg)()
+	_, _, err = producer.SendMessageWithSchema(msg, 1, 1)
+	require.NoError(t, err)
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, testTopic+" publish", spans[0].OperationName)
+	require.Equal(t, parent.Context().(mocktracer.MockSpanContext).SpanID, spans[0].ParentID)
+}
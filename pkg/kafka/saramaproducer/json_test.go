@@ -0,0 +1,45 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSyncProducer_SendMessageJSON(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewJSONSyncProducer(inner)
+
+	_, _, err := producer.SendMessageJSON(context.Background(), testTopic, map[string]int{"id": 1}, struct {
+		Name string `json:"name"`
+	}{Name: "foo"})
+	require.NoError(t, err)
+	_, _, err = producer.SendMessageJSON(context.Background(), testTopic, nil, nil)
+	require.NoError(t, err)
+
+	sent := recorder.messages()
+	require.Len(t, sent, 2)
+	msg := consumed(t, sent[0])
+	require.JSONEq(t, `{"id": 1}`, string(msg.Key))
+	require.JSONEq(t, `{"name": "foo"}`, string(msg.Value))
+	// nil keys and values are null rather than the JSON literal
+	require.Nil(t, sent[1].Key)
+	require.Nil(t, sent[1].Value)
+}
+
+func TestJSONSyncProducer_SendMessageJSONEncodeError(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewJSONSyncProducer(inner)
+
+	for field, args := range map[string][2]interface{}{
+		"key":   {make(chan int), "foo"},
+		"value": {"foo", make(chan int)},
+	} {
+		_, _, err := producer.SendMessageJSON(context.Background(), testTopic, args[0], args[1])
+		var encodeErr JSONEncodeError
+		require.ErrorAs(t, err, &encodeErr)
+		require.Equal(t, field, encodeErr.Field)
+	}
+	require.Empty(t, recorder.messages())
+}
@@ -0,0 +1,51 @@
+package saramaproducer
+
+import "github.com/IBM/sarama"
+
+// WithKeyHasher makes the producer place every message with a key on
+// partition h(key, numPartitions) instead of using Producer.Partitioner, for
+// compatibility with producers in other languages that hash keys with, for
+// example, FNV-1a or xxHash. key is the encoded key and h must return a value
+// in [0, numPartitions); other values fail the message with
+// ErrInvalidPartition. As with the default HashPartitioner, numPartitions
+// counts all partitions of the topic, available or not, so that a key keeps
+// its partition while a leader is down, and messages without a key go to a
+// random available partition. Partitioners set with SetTopicPartitioner take
+// precedence.
+func WithKeyHasher(h func(key []byte, numPartitions int32) int32) SyncProducerOption {
+	return func(sp *syncProducer) {
+		if h == nil {
+			return
+		}
+		sp.partitioner = func(topic string) sarama.Partitioner {
+			return &keyHasherPartitioner{
+				random: sarama.NewRandomPartitioner(topic),
+				hash:   h,
+			}
+		}
+	}
+}
+
+type keyHasherPartitioner struct {
+	random sarama.Partitioner
+	hash   func(key []byte, numPartitions int32) int32
+}
+
+func (p *keyHasherPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return p.random.Partition(message, numPartitions)
+	}
+	bytes, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return p.hash(bytes, numPartitions), nil
+}
+
+func (p *keyHasherPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (p *keyHasherPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	return message.Key != nil
+}
@@ -0,0 +1,37 @@
+package saramaproducer
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithKeyHasher(t *testing.T) {
+	producer := newTestSyncProducer(t, WithKeyHasher(func(key []byte, numPartitions int32) int32 {
+		if string(key) == "invalid" {
+			return numPartitions
+		}
+		return int32(key[0]) % numPartitions
+	}))
+
+	send := func(key string) (int32, error) {
+		partition, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: testTopic, Key: sarama.StringEncoder(key), Value: sarama.StringEncoder("foo")})
+		return partition, err
+	}
+	for i := 0; i < 5; i++ {
+		partition, err := send("a")
+		require.NoError(t, err)
+		require.Equal(t, int32('a'%2), partition)
+		partition, err = send("b")
+		require.NoError(t, err)
+		require.Equal(t, int32('b'%2), partition)
+	}
+
+	_, err := send("invalid")
+	require.ErrorIs(t, err, sarama.ErrInvalidPartition)
+
+	// messages without a key go to any partition
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+}
@@ -0,0 +1,27 @@
+package saramaproducer
+
+import "github.com/IBM/sarama"
+
+// WithKeyNormalizer makes SendMessage, SendMessages and the methods built on
+// them replace the key of each message with fn(msg.Topic, key) before the
+// message is partitioned, so that keys formatted differently by different
+// producers, e.g. with and without URL encoding, land on the same partition.
+// Messages without a key are left alone. As a message resent through the
+// producer is normalized again, fn should be idempotent.
+func WithKeyNormalizer(fn func(topic string, key []byte) []byte) SyncProducerOption {
+	return func(sp *syncProducer) {
+		sp.keyNormalizer = fn
+	}
+}
+
+func (sp *syncProducer) normalizeKey(msg *sarama.ProducerMessage) error {
+	if msg.Key == nil {
+		return nil
+	}
+	key, err := msg.Key.Encode()
+	if err != nil {
+		return err
+	}
+	msg.Key = sarama.ByteEncoder(sp.keyNormalizer(msg.Topic, key))
+	return nil
+}
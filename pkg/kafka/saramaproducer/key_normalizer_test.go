@@ -0,0 +1,42 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithKeyNormalizer(t *testing.T) {
+	var (
+		lock        sync.Mutex
+		partitioned [][]byte
+	)
+	producer := newTestSyncProducer(t,
+		WithKeyNormalizer(func(_ string, key []byte) []byte { return bytes.ToLower(key) }),
+		// records the keys the partitioner sees
+		WithKeyHasher(func(key []byte, _ int32) int32 {
+			lock.Lock()
+			defer lock.Unlock()
+			partitioned = append(partitioned, key)
+			return 0
+		}),
+	)
+
+	msg := &sarama.ProducerMessage{Topic: testTopic, Key: sarama.StringEncoder("User-1"), Value: sarama.StringEncoder("foo")}
+	_, _, err := producer.SendMessage(msg)
+	require.NoError(t, err)
+	require.NoError(t, producer.SendMessages([]*sarama.ProducerMessage{
+		{Topic: testTopic, Key: sarama.StringEncoder("USER-1"), Value: sarama.StringEncoder("foo")},
+		newTestMessage(),
+	}))
+
+	key, err := msg.Key.Encode()
+	require.NoError(t, err)
+	require.Equal(t, []byte("user-1"), key)
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, [][]byte{[]byte("user-1"), []byte("user-1")}, partitioned)
+}
@@ -0,0 +1,91 @@
+package saramaproducer
+
+import (
+	"errors"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrKeyNotSerialized is returned when a TypedKey reaches a producer that was
+// not created with NewKeySerializingSyncProducer.
+var ErrKeyNotSerialized = errors.New("kafka: TypedKey must be sent through a key-serializing producer")
+
+// KeySerializer turns composite keys into bytes, e.g. as Avro, Protobuf or
+// JSON. Implementations must be safe for concurrent use.
+type KeySerializer interface {
+	SerializeKey(topic string, key interface{}) ([]byte, error)
+}
+
+// TypedKey carries an arbitrary Go value as the Key of a ProducerMessage. It
+// is replaced by its serialized form when the message is sent through a
+// SyncProducer created with NewKeySerializingSyncProducer; any other producer
+// fails to encode it with ErrKeyNotSerialized.
+type TypedKey struct {
+	Key interface{}
+}
+
+func (TypedKey) Encode() ([]byte, error) {
+	return nil, ErrKeyNotSerialized
+}
+
+func (TypedKey) Length() int {
+	return 0
+}
+
+type keySerializingSyncProducer struct {
+	decorator
+	serializer KeySerializer
+}
+
+// NewKeySerializingSyncProducer returns a SyncProducer that serializes the
+// keys of messages whose Key is a TypedKey with ks before handing them to
+// inner, e.g.
+//
+//	msg := &sarama.ProducerMessage{Topic: "orders", Key: saramaproducer.TypedKey{Key: OrderKey{Region: "eu", ID: 42}}}
+//
+// Messages with other keys are passed through unchanged.
+func NewKeySerializingSyncProducer(inner SyncProducer, ks KeySerializer) SyncProducer {
+	kp := &keySerializingSyncProducer{serializer: ks}
+	kp.decorator = newPreSendDecorator(inner, kp)
+	return kp
+}
+
+func (kp *keySerializingSyncProducer) serialize(msg *sarama.ProducerMessage) error {
+	typed, ok := msg.Key.(TypedKey)
+	if !ok {
+		return nil
+	}
+	key, err := kp.serializer.SerializeKey(msg.Topic, typed.Key)
+	if err != nil {
+		return err
+	}
+	msg.Key = sarama.ByteEncoder(key)
+	return nil
+}
+
+func (kp *keySerializingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if err := kp.serialize(msg); err != nil {
+		return -1, -1, err
+	}
+	return kp.SyncProducer.SendMessage(msg)
+}
+
+func (kp *keySerializingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var failed ProducerErrors
+	prepared := make([]*sarama.ProducerMessage, 0, len(msgs))
+	positions := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		if err := kp.serialize(msg); err != nil {
+			failed = append(failed, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+			continue
+		}
+		prepared = append(prepared, msg)
+		positions = append(positions, i)
+	}
+
+	var err error
+	if len(prepared) > 0 {
+		err = kp.SyncProducer.SendMessages(prepared)
+	}
+	return mergeProducerErrors(failed, err, positions)
+}
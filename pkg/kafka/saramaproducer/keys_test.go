@@ -0,0 +1,41 @@
+package saramaproducer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// stringKeySerializer serializes string keys and fails on any other type.
+type stringKeySerializer struct{}
+
+func (stringKeySerializer) SerializeKey(_ string, key interface{}) ([]byte, error) {
+	s, ok := key.(string)
+	if !ok {
+		return nil, errors.New("not a string")
+	}
+	return []byte(s), nil
+}
+
+func TestKeySerializingSyncProducer_SendMessagesSendsSerializedKeys(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewKeySerializingSyncProducer(inner, stringKeySerializer{})
+
+	msgs := []*sarama.ProducerMessage{newTestMessage(), newTestMessage(), newTestMessage()}
+	msgs[0].Key = TypedKey{Key: "a"}
+	msgs[1].Key = TypedKey{Key: 1}
+	msgs[2].Key = TypedKey{Key: "c"}
+	err := producer.SendMessages(msgs)
+
+	var pErrs ProducerErrors
+	require.ErrorAs(t, err, &pErrs)
+	require.Len(t, pErrs, 1)
+	require.Same(t, msgs[1], pErrs[0].Msg)
+	require.Equal(t, 1, pErrs[0].BatchIndex)
+	for _, msg := range []*sarama.ProducerMessage{msgs[0], msgs[2]} {
+		require.True(t, recorder.wasSent(msg))
+		require.IsType(t, sarama.ByteEncoder{}, msg.Key)
+	}
+}
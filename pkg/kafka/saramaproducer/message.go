@@ -0,0 +1,176 @@
+package saramaproducer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// messageOptions are per-message settings that a sarama.ProducerMessage has
+// no field for. They are attached to a message before it is sent, possibly
+// through decorators, and taken off by the core producer when the message
+// reaches it. Whoever attaches them also takes them off once the send has
+// returned, as the message may never reach a core producer: a decorator may
+// fail or drop it first, or the decorated producer may not be one of this
+// package.
+type messageOptions struct {
+	// manualPartition is set for messages whose Partition was chosen by the
+	// caller, in which case the topic's partitioner is bypassed
+	manualPartition bool
+
+	// codec replaces Producer.Compression for the message when hasCodec is
+	// set; see CodecSyncProducer
+	codec    sarama.CompressionCodec
+	hasCodec bool
+
+	// encryptHeaders returns the headers to produce in place of the
+	// message's own once global headers and the header interceptor have been
+	// applied; see NewEncryptingHeaderSyncProducer
+	encryptHeaders func([]sarama.RecordHeader) ([]sarama.RecordHeader, error)
+
+	// singleAttempt sends the message with an async producer that does not
+	// retry it, as SendMessage does when resending after a leader change
+	singleAttempt bool
+
+	// ctx holds the parent of the spans started for the message; see
+	// SetMessageContext
+	ctx context.Context
+
+	// sendCtx stops the core producer waiting for the message to be
+	// acknowledged once it is done; see sendMessageWithContext
+	sendCtx context.Context
+
+	// callback is set for messages sent with SendMessageWithCallback through
+	// decorators that do not wait for the outcome; see
+	// decorator.SendMessageWithCallback
+	callback *pendingCallback
+}
+
+// pendingCallback carries the callback of a message to the core producer,
+// which takes it over instead of returning the outcome from SendMessage.
+type pendingCallback struct {
+	onComplete func(partition int32, offset int64, err error)
+	// taken is set by the core producer once it has taken over onComplete
+	taken bool
+}
+
+// pendingOptions holds the options of messages on their way to the core
+// producer.
+var pendingOptions sync.Map // *sarama.ProducerMessage -> messageOptions
+
+func updateMessageOptions(msg *sarama.ProducerMessage, update func(*messageOptions)) {
+	var opts messageOptions
+	if v, ok := pendingOptions.Load(msg); ok {
+		opts = v.(messageOptions)
+	}
+	update(&opts)
+	pendingOptions.Store(msg, opts)
+}
+
+// setManualPartition sends msg to partition, bypassing the partitioner.
+func setManualPartition(msg *sarama.ProducerMessage, partition int32) {
+	msg.Partition = partition
+	updateMessageOptions(msg, func(opts *messageOptions) { opts.manualPartition = true })
+}
+
+// setMessageCodec compresses msg with codec instead of Producer.Compression.
+func setMessageCodec(msg *sarama.ProducerMessage, codec sarama.CompressionCodec) {
+	updateMessageOptions(msg, func(opts *messageOptions) {
+		opts.codec = codec
+		opts.hasCodec = true
+	})
+}
+
+// addHeaderEncryption has the core producer replace the headers of msg with
+// the result of encrypt once all other headers have been added. Encryptions
+// added earlier are applied first.
+func addHeaderEncryption(msg *sarama.ProducerMessage, encrypt func([]sarama.RecordHeader) ([]sarama.RecordHeader, error)) {
+	updateMessageOptions(msg, func(opts *messageOptions) {
+		prev := opts.encryptHeaders
+		if prev == nil {
+			opts.encryptHeaders = encrypt
+			return
+		}
+		opts.encryptHeaders = func(headers []sarama.RecordHeader) ([]sarama.RecordHeader, error) {
+			headers, err := prev(headers)
+			if err != nil {
+				return nil, err
+			}
+			return encrypt(headers)
+		}
+	})
+}
+
+// moveMessageOptions moves the options attached to from over to to, which is
+// sent in its place.
+func moveMessageOptions(from, to *sarama.ProducerMessage) {
+	if v, ok := pendingOptions.LoadAndDelete(from); ok {
+		pendingOptions.Store(to, v)
+	}
+}
+
+// isManualPartition reports whether msg is on its way to the core producer
+// with a partition chosen by the caller.
+func isManualPartition(msg *sarama.ProducerMessage) bool {
+	v, ok := pendingOptions.Load(msg)
+	return ok && v.(messageOptions).manualPartition
+}
+
+// takeMessageOptions removes and returns the options attached to msg.
+func takeMessageOptions(msg *sarama.ProducerMessage) messageOptions {
+	v, ok := pendingOptions.LoadAndDelete(msg)
+	if !ok {
+		return messageOptions{}
+	}
+	return v.(messageOptions)
+}
+
+// keyBytes returns the encoded key of the message, or nil if it has no key or
+// the key fails to encode.
+func keyBytes(m *sarama.ProducerMessage) []byte {
+	if m.Key == nil {
+		return nil
+	}
+	key, err := m.Key.Encode()
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// noCopyEncoder implements the Encoder interface for byte slices owned by the
+// caller. The slice is handed to the producer by reference, so it must not be
+// modified until the message has been acknowledged.
+type noCopyEncoder []byte
+
+func (b noCopyEncoder) Encode() ([]byte, error) {
+	return b, nil
+}
+
+func (b noCopyEncoder) Length() int {
+	return len(b)
+}
+
+// cloneConfig returns a copy of c that shares no slices or TLS configuration
+// with it. Interfaces and funcs such as the partitioner, token provider and
+// metric registry cannot be copied and are shared.
+func cloneConfig(c *sarama.Config) *sarama.Config {
+	clone := *c
+	if c.Net.TLS.Config != nil {
+		clone.Net.TLS.Config = c.Net.TLS.Config.Clone()
+	}
+	if c.Producer.Interceptors != nil {
+		clone.Producer.Interceptors = append([]sarama.ProducerInterceptor(nil), c.Producer.Interceptors...)
+	}
+	if c.Consumer.Interceptors != nil {
+		clone.Consumer.Interceptors = append([]sarama.ConsumerInterceptor(nil), c.Consumer.Interceptors...)
+	}
+	if c.Consumer.Group.Rebalance.GroupStrategies != nil {
+		clone.Consumer.Group.Rebalance.GroupStrategies = append([]sarama.BalanceStrategy(nil), c.Consumer.Group.Rebalance.GroupStrategies...)
+	}
+	if c.Consumer.Group.Member.UserData != nil {
+		clone.Consumer.Group.Member.UserData = append([]byte(nil), c.Consumer.Group.Member.UserData...)
+	}
+	return &clone
+}
@@ -0,0 +1,151 @@
+package saramaproducer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	// migrateBatchSize is how many messages MigrateMessages produces at once.
+	migrateBatchSize = 500
+	// migrateIdleTimeout bounds how long MigrateMessages waits for further
+	// records of a partition once it has caught up with everything but
+	// trailing transaction markers, which are never delivered to consumers.
+	migrateIdleTimeout = time.Second
+)
+
+func (sp *syncProducer) MigrateMessages(ctx context.Context, fromTopic, toTopic string, transform func(*sarama.ConsumerMessage) (*sarama.ProducerMessage, error)) (int64, error) {
+	return sp.migrateMessages(ctx, sp, fromTopic, toTopic, transform)
+}
+
+// migrateMessages implements MigrateMessages, consuming fromTopic with sp's
+// client and producing to toTopic with p.SendMessages.
+func (sp *syncProducer) migrateMessages(ctx context.Context, p SyncProducer, fromTopic, toTopic string, transform func(*sarama.ConsumerMessage) (*sarama.ProducerMessage, error)) (int64, error) {
+	if fromTopic == toTopic {
+		return 0, sarama.ConfigurationError("MigrateMessages requires different source and destination topics")
+	}
+	if transform == nil {
+		transform = copyConsumerMessage
+	}
+
+	client := sp.client
+	partitions, err := client.Partitions(fromTopic)
+	if err != nil {
+		return 0, err
+	}
+
+	conf := cloneConfig(client.Config())
+	conf.Consumer.Return.Errors = true
+	consumer, err := sarama.NewConsumerFromClient(&configOverrideClient{Client: client, conf: conf})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = consumer.Close() }()
+
+	var migrated int64
+	for _, partition := range partitions {
+		n, err := sp.migratePartition(ctx, p, consumer, fromTopic, partition, toTopic, transform)
+		migrated += n
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// migratePartition migrates the records of fromTopic/partition up to its
+// current high watermark through p and returns how many messages it
+// produced.
+func (sp *syncProducer) migratePartition(ctx context.Context, p SyncProducer, consumer sarama.Consumer, fromTopic string, partition int32, toTopic string, transform func(*sarama.ConsumerMessage) (*sarama.ProducerMessage, error)) (int64, error) {
+	client := sp.client
+	oldest, err := client.GetOffset(fromTopic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	newest, err := client.GetOffset(fromTopic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+	if newest <= oldest {
+		return 0, nil
+	}
+
+	pc, err := consumer.ConsumePartition(fromTopic, partition, oldest)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = pc.Close() }()
+
+	var migrated int64
+	batch := make([]*sarama.ProducerMessage, 0, migrateBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := p.SendMessages(batch)
+		migrated += int64(len(batch))
+		var pErrs ProducerErrors
+		if errors.As(err, &pErrs) {
+			migrated -= int64(len(pErrs))
+		} else if err != nil {
+			migrated -= int64(len(batch))
+		}
+		batch = batch[:0]
+		return err
+	}
+
+	idle := time.NewTimer(migrateIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case consumed := <-pc.Messages():
+			msg, err := transform(consumed)
+			if err != nil {
+				return migrated, errors.Join(err, flush())
+			}
+			if msg != nil {
+				msg.Topic = toTopic
+				batch = append(batch, msg)
+			}
+			if consumed.Offset+1 >= newest {
+				return migrated, flush()
+			}
+			if len(batch) == migrateBatchSize {
+				if err := flush(); err != nil {
+					return migrated, err
+				}
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(migrateIdleTimeout)
+		case consumeErr := <-pc.Errors():
+			return migrated, errors.Join(consumeErr, flush())
+		case <-idle.C:
+			return migrated, flush()
+		case <-ctx.Done():
+			return migrated, errors.Join(ctx.Err(), flush())
+		}
+	}
+}
+
+// copyConsumerMessage is the transform used by MigrateMessages if none is
+// given.
+func copyConsumerMessage(consumed *sarama.ConsumerMessage) (*sarama.ProducerMessage, error) {
+	msg := &sarama.ProducerMessage{Timestamp: consumed.Timestamp}
+	if consumed.Key != nil {
+		msg.Key = sarama.ByteEncoder(consumed.Key)
+	}
+	if consumed.Value != nil {
+		msg.Value = sarama.ByteEncoder(consumed.Value)
+	}
+	for _, h := range consumed.Headers {
+		if h != nil {
+			msg.Headers = append(msg.Headers, *h)
+		}
+	}
+	return msg, nil
+}
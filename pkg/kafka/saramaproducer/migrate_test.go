@@ -0,0 +1,57 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncProducer_MigrateMessages(t *testing.T) {
+	// otherTestTopic holds three records, migrated to testTopic
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(otherTestTopic, 0, broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(otherTestTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(otherTestTopic, 0, sarama.OffsetNewest, 3),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessageWithKey(otherTestTopic, 0, 0, sarama.StringEncoder("a"), sarama.StringEncoder("foo")).
+			SetMessageWithKey(otherTestTopic, 0, 1, sarama.StringEncoder("b"), sarama.StringEncoder("skip")).
+			SetMessageWithKey(otherTestTopic, 0, 2, sarama.StringEncoder("c"), sarama.StringEncoder("bar")).
+			SetHighWaterMark(otherTestTopic, 0, 3),
+	})
+	recorder := &sendRecorder{}
+	config := newTestConfig()
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	migrated, err := producer.MigrateMessages(context.Background(), otherTestTopic, testTopic, func(msg *sarama.ConsumerMessage) (*sarama.ProducerMessage, error) {
+		if string(msg.Value) == "skip" {
+			return nil, nil
+		}
+		return &sarama.ProducerMessage{Key: sarama.ByteEncoder(msg.Key), Value: sarama.ByteEncoder(bytes.ToUpper(msg.Value))}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), migrated)
+
+	var got []string
+	for _, msg := range recorder.messages() {
+		require.Equal(t, testTopic, msg.Topic)
+		cm := consumed(t, msg)
+		got = append(got, string(cm.Key)+"="+string(cm.Value))
+	}
+	require.Equal(t, []string{"a=FOO", "c=BAR"}, got)
+
+	_, err = producer.MigrateMessages(context.Background(), testTopic, testTopic, nil)
+	require.ErrorAs(t, err, new(sarama.ConfigurationError))
+}
@@ -0,0 +1,40 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// valueRecorder is a ProducerInterceptor that records the last value sent.
+type valueRecorder struct {
+	value []byte
+}
+
+func (vr *valueRecorder) OnSend(msg *sarama.ProducerMessage) {
+	vr.value, _ = msg.Value.Encode()
+}
+
+func TestMsgpackSyncProducer_SendMessageMsgpack(t *testing.T) {
+	recorder := &valueRecorder{}
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	inner, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, inner.Close()) })
+
+	type event struct {
+		Name  string `msgpack:"name"`
+		Count int    `msgpack:"count"`
+	}
+	_, _, err = NewMsgpackSyncProducer(inner).SendMessageMsgpack(context.Background(), testTopic, "key", event{Name: "foo", Count: 2})
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, msgpack.Unmarshal(recorder.value, &decoded))
+	require.Equal(t, map[string]interface{}{"name": "foo", "count": int8(2)}, decoded)
+}
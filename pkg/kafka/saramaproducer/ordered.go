@@ -0,0 +1,53 @@
+package saramaproducer
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+type orderedSyncProducer struct {
+	decorator
+	lock sync.Mutex
+}
+
+// NewOrderedSyncProducer returns a SyncProducer that sends every message
+// through inner one at a time, waiting for each acknowledgement before the
+// next message is handed over, regardless of topic or partition.
+//
+// With Net.MaxOpenRequests > 1 and a non-idempotent producer, a broker failure
+// can cause batches to be retried out of order. Serialising sends rules this
+// out, and additionally gives a single global order across all partitions.
+// The price is throughput: only one message is ever in flight, so the
+// producer is limited to roughly one message per broker round-trip and no
+// batching takes place. SendMessages sends its messages sequentially in slice
+// order. Only use this wrapper where strict ordering matters more than
+// throughput; for per-partition ordering, Producer.Idempotent is far cheaper.
+func NewOrderedSyncProducer(inner SyncProducer) SyncProducer {
+	op := &orderedSyncProducer{}
+	op.decorator = newDecorator(inner, op)
+	return op
+}
+
+func (op *orderedSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	op.lock.Lock()
+	defer op.lock.Unlock()
+	return op.SyncProducer.SendMessage(msg)
+}
+
+func (op *orderedSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	op.lock.Lock()
+	defer op.lock.Unlock()
+
+	var errors ProducerErrors
+	for i, msg := range msgs {
+		if _, _, err := op.SyncProducer.SendMessage(msg); err != nil {
+			errors = append(errors, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+		}
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
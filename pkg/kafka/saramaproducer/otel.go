@@ -0,0 +1,57 @@
+package saramaproducer
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithOTelTracing makes SendMessage and SendMessages start a producer span
+// with tracer for every message, named by spanNameFn, or "<topic> publish"
+// if it is nil. The span is a child of the span of the context set with
+// SetMessageContext or, failing that, of the trace context already carried
+// in the message's W3C trace context headers, e.g. by
+// SendMessageWithCorrelationID. It is itself injected into those headers
+// for consumers to continue the trace. It ends once the message is
+// acknowledged or has failed, with the kafka.topic attribute and, on
+// success, kafka.partition and kafka.offset.
+func WithOTelTracing(tracer trace.Tracer, spanNameFn func(*sarama.ProducerMessage) string) SyncProducerOption {
+	return func(sp *syncProducer) {
+		if spanNameFn == nil {
+			spanNameFn = func(msg *sarama.ProducerMessage) string {
+				return msg.Topic + " publish"
+			}
+		}
+		sp.tracer = tracer
+		sp.spanName = spanNameFn
+	}
+}
+
+func (sp *syncProducer) startSpan(ctx context.Context, msg *sarama.ProducerMessage) trace.Span {
+	carrier := producerMessageCarrier{msg: msg}
+	parent := ctx
+	if parent == nil || !trace.SpanContextFromContext(parent).IsValid() {
+		parent = propagation.TraceContext{}.Extract(context.Background(), carrier)
+	}
+	ctx, span := sp.tracer.Start(parent, sp.spanName(msg),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("kafka.topic", msg.Topic)))
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return span
+}
+
+func endSpan(span trace.Span, msg *sarama.ProducerMessage, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(
+			attribute.Int64("kafka.partition", int64(msg.Partition)),
+			attribute.Int64("kafka.offset", msg.Offset))
+	}
+	span.End()
+}
@@ -0,0 +1,127 @@
+package saramaproducer
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+)
+
+// recordingTracer starts recordingSpans, numbering their span IDs from 1.
+type recordingTracer struct {
+	embedded.Tracer
+
+	lock  sync.Mutex
+	spans []*recordingSpan
+}
+
+func (rt *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	config := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+	traceID := parent.TraceID()
+	if !parent.IsValid() {
+		traceID = trace.TraceID{0xff}
+	}
+	span := &recordingSpan{
+		Span:   trace.SpanFromContext(context.Background()),
+		name:   name,
+		kind:   config.SpanKind(),
+		parent: parent,
+		attrs:  config.Attributes(),
+		spanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{byte(len(rt.spans) + 1)},
+			TraceFlags: trace.FlagsSampled,
+		}),
+	}
+	rt.spans = append(rt.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (rt *recordingTracer) started() []*recordingSpan {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]*recordingSpan(nil), rt.spans...)
+}
+
+// recordingSpan records what is set on it, doing nothing else.
+type recordingSpan struct {
+	trace.Span
+	name        string
+	kind        trace.SpanKind
+	parent      trace.SpanContext
+	spanContext trace.SpanContext
+	attrs       []attribute.KeyValue
+	status      codes.Code
+	ended       bool
+}
+
+func (s *recordingSpan) SpanContext() trace.SpanContext         { return s.spanContext }
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue) { s.attrs = append(s.attrs, kv...) }
+func (s *recordingSpan) SetStatus(code codes.Code, _ string)    { s.status = code }
+func (s *recordingSpan) End(...trace.SpanEndOption)             { s.ended = true }
+
+func TestWithOTelTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	producer := newTestSyncProducer(t, WithOTelTracing(tracer, nil))
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}, TraceFlags: trace.FlagsSampled})
+	msg := newTestMessage()
+	defer SetMessageContext(trace.ContextWithSpanContext(context.Background(), parent), msg)()
+	partition, offset, err := producer.SendMessage(msg)
+	require.NoError(t, err)
+
+	spans := tracer.started()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	require.Equal(t, testTopic+" publish", span.name)
+	require.Equal(t, trace.SpanKindProducer, span.kind)
+	require.Equal(t, parent, span.parent)
+	require.True(t, span.ended)
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("kafka.topic", testTopic),
+		attribute.Int64("kafka.partition", int64(partition)),
+		attribute.Int64("kafka.offset", offset),
+	}, span.attrs)
+
+	// the span is injected for consumers to continue the trace
+	injected := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), producerMessageCarrier{msg: msg}))
+	require.Equal(t, span.spanContext.TraceID(), injected.TraceID())
+	require.Equal(t, span.spanContext.SpanID(), injected.SpanID())
+}
+
+func TestWithOTelTracing_ParentFromHeadersAndFailure(t *testing.T) {
+	tracer := &recordingTracer{}
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t).
+		SetError(testTopic, 0, sarama.ErrMessageSizeTooLarge).
+		SetError(testTopic, 1, sarama.ErrMessageSizeTooLarge))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig(), WithOTelTracing(tracer, func(msg *sarama.ProducerMessage) string {
+		return "send " + msg.Topic
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{2}, SpanID: trace.SpanID{2}, TraceFlags: trace.FlagsSampled})
+	msg := newTestMessage()
+	propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(context.Background(), parent), producerMessageCarrier{msg: msg})
+	require.Error(t, producer.SendMessages([]*sarama.ProducerMessage{msg}))
+
+	spans := tracer.started()
+	require.Len(t, spans, 1)
+	require.Equal(t, "send "+testTopic, spans[0].name)
+	require.Equal(t, parent.TraceID(), spans[0].parent.TraceID())
+	require.Equal(t, parent.SpanID(), spans[0].parent.SpanID())
+	require.Equal(t, codes.Error, spans[0].status)
+	require.True(t, spans[0].ended)
+	require.Equal(t, []attribute.KeyValue{attribute.String("kafka.topic", testTopic)}, spans[0].attrs)
+}
@@ -0,0 +1,240 @@
+package saramaproducer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// dispatchPartitioner is the Producer.Partitioner of every async producer of
+// the pool. It keeps manually partitioned messages on their partition and
+// hands all other messages to the partitioner currently set for the topic,
+// which is shared by the async producers.
+type dispatchPartitioner struct {
+	sp    *syncProducer
+	topic string
+}
+
+func (sp *syncProducer) newDispatchPartitioner(topic string) sarama.Partitioner {
+	return &dispatchPartitioner{sp: sp, topic: topic}
+}
+
+func (p *dispatchPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if p.sp.isManual(message) {
+		// consistency is required, so the async producer indexes all
+		// partitions by id
+		if message.Partition >= numPartitions {
+			return -1, sarama.ErrInvalidPartition
+		}
+		return message.Partition, nil
+	}
+	return p.sp.topicPartitioner(p.topic).Partition(message, numPartitions)
+}
+
+func (p *dispatchPartitioner) RequiresConsistency() bool {
+	return p.sp.topicPartitioner(p.topic).RequiresConsistency()
+}
+
+func (p *dispatchPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	if p.sp.isManual(message) {
+		return true
+	}
+	return p.sp.topicPartitioner(p.topic).MessageRequiresConsistency(message)
+}
+
+// lockedPartitioner serializes the calls to a partitioner shared by the
+// async producers of the pool.
+type lockedPartitioner struct {
+	lock        sync.Mutex
+	partitioner sarama.Partitioner
+}
+
+func (p *lockedPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.partitioner.Partition(message, numPartitions)
+}
+
+func (p *lockedPartitioner) RequiresConsistency() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.partitioner.RequiresConsistency()
+}
+
+func (p *lockedPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if dp, ok := p.partitioner.(sarama.DynamicConsistencyPartitioner); ok {
+		return dp.MessageRequiresConsistency(message)
+	}
+	return p.partitioner.RequiresConsistency()
+}
+
+// defaultPartitioner returns a new instance of the partitioner used for
+// topic unless overridden with SetTopicPartitioner.
+func (sp *syncProducer) defaultPartitioner(topic string) sarama.Partitioner {
+	if sp.partitioner != nil {
+		return sp.partitioner(topic)
+	}
+	return sp.conf.Producer.Partitioner(topic)
+}
+
+// topicPartitioner returns the partitioner of topic, creating the default one
+// on first use.
+func (sp *syncProducer) topicPartitioner(topic string) *lockedPartitioner {
+	sp.topicsConfig.Lock()
+	defer sp.topicsConfig.Unlock()
+
+	if p, ok := sp.topicPartitioners[topic]; ok {
+		return p
+	}
+	p := &lockedPartitioner{partitioner: sp.preferLocalRack(topic, sp.defaultPartitioner(topic))}
+	sp.topicPartitioners[topic] = p
+	return p
+}
+
+// preferLocalRack wraps the partitioner of topic with a localRackPartitioner
+// if WithPreferLocalRack is set.
+func (sp *syncProducer) preferLocalRack(topic string, partitioner sarama.Partitioner) sarama.Partitioner {
+	if sp.localRack == "" {
+		return partitioner
+	}
+	return &localRackPartitioner{sp: sp, topic: topic, fallback: partitioner}
+}
+
+// setTopicPartitioner replaces the partitioner used for new messages to topic.
+// A nil constructor restores the default partitioner.
+func (sp *syncProducer) setTopicPartitioner(topic string, constructor sarama.PartitionerConstructor) {
+	var partitioner sarama.Partitioner
+	if constructor != nil {
+		partitioner = constructor(topic)
+	} else {
+		partitioner = sp.defaultPartitioner(topic)
+	}
+
+	sp.topicsConfig.Lock()
+	defer sp.topicsConfig.Unlock()
+	sp.topicPartitioners[topic] = &lockedPartitioner{partitioner: sp.preferLocalRack(topic, partitioner)}
+}
+
+// WithPreferLocalRack makes the producer spread messages that the
+// partitioner does not need to place consistently, such as messages without
+// a key under the default HashPartitioner, round-robin over the partitions
+// whose leader is in rack, which avoids cross-zone traffic. Messages are left
+// to the partitioner if no partition has a local leader. rack is usually the
+// availability zone of the producer, similar to the `client.rack` setting of
+// the JVM client. Broker racks are only known with Version >= V0_10_0_0.
+// Partitioners set with SetTopicPartitioner are wrapped in the same way.
+func WithPreferLocalRack(rack string) SyncProducerOption {
+	return func(sp *syncProducer) {
+		sp.localRack = rack
+	}
+}
+
+// metadataClient is the client of a producer with WithPreferLocalRack. It
+// counts the metadata refreshes made through it, including those of the
+// async producers after a leader change, so that localRackPartitioners know
+// when to look up the leaders again.
+type metadataClient struct {
+	sarama.Client
+	refreshes atomic.Uint64
+}
+
+func (c *metadataClient) RefreshMetadata(topics ...string) error {
+	defer c.refreshes.Add(1)
+	return c.Client.RefreshMetadata(topics...)
+}
+
+// metadataGeneration returns the number of metadata refreshes made through
+// the producer's client, which only counts them with WithPreferLocalRack.
+func (sp *syncProducer) metadataGeneration() uint64 {
+	if c, ok := sp.client.(*metadataClient); ok {
+		return c.refreshes.Load()
+	}
+	return 0
+}
+
+// localRackPartitioner sends messages that do not require consistency to the
+// partitions led by brokers in the producer's rack, in turn, and all other
+// messages to fallback. The producer installs it when WithPreferLocalRack is
+// set. The rack-local partitions are looked up again after the metadata has
+// been refreshed through the producer, or once Metadata.RefreshFrequency
+// has passed since the last lookup, covering the client's own refreshes.
+type localRackPartitioner struct {
+	sp       *syncProducer
+	topic    string
+	fallback sarama.Partitioner
+	next     int
+
+	// local holds the indexes of the rack-local partitions among the
+	// numPartitions writable partitions, looked up at checkedAt in metadata
+	// generation generation
+	local         []int32
+	numPartitions int32
+	generation    uint64
+	checkedAt     time.Time
+}
+
+func (p *localRackPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if p.MessageRequiresConsistency(message) {
+		return p.fallback.Partition(message, numPartitions)
+	}
+
+	if p.stale(numPartitions) {
+		p.lookup(numPartitions)
+	}
+	if len(p.local) == 0 {
+		return p.fallback.Partition(message, numPartitions)
+	}
+
+	if p.next >= len(p.local) {
+		p.next = 0
+	}
+	choice := p.local[p.next]
+	p.next++
+	return choice, nil
+}
+
+func (p *localRackPartitioner) stale(numPartitions int32) bool {
+	if p.checkedAt.IsZero() || numPartitions != p.numPartitions || p.sp.metadataGeneration() != p.generation {
+		return true
+	}
+	maxAge := p.sp.conf.Metadata.RefreshFrequency
+	return maxAge > 0 && time.Since(p.checkedAt) > maxAge
+}
+
+// lookup finds the rack-local partitions among the numPartitions writable
+// partitions the producer indexes.
+func (p *localRackPartitioner) lookup(numPartitions int32) {
+	// a refresh during the lookup makes the result stale right away
+	p.generation = p.sp.metadataGeneration()
+	p.numPartitions = numPartitions
+	p.checkedAt = time.Now()
+	p.local = p.local[:0]
+
+	// if the writable partitions changed since the producer indexed them,
+	// leave the messages to fallback
+	partitions, err := p.sp.client.WritablePartitions(p.topic)
+	if err != nil || int32(len(partitions)) != numPartitions {
+		return
+	}
+	for i, partition := range partitions {
+		leader, err := p.sp.client.Leader(p.topic, partition)
+		if err == nil && leader.Rack() == p.sp.localRack {
+			p.local = append(p.local, int32(i))
+		}
+	}
+}
+
+func (p *localRackPartitioner) RequiresConsistency() bool {
+	return p.fallback.RequiresConsistency()
+}
+
+func (p *localRackPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	if dp, ok := p.fallback.(sarama.DynamicConsistencyPartitioner); ok {
+		return dp.MessageRequiresConsistency(message)
+	}
+	return p.fallback.RequiresConsistency()
+}
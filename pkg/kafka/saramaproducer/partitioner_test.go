@@ -0,0 +1,68 @@
+package saramaproducer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPreferLocalRack_WrapsTopicPartitioner(t *testing.T) {
+	producer := newTestSyncProducer(t, WithPreferLocalRack("zone-a"))
+	require.NoError(t, producer.SetTopicPartitioner(testTopic, sarama.NewRandomPartitioner))
+
+	sp := producer.(*syncProducer)
+	p, ok := sp.topicPartitioner(testTopic).partitioner.(*localRackPartitioner)
+	require.True(t, ok)
+	require.IsType(t, sarama.NewRandomPartitioner(testTopic), p.fallback)
+
+	require.NoError(t, producer.SetTopicPartitioner(testTopic, nil))
+	require.IsType(t, &localRackPartitioner{}, sp.topicPartitioner(testTopic).partitioner)
+}
+
+// rackClient serves two writable partitions led by a broker with no rack,
+// counting the lookups.
+type rackClient struct {
+	sarama.Client
+	lookups int
+}
+
+func (c *rackClient) WritablePartitions(string) ([]int32, error) {
+	c.lookups++
+	return []int32{0, 1}, nil
+}
+
+func (c *rackClient) Leader(string, int32) (*sarama.Broker, error) {
+	return sarama.NewBroker("localhost:9092"), nil
+}
+
+func (c *rackClient) RefreshMetadata(...string) error {
+	return nil
+}
+
+func TestLocalRackPartitioner_CachesLocalPartitions(t *testing.T) {
+	client := &rackClient{}
+	config := sarama.NewConfig()
+	config.Metadata.RefreshFrequency = time.Hour
+	sp := &syncProducer{client: &metadataClient{Client: client}, conf: config}
+	p := &localRackPartitioner{sp: sp, topic: testTopic, fallback: sarama.NewHashPartitioner(testTopic)}
+
+	var partitions []int32
+	for i := 0; i < 3; i++ {
+		partition, err := p.Partition(newTestMessage(), 2)
+		require.NoError(t, err)
+		partitions = append(partitions, partition)
+	}
+	require.Equal(t, []int32{0, 1, 0}, partitions)
+	require.Equal(t, 1, client.lookups)
+
+	require.NoError(t, sp.client.RefreshMetadata(testTopic))
+	_, err := p.Partition(newTestMessage(), 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, client.lookups)
+
+	_, err = p.Partition(newTestMessage(), 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, client.lookups)
+}
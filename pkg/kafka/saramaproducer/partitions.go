@@ -0,0 +1,94 @@
+package saramaproducer
+
+import (
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+const defaultPartitionChangeInterval = 30 * time.Second
+
+// PartitionChangeHandler is notified when a SyncProducer created with
+// WithPartitionChangeHandler observes a change in the partition count of a
+// topic, typically because partitions were added.
+type PartitionChangeHandler interface {
+	OnPartitionCountChanged(topic string, oldCount, newCount int32)
+}
+
+// PartitionChangeHandlerFunc adapts an ordinary function to a
+// PartitionChangeHandler.
+type PartitionChangeHandlerFunc func(topic string, oldCount, newCount int32)
+
+func (f PartitionChangeHandlerFunc) OnPartitionCountChanged(topic string, oldCount, newCount int32) {
+	f(topic, oldCount, newCount)
+}
+
+// WithPartitionChangeHandler makes the SyncProducer refresh the metadata of the
+// topics known to its client every interval (30s if interval <= 0) and call
+// handler whenever the number of partitions of one of them has changed since
+// the previous poll. Topics are not reported on the poll that first sees them.
+// handler is called from a single background goroutine, which stops when the
+// producer is closed.
+func WithPartitionChangeHandler(interval time.Duration, handler PartitionChangeHandler) SyncProducerOption {
+	return func(sp *syncProducer) {
+		if interval <= 0 {
+			interval = defaultPartitionChangeInterval
+		}
+		sp.partitionChangeInterval = interval
+		sp.partitionChangeHandler = handler
+	}
+}
+
+func (sp *syncProducer) watchPartitionCounts() {
+	defer sp.wg.Done()
+
+	ticker := time.NewTicker(sp.partitionChangeInterval)
+	defer ticker.Stop()
+
+	counts := make(map[string]int32)
+	sp.pollPartitionCounts(counts)
+	for {
+		select {
+		case <-ticker.C:
+			sp.pollPartitionCounts(counts)
+		case <-sp.closing:
+			return
+		}
+	}
+}
+
+func (sp *syncProducer) pollPartitionCounts(counts map[string]int32) {
+	client := sp.client
+	if err := client.RefreshMetadata(); err != nil {
+		level.Warn(sp.logger).Log("msg", "failed to refresh metadata", "err", err)
+		return
+	}
+	topics, err := client.Topics()
+	if err != nil {
+		level.Warn(sp.logger).Log("msg", "failed to list topics", "err", err)
+		return
+	}
+
+	seen := make(map[string]struct{}, len(topics))
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			continue
+		}
+		seen[topic] = struct{}{}
+
+		newCount := int32(len(partitions))
+		oldCount, known := counts[topic]
+		counts[topic] = newCount
+		if known && oldCount != newCount {
+			level.Info(sp.logger).Log("msg", "partition count changed", "topic", topic, "old", oldCount, "new", newCount)
+			sp.partitionChangeHandler.OnPartitionCountChanged(topic, oldCount, newCount)
+		}
+	}
+
+	for topic := range counts {
+		if _, ok := seen[topic]; !ok {
+			delete(counts, topic)
+		}
+	}
+}
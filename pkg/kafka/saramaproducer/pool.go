@@ -0,0 +1,281 @@
+package saramaproducer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// producerKey identifies an async producer of the pool by the settings that
+// are fixed for an async producer's lifetime but can vary between messages.
+type producerKey struct {
+	requiredAcks sarama.RequiredAcks
+	codec        sarama.CompressionCodec
+	// singleAttempt disables the async producer's retries
+	singleAttempt bool
+}
+
+// pooledProducer is an async producer of the pool together with the
+// goroutines reading its successes and errors.
+type pooledProducer struct {
+	sarama.AsyncProducer
+	// handlers is done once both result channels have been drained
+	handlers sync.WaitGroup
+}
+
+type flushSettings struct {
+	bytes     int
+	messages  int
+	frequency time.Duration
+}
+
+// TopicProducerConfig holds producer settings that can be overridden for
+// individual topics with SyncProducer.SetTopicConfig.
+type TopicProducerConfig struct {
+	// The level of acknowledgement reliability needed from the broker for
+	// messages of this topic. See Producer.RequiredAcks.
+	RequiredAcks sarama.RequiredAcks
+}
+
+func (sp *syncProducer) defaultKey() producerKey {
+	return producerKey{requiredAcks: sp.conf.Producer.RequiredAcks, codec: sp.conf.Producer.Compression}
+}
+
+// keyFor returns the key of the async producer msg is sent with.
+func (sp *syncProducer) keyFor(msg *sarama.ProducerMessage, opts messageOptions) producerKey {
+	key := sp.defaultKey()
+	if opts.hasCodec {
+		key.codec = opts.codec
+	}
+	key.singleAttempt = opts.singleAttempt
+
+	sp.topicsConfig.RLock()
+	defer sp.topicsConfig.RUnlock()
+	if overrides, ok := sp.topicConfigs[msg.Topic]; ok {
+		key.requiredAcks = overrides.RequiredAcks
+	}
+	return key
+}
+
+// addProducer creates the async producer for key unless it already exists.
+func (sp *syncProducer) addProducer(key producerKey) error {
+	sp.producersLock.Lock()
+	defer sp.producersLock.Unlock()
+
+	if sp.closed {
+		return sarama.ErrShuttingDown
+	}
+	if _, ok := sp.producers[key]; ok {
+		return nil
+	}
+	if key != sp.defaultKey() && sp.conf.Producer.Transaction.ID != "" {
+		// a transaction is bound to a single producer id
+		return sarama.ConfigurationError("a transactional producer cannot use a different codec or RequiredAcks per message")
+	}
+	p, err := sp.newPooledProducer(key, sp.flush)
+	if err != nil {
+		return err
+	}
+	sp.producers[key] = p
+	return nil
+}
+
+// newPooledProducer creates an async producer sharing the client, with the
+// settings of key and flush.
+func (sp *syncProducer) newPooledProducer(key producerKey, flush flushSettings) (*pooledProducer, error) {
+	conf := cloneConfig(sp.conf)
+	conf.Producer.RequiredAcks = key.requiredAcks
+	if key.codec != conf.Producer.Compression {
+		conf.Producer.Compression = key.codec
+		conf.Producer.CompressionLevel = sarama.CompressionLevelDefault
+	}
+	if key.singleAttempt {
+		conf.Producer.Retry.Max = 0
+		if conf.Producer.Idempotent {
+			// the lowest an idempotent producer accepts
+			conf.Producer.Retry.Max = 1
+		}
+	}
+	conf.Producer.Flush.Bytes = flush.bytes
+	conf.Producer.Flush.Messages = flush.messages
+	conf.Producer.Flush.Frequency = flush.frequency
+	conf.Producer.Partitioner = sp.newDispatchPartitioner
+
+	ap, err := sarama.NewAsyncProducerFromClient(&configOverrideClient{Client: sp.client, conf: conf})
+	if err != nil {
+		return nil, err
+	}
+	p := &pooledProducer{AsyncProducer: ap}
+	p.handlers.Add(2)
+	sp.wg.Add(2)
+	go sp.handleSuccesses(p)
+	go sp.handleErrors(p)
+	return p, nil
+}
+
+// defaultProducer returns the async producer that transactions run on.
+func (sp *syncProducer) defaultProducer() sarama.AsyncProducer {
+	sp.producersLock.RLock()
+	defer sp.producersLock.RUnlock()
+	return sp.producers[sp.defaultKey()]
+}
+
+// handOver writes msg to the Input of the async producer for key, creating
+// the producer if needed.
+func (sp *syncProducer) handOver(msg *sarama.ProducerMessage, key producerKey) error {
+	for {
+		sp.producersLock.RLock()
+		if sp.closed {
+			sp.producersLock.RUnlock()
+			return sarama.ErrShuttingDown
+		}
+		if p, ok := sp.producers[key]; ok {
+			p.Input() <- msg
+			sp.producersLock.RUnlock()
+			return nil
+		}
+		sp.producersLock.RUnlock()
+
+		if err := sp.addProducer(key); err != nil {
+			return err
+		}
+	}
+}
+
+func (sp *syncProducer) addFlight(msg *sarama.ProducerMessage, f *flight) {
+	sp.flightsLock.Lock()
+	defer sp.flightsLock.Unlock()
+	sp.flights[msg] = f
+}
+
+func (sp *syncProducer) takeFlight(msg *sarama.ProducerMessage) *flight {
+	sp.flightsLock.Lock()
+	defer sp.flightsLock.Unlock()
+	f := sp.flights[msg]
+	delete(sp.flights, msg)
+	return f
+}
+
+// isManual reports whether msg is in flight with a partition chosen by the
+// caller.
+func (sp *syncProducer) isManual(msg *sarama.ProducerMessage) bool {
+	sp.flightsLock.Lock()
+	defer sp.flightsLock.Unlock()
+	f, ok := sp.flights[msg]
+	return ok && f.opts.manualPartition
+}
+
+func (sp *syncProducer) handleSuccesses(p *pooledProducer) {
+	defer sp.wg.Done()
+	defer p.handlers.Done()
+	for msg := range p.Successes() {
+		f := sp.takeFlight(msg)
+		sp.markActive(msg)
+		sp.resolved(msg)
+		if f.callback != nil {
+			f.callback(msg.Partition, msg.Offset, nil)
+			continue
+		}
+		f.expectation <- nil
+	}
+}
+
+func (sp *syncProducer) handleErrors(p *pooledProducer) {
+	defer sp.wg.Done()
+	defer p.handlers.Done()
+	for err := range p.Errors() {
+		f := sp.takeFlight(err.Msg)
+		sp.resolved(err.Msg)
+		sp.recordErrors.Mark(1)
+		pErr := &ProducerError{Msg: err.Msg, Err: err.Err}
+		select {
+		case <-sp.closing:
+			sp.shutdownErrorsLock.Lock()
+			sp.shutdownErrors = append(sp.shutdownErrors, pErr)
+			sp.shutdownErrorsLock.Unlock()
+		default:
+		}
+		if f.callback != nil {
+			f.callback(-1, -1, err.Err)
+			continue
+		}
+		f.expectation <- pErr
+	}
+}
+
+// closeProducers shuts down every async producer of the pool and waits for
+// their in-flight messages to be resolved. Messages sent afterwards fail
+// with sarama.ErrShuttingDown.
+func (sp *syncProducer) closeProducers() {
+	sp.producersLock.Lock()
+	defer sp.producersLock.Unlock()
+
+	sp.closed = true
+	for _, p := range sp.producers {
+		p.AsyncClose()
+	}
+	for _, p := range sp.producers {
+		p.handlers.Wait()
+	}
+}
+
+// setFlushSettings replaces every async producer of the pool by one with the
+// given flush settings. Sends block until the messages in flight on the old
+// producers are resolved, so that no message overtakes an earlier one.
+func (sp *syncProducer) setFlushSettings(flush flushSettings) error {
+	sp.producersLock.Lock()
+	defer sp.producersLock.Unlock()
+
+	if sp.closed {
+		return sarama.ErrShuttingDown
+	}
+	for _, p := range sp.producers {
+		p.AsyncClose()
+	}
+	for _, p := range sp.producers {
+		p.handlers.Wait()
+	}
+
+	// the other producers are recreated when next needed
+	sp.producers = make(map[producerKey]*pooledProducer, len(sp.producers))
+	sp.flush = flush
+	p, err := sp.newPooledProducer(sp.defaultKey(), flush)
+	if err != nil {
+		return err
+	}
+	sp.producers[sp.defaultKey()] = p
+	return nil
+}
+
+func (sp *syncProducer) flushSettings() flushSettings {
+	sp.producersLock.RLock()
+	defer sp.producersLock.RUnlock()
+	return sp.flush
+}
+
+func (sp *syncProducer) setTopicConfig(topic string, overrides TopicProducerConfig) error {
+	if overrides.RequiredAcks < -1 {
+		return sarama.ConfigurationError("RequiredAcks must be >= -1")
+	}
+	if sp.conf.Producer.Idempotent && overrides.RequiredAcks != sarama.WaitForAll {
+		return sarama.ConfigurationError("Idempotent producer requires RequiredAcks to be WaitForAll")
+	}
+
+	sp.topicsConfig.Lock()
+	defer sp.topicsConfig.Unlock()
+	sp.topicConfigs[topic] = overrides
+	return nil
+}
+
+// configOverrideClient is a Client reporting conf instead of the underlying
+// client's configuration, so that producers with different settings can share
+// its connections and metadata.
+type configOverrideClient struct {
+	sarama.Client
+	conf *sarama.Config
+}
+
+func (c *configOverrideClient) Config() *sarama.Config {
+	return c.conf
+}
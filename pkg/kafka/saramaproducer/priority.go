@@ -0,0 +1,207 @@
+package saramaproducer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const defaultPriorityMaxWait = time.Second
+
+// PriorityOption lets you modify default values of a SyncProducer created
+// with NewPrioritySyncProducer.
+type PriorityOption func(*prioritySyncProducer)
+
+// WithPriorityMaxWait bounds how long a queued message may be overtaken by
+// higher-priority messages (default 1s). Once the oldest message of a level
+// has waited this long it is sent next regardless of its priority.
+func WithPriorityMaxWait(d time.Duration) PriorityOption {
+	return func(pp *prioritySyncProducer) {
+		pp.maxWait = d
+	}
+}
+
+// WithPriorityConcurrency sets how many messages are handed to the inner
+// producer at the same time (default 1). Higher values improve throughput at
+// the cost of weaker prioritisation, as more messages are in flight at once.
+func WithPriorityConcurrency(n int) PriorityOption {
+	return func(pp *prioritySyncProducer) {
+		pp.concurrency = n
+	}
+}
+
+type prioritySyncProducer struct {
+	decorator
+
+	priorityFn  func(*sarama.ProducerMessage) int
+	maxWait     time.Duration
+	concurrency int
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	queues [][]*prioritizedMessage
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type prioritizedMessage struct {
+	msg      *sarama.ProducerMessage
+	enqueued time.Time
+	done     chan prioritizedResult
+}
+
+type prioritizedResult struct {
+	partition int32
+	offset    int64
+	err       error
+}
+
+// NewPrioritySyncProducer returns a SyncProducer that queues messages into
+// levels priority queues and sends them through inner highest priority first.
+// priorityFn maps a message to its level, where levels-1 is the highest
+// priority; results outside [0, levels) are clamped. Messages within a level
+// are sent in FIFO order, and a message is never overtaken for longer than the
+// configured maximum wait (see WithPriorityMaxWait).
+//
+// SendMessage still blocks until the message has been produced. Close drains
+// the queues before closing inner.
+func NewPrioritySyncProducer(inner SyncProducer, priorityFn func(*sarama.ProducerMessage) int, levels int, opts ...PriorityOption) SyncProducer {
+	if levels < 1 {
+		levels = 1
+	}
+	pp := &prioritySyncProducer{
+		priorityFn:  priorityFn,
+		maxWait:     defaultPriorityMaxWait,
+		concurrency: 1,
+		queues:      make([][]*prioritizedMessage, levels),
+	}
+	pp.decorator = newDecorator(inner, pp)
+	for _, opt := range opts {
+		opt(pp)
+	}
+	if pp.concurrency < 1 {
+		pp.concurrency = 1
+	}
+	pp.cond = sync.NewCond(&pp.lock)
+
+	pp.wg.Add(pp.concurrency)
+	for i := 0; i < pp.concurrency; i++ {
+		go pp.run()
+	}
+	return pp
+}
+
+func (pp *prioritySyncProducer) enqueue(msg *sarama.ProducerMessage) (*prioritizedMessage, error) {
+	level := pp.priorityFn(msg)
+	if level < 0 {
+		level = 0
+	} else if level >= len(pp.queues) {
+		level = len(pp.queues) - 1
+	}
+
+	pm := &prioritizedMessage{msg: msg, enqueued: time.Now(), done: make(chan prioritizedResult, 1)}
+
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	if pp.closed {
+		return nil, sarama.ErrShuttingDown
+	}
+	pp.queues[level] = append(pp.queues[level], pm)
+	pp.cond.Signal()
+	return pm, nil
+}
+
+// next blocks until a message is available and dequeues it, or returns nil
+// once the producer is closed and every queue has been drained.
+func (pp *prioritySyncProducer) next() *prioritizedMessage {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	for {
+		chosen := -1
+		var oldest time.Time
+		// starved messages go first, oldest wins
+		for level, queue := range pp.queues {
+			if len(queue) == 0 || time.Since(queue[0].enqueued) < pp.maxWait {
+				continue
+			}
+			if chosen == -1 || queue[0].enqueued.Before(oldest) {
+				chosen, oldest = level, queue[0].enqueued
+			}
+		}
+		if chosen == -1 {
+			for level := len(pp.queues) - 1; level >= 0; level-- {
+				if len(pp.queues[level]) > 0 {
+					chosen = level
+					break
+				}
+			}
+		}
+
+		if chosen != -1 {
+			pm := pp.queues[chosen][0]
+			pp.queues[chosen][0] = nil
+			pp.queues[chosen] = pp.queues[chosen][1:]
+			return pm
+		}
+		if pp.closed {
+			return nil
+		}
+		pp.cond.Wait()
+	}
+}
+
+func (pp *prioritySyncProducer) run() {
+	defer pp.wg.Done()
+	for pm := pp.next(); pm != nil; pm = pp.next() {
+		partition, offset, err := pp.SyncProducer.SendMessage(pm.msg)
+		pm.done <- prioritizedResult{partition: partition, offset: offset, err: err}
+	}
+}
+
+func (pp *prioritySyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	pm, err := pp.enqueue(msg)
+	if err != nil {
+		return -1, -1, err
+	}
+	res := <-pm.done
+	return res.partition, res.offset, res.err
+}
+
+func (pp *prioritySyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errors ProducerErrors
+	pending := make([]*prioritizedMessage, len(msgs))
+	for i, msg := range msgs {
+		pm, err := pp.enqueue(msg)
+		if err != nil {
+			errors = append(errors, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+			continue
+		}
+		pending[i] = pm
+	}
+
+	for i, pm := range pending {
+		if pm == nil {
+			continue
+		}
+		if res := <-pm.done; res.err != nil {
+			errors = append(errors, &ProducerError{Msg: pm.msg, Err: res.err, BatchIndex: i})
+		}
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+func (pp *prioritySyncProducer) Close() error {
+	pp.lock.Lock()
+	pp.closed = true
+	pp.cond.Broadcast()
+	pp.lock.Unlock()
+
+	pp.wg.Wait()
+	return pp.SyncProducer.Close()
+}
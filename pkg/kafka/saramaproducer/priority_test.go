@@ -0,0 +1,148 @@
+package saramaproducer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedSyncProducer reports every message handed to SendMessage on entered
+// and holds it until release is closed.
+type gatedSyncProducer struct {
+	SyncProducer
+	entered chan *sarama.ProducerMessage
+	release chan struct{}
+}
+
+func newGatedSyncProducer(inner SyncProducer) *gatedSyncProducer {
+	return &gatedSyncProducer{
+		SyncProducer: inner,
+		entered:      make(chan *sarama.ProducerMessage, 16),
+		release:      make(chan struct{}),
+	}
+}
+
+func (gp *gatedSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	gp.entered <- msg
+	<-gp.release
+	return gp.SyncProducer.SendMessage(msg)
+}
+
+func priorityOf(msg *sarama.ProducerMessage) int {
+	return int(msg.Key.(sarama.ByteEncoder)[0])
+}
+
+func newPriorityTestMessage(priority byte) *sarama.ProducerMessage {
+	msg := newTestMessage()
+	msg.Key = sarama.ByteEncoder{priority}
+	return msg
+}
+
+// queued returns the number of messages waiting in the queues of pp.
+func queued(pp *prioritySyncProducer) int {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	var n int
+	for _, queue := range pp.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// sendAsync sends msgs one by one in the background, returning a channel
+// receiving the error of each send.
+func sendAsync(producer SyncProducer, msgs ...*sarama.ProducerMessage) <-chan error {
+	errs := make(chan error, len(msgs))
+	for _, msg := range msgs {
+		go func() {
+			_, _, err := producer.SendMessage(msg)
+			errs <- err
+		}()
+	}
+	return errs
+}
+
+func TestPrioritySyncProducer_HighestPriorityFirst(t *testing.T) {
+	gated := newGatedSyncProducer(newTestSyncProducer(t))
+	producer := NewPrioritySyncProducer(gated, priorityOf, 3, WithPriorityMaxWait(time.Hour))
+	pp := producer.(*prioritySyncProducer)
+
+	blocker := newPriorityTestMessage(0)
+	errs := sendAsync(producer, blocker)
+	require.Same(t, blocker, <-gated.entered)
+
+	low, mid, high := newPriorityTestMessage(0), newPriorityTestMessage(1), newPriorityTestMessage(7)
+	errs2 := sendAsync(producer, low, mid, high)
+	require.Eventually(t, func() bool { return queued(pp) == 3 }, time.Second, time.Millisecond)
+	close(gated.release)
+
+	// levels out of range are clamped to the highest one
+	require.Same(t, high, <-gated.entered)
+	require.Same(t, mid, <-gated.entered)
+	require.Same(t, low, <-gated.entered)
+	require.NoError(t, <-errs)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, <-errs2)
+	}
+	require.NoError(t, producer.Close())
+}
+
+func TestPrioritySyncProducer_StarvedMessageGoesFirst(t *testing.T) {
+	const maxWait = 20 * time.Millisecond
+
+	gated := newGatedSyncProducer(newTestSyncProducer(t))
+	producer := NewPrioritySyncProducer(gated, priorityOf, 2, WithPriorityMaxWait(maxWait))
+	pp := producer.(*prioritySyncProducer)
+
+	blocker := newPriorityTestMessage(1)
+	sendAsync(producer, blocker)
+	require.Same(t, blocker, <-gated.entered)
+
+	low := newPriorityTestMessage(0)
+	sendAsync(producer, low)
+	require.Eventually(t, func() bool { return queued(pp) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(2 * maxWait)
+	high := newPriorityTestMessage(1)
+	sendAsync(producer, high)
+	require.Eventually(t, func() bool { return queued(pp) == 2 }, time.Second, time.Millisecond)
+	close(gated.release)
+
+	require.Same(t, low, <-gated.entered)
+	require.Same(t, high, <-gated.entered)
+	require.NoError(t, producer.Close())
+}
+
+func TestPrioritySyncProducer_CloseDrainsQueues(t *testing.T) {
+	gated := newGatedSyncProducer(newTestSyncProducer(t))
+	producer := NewPrioritySyncProducer(gated, priorityOf, 2)
+	pp := producer.(*prioritySyncProducer)
+
+	errs := sendAsync(producer, newPriorityTestMessage(0))
+	<-gated.entered
+	queuedErrs := sendAsync(producer, newPriorityTestMessage(0), newPriorityTestMessage(1))
+	require.Eventually(t, func() bool { return queued(pp) == 2 }, time.Second, time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- producer.Close() }()
+	require.Eventually(t, func() bool {
+		pp.lock.Lock()
+		defer pp.lock.Unlock()
+		return pp.closed
+	}, time.Second, time.Millisecond)
+	_, _, err := producer.SendMessage(newPriorityTestMessage(1))
+	require.ErrorIs(t, err, sarama.ErrShuttingDown)
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the queues were drained")
+	default:
+	}
+
+	close(gated.release)
+	require.NoError(t, <-errs)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, <-queuedErrs)
+	}
+	require.NoError(t, <-closed)
+}
@@ -0,0 +1,101 @@
+package saramaproducer
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// recordErrorRateMetric is the meter, in the client registry, of the records
+// that failed to be produced. Sarama has no such metric, so the SyncProducer
+// marks it itself.
+const recordErrorRateMetric = "record-error-rate"
+
+var (
+	prometheusLatencyBuckets   = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	prometheusBatchSizeBuckets = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+)
+
+// NewPrometheusExporter returns an http.Handler serving the producer metrics
+// of producer's client registry (Config.MetricRegistry) in the Prometheus text
+// exposition format:
+//
+//	kafka_producer_messages_sent_total                 counter    from record-send-rate
+//	kafka_producer_messages_errored_total              counter    from record-error-rate
+//	kafka_producer_request_latency_seconds_histogram   histogram  from request-latency-in-ms
+//	kafka_producer_batch_size_bytes_histogram          histogram  from batch-size
+//
+// go-metrics histograms keep an exponentially decaying sample rather than
+// cumulative buckets, so bucket counts and sums are estimated by scaling the
+// current sample to the total number of observations. Counters are exact.
+//
+// producer must have been created by NewSyncProducer or
+// NewSyncProducerFromClient, or be one of the decorators of this package
+// wrapping such a producer; NewPrometheusExporter returns ErrNotSupported for
+// any other implementation.
+func NewPrometheusExporter(producer SyncProducer) (http.Handler, error) {
+	sp, err := coreOf(producer, "NewPrometheusExporter")
+	if err != nil {
+		return nil, err
+	}
+	registry := sp.conf.MetricRegistry
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+
+		writePrometheusCounter(bw, "kafka_producer_messages_sent_total",
+			"Total number of records sent to all topics.", registry.Get("record-send-rate"))
+		writePrometheusCounter(bw, "kafka_producer_messages_errored_total",
+			"Total number of records that failed to be produced.", registry.Get(recordErrorRateMetric))
+		writePrometheusHistogram(bw, "kafka_producer_request_latency_seconds_histogram",
+			"Request latency for all brokers in seconds.", registry.Get("request-latency-in-ms"), 1e-3, prometheusLatencyBuckets)
+		writePrometheusHistogram(bw, "kafka_producer_batch_size_bytes_histogram",
+			"Bytes sent per partition per request for all topics.", registry.Get("batch-size"), 1, prometheusBatchSizeBuckets)
+
+		_ = bw.Flush()
+	}), nil
+}
+
+func writePrometheusCounter(w *bufio.Writer, name, help string, metric interface{}) {
+	var count int64
+	if meter, ok := metric.(metrics.Meter); ok {
+		count = meter.Count()
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, count)
+}
+
+// writePrometheusHistogram writes metric, with its values multiplied by scale,
+// as a Prometheus histogram with the given upper bounds.
+func writePrometheusHistogram(w *bufio.Writer, name, help string, metric interface{}, scale float64, buckets []float64) {
+	var (
+		count  int64
+		sum    float64
+		values []int64
+	)
+	if histogram, ok := metric.(metrics.Histogram); ok {
+		snapshot := histogram.Snapshot()
+		count = snapshot.Count()
+		sum = snapshot.Mean() * float64(count) * scale
+		values = snapshot.Sample().Values()
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, bound := range buckets {
+		var inBucket int
+		for _, v := range values {
+			if float64(v)*scale <= bound {
+				inBucket++
+			}
+		}
+		var estimate int64
+		if len(values) > 0 {
+			estimate = int64(math.Round(float64(count) * float64(inBucket) / float64(len(values))))
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, estimate)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, count, name, sum, name, count)
+}
@@ -0,0 +1,36 @@
+package saramaproducer
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusExporter_RecordErrors(t *testing.T) {
+	produce := sarama.NewMockProduceResponse(t).
+		SetError(testTopic, 0, sarama.ErrMessageSizeTooLarge).
+		SetError(testTopic, 1, sarama.ErrMessageSizeTooLarge)
+	broker := newTestBroker(t, produce)
+	config := newTestConfig()
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, _, err = producer.SendMessage(newTestMessage())
+		require.ErrorIs(t, err, sarama.ErrMessageSizeTooLarge)
+	}
+
+	exporter, err := NewPrometheusExporter(producer)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	exporter.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "\nkafka_producer_messages_errored_total 2\n")
+
+	require.NoError(t, producer.Close())
+	require.Nil(t, config.MetricRegistry.Get(recordErrorRateMetric))
+}
@@ -0,0 +1,56 @@
+package saramaproducer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtoSyncProducer_SendMessageProto(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewProtoSyncProducer(inner)
+
+	_, _, err := producer.SendMessageProto(context.Background(), testTopic, "key", wrapperspb.String("foo"))
+	require.NoError(t, err)
+
+	sent := recorder.messages()
+	require.Len(t, sent, 1)
+	msg := consumed(t, sent[0])
+	require.Equal(t, []byte("key"), msg.Key)
+	decoded := &wrapperspb.StringValue{}
+	require.NoError(t, proto.Unmarshal(msg.Value, decoded))
+	require.Equal(t, "foo", decoded.GetValue())
+
+	// proto3 strings must be valid UTF-8
+	_, _, err = producer.SendMessageProto(context.Background(), testTopic, "key", wrapperspb.String("\xff"))
+	var encodeErr ProtoEncodeError
+	require.ErrorAs(t, err, &encodeErr)
+	require.Equal(t, "google.protobuf.StringValue", encodeErr.MessageType)
+	require.Len(t, recorder.messages(), 1)
+}
+
+func TestProtoKeySyncProducer_SendMessageWithProtoKey(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	producer := NewProtoKeySyncProducer(inner)
+
+	// equal keys always encode to the same bytes
+	for i := 0; i < 2; i++ {
+		_, _, err := producer.SendMessageWithProtoKey(context.Background(), testTopic, wrapperspb.Int64(42), []byte("foo"))
+		require.NoError(t, err)
+	}
+
+	sent := recorder.messages()
+	require.Len(t, sent, 2)
+	first, second := consumed(t, sent[0]), consumed(t, sent[1])
+	require.Equal(t, first.Key, second.Key)
+	decoded := &wrapperspb.Int64Value{}
+	require.NoError(t, proto.Unmarshal(first.Key, decoded))
+	require.Equal(t, int64(42), decoded.GetValue())
+	require.Equal(t, []byte("foo"), first.Value)
+
+	_, _, err := producer.SendMessageWithProtoKey(context.Background(), testTopic, wrapperspb.String("\xff"), []byte("foo"))
+	require.ErrorAs(t, err, new(ProtoEncodeError))
+}
@@ -0,0 +1,206 @@
+package saramaproducer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log/level"
+)
+
+// rebalancePollInterval is how often a SyncProducer created with
+// NewRebalanceAwareSyncProducer describes its consumer group.
+const rebalancePollInterval = time.Second
+
+type rebalanceAwareSyncProducer struct {
+	decorator
+	sp      *syncProducer
+	groupID string
+
+	lock sync.Mutex
+	// assigned holds the partitions assigned to the group when it was last
+	// seen stable
+	assigned map[string]map[int32]struct{}
+	// resumed is closed when a rebalance completes; it is nil while the
+	// group is not rebalancing
+	resumed chan struct{}
+
+	closing chan struct{}
+	done    chan struct{}
+	// closeOnce runs the shutdown of Close once, closeErr is its result
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewRebalanceAwareSyncProducer returns a SyncProducer that holds back
+// messages for partitions consumed by groupID while the group rebalances, so
+// that they are not produced before the new owners have taken over. The
+// group is described every second; while it is preparing or completing a
+// rebalance, messages for the partitions it was assigned when last seen
+// stable wait until it is stable, empty or gone again. As the partition of a
+// message is only known once it has been partitioned, messages without a
+// manually chosen partition wait if any partition of their topic is affected.
+// If the group cannot be described, messages are not held back.
+//
+// inner must have been created by NewSyncProducer or
+// NewSyncProducerFromClient, or be one of the decorators of this package
+// wrapping such a producer; NewRebalanceAwareSyncProducer returns
+// ErrNotSupported for any other implementation. Every sending method is held
+// back, SendMessageToPartition included; all other methods are forwarded to
+// inner unchanged.
+func NewRebalanceAwareSyncProducer(inner SyncProducer, groupID string) (SyncProducer, error) {
+	sp, err := coreOf(inner, "NewRebalanceAwareSyncProducer")
+	if err != nil {
+		return nil, err
+	}
+	rp := &rebalanceAwareSyncProducer{
+		sp:      sp,
+		groupID: groupID,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	rp.decorator = newDecorator(inner, rp)
+	go rp.watch()
+	return rp, nil
+}
+
+func (rp *rebalanceAwareSyncProducer) watch() {
+	defer close(rp.done)
+
+	ticker := time.NewTicker(rebalancePollInterval)
+	defer ticker.Stop()
+
+	for {
+		rp.poll()
+		select {
+		case <-ticker.C:
+		case <-rp.closing:
+			return
+		}
+	}
+}
+
+func (rp *rebalanceAwareSyncProducer) poll() {
+	admin, err := rp.sp.admin()
+	var groups []*sarama.GroupDescription
+	if err == nil {
+		groups, err = admin.DescribeConsumerGroups([]string{rp.groupID})
+	}
+	if err == nil && len(groups) == 1 && groups[0].Err != sarama.ErrNoError {
+		err = groups[0].Err
+	}
+	if err != nil {
+		level.Warn(rp.sp.logger).Log("msg", "failed to describe consumer group", "group", rp.groupID, "err", err)
+		rp.resume()
+		return
+	}
+
+	group := groups[0]
+	switch group.State {
+	case "PreparingRebalance", "CompletingRebalance":
+		rp.lock.Lock()
+		if rp.resumed == nil {
+			level.Info(rp.sp.logger).Log("msg", "consumer group is rebalancing, holding back messages for its partitions", "group", rp.groupID)
+			rp.resumed = make(chan struct{})
+		}
+		rp.lock.Unlock()
+	case "Stable":
+		assigned := make(map[string]map[int32]struct{})
+		for _, member := range group.Members {
+			assignment, err := member.GetMemberAssignment()
+			if err != nil || assignment == nil {
+				continue
+			}
+			for topic, partitions := range assignment.Topics {
+				if assigned[topic] == nil {
+					assigned[topic] = make(map[int32]struct{})
+				}
+				for _, partition := range partitions {
+					assigned[topic][partition] = struct{}{}
+				}
+			}
+		}
+		rp.lock.Lock()
+		rp.assigned = assigned
+		rp.lock.Unlock()
+		rp.resume()
+	default:
+		rp.resume()
+	}
+}
+
+// resume releases the messages held back for a rebalance, if any.
+func (rp *rebalanceAwareSyncProducer) resume() {
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	if rp.resumed != nil {
+		level.Info(rp.sp.logger).Log("msg", "consumer group is no longer rebalancing", "group", rp.groupID)
+		close(rp.resumed)
+		rp.resumed = nil
+	}
+}
+
+// affected reports whether msg may be produced to a partition of a
+// rebalancing group.
+func (rp *rebalanceAwareSyncProducer) affected(msg *sarama.ProducerMessage) bool {
+	partitions, ok := rp.assigned[msg.Topic]
+	if !ok {
+		return false
+	}
+	if !isManualPartition(msg) {
+		return true
+	}
+	_, ok = partitions[msg.Partition]
+	return ok
+}
+
+// wait blocks until none of msgs is held back by a rebalance, returning
+// ErrShuttingDown if the producer is closed first.
+func (rp *rebalanceAwareSyncProducer) wait(msgs ...*sarama.ProducerMessage) error {
+	for {
+		rp.lock.Lock()
+		resumed := rp.resumed
+		held := false
+		if resumed != nil {
+			for _, msg := range msgs {
+				if rp.affected(msg) {
+					held = true
+					break
+				}
+			}
+		}
+		rp.lock.Unlock()
+		if !held {
+			return nil
+		}
+
+		select {
+		case <-resumed:
+		case <-rp.closing:
+			return sarama.ErrShuttingDown
+		}
+	}
+}
+
+func (rp *rebalanceAwareSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if err := rp.wait(msg); err != nil {
+		return -1, -1, err
+	}
+	return rp.SyncProducer.SendMessage(msg)
+}
+
+func (rp *rebalanceAwareSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	if err := rp.wait(msgs...); err != nil {
+		return err
+	}
+	return rp.SyncProducer.SendMessages(msgs)
+}
+
+func (rp *rebalanceAwareSyncProducer) Close() error {
+	rp.closeOnce.Do(func() {
+		close(rp.closing)
+		<-rp.done
+		rp.closeErr = rp.SyncProducer.Close()
+	})
+	return rp.closeErr
+}
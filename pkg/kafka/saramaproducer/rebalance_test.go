@@ -0,0 +1,167 @@
+package saramaproducer
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+const rebalanceTestGroup = "test-group"
+
+// encodeMemberAssignment encodes a consumer group member assignment of
+// partitions of topic in the consumer protocol.
+func encodeMemberAssignment(topic string, partitions ...int32) []byte {
+	b := binary.BigEndian.AppendUint16(nil, 0)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(topic)))
+	b = append(b, topic...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(partitions)))
+	for _, partition := range partitions {
+		b = binary.BigEndian.AppendUint32(b, uint32(partition))
+	}
+	return binary.BigEndian.AppendUint32(b, 0)
+}
+
+// rebalanceTestBroker is a mock broker describing rebalanceTestGroup as
+// consuming partition 0 of testTopic, in the state set with setState.
+type rebalanceTestBroker struct {
+	*sarama.MockBroker
+	t *testing.T
+}
+
+func newRebalanceTestBroker(t *testing.T) *rebalanceTestBroker {
+	t.Helper()
+
+	broker := &rebalanceTestBroker{MockBroker: newTestBroker(t, sarama.NewMockProduceResponse(t)), t: t}
+	broker.setState("Stable")
+	return broker
+}
+
+func (b *rebalanceTestBroker) setState(state string) {
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(b.t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetController(b.BrokerID()).
+			SetLeader(testTopic, 0, b.BrokerID()).
+			SetLeader(testTopic, 1, b.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(b.t),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(b.t).
+			SetCoordinator(sarama.CoordinatorGroup, rebalanceTestGroup, b.MockBroker),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(b.t).
+			AddGroupDescription(rebalanceTestGroup, &sarama.GroupDescription{
+				GroupId: rebalanceTestGroup,
+				State:   state,
+				Members: map[string]*sarama.GroupMemberDescription{
+					"member": {MemberId: "member", MemberAssignment: encodeMemberAssignment(testTopic, 0)},
+				},
+			}),
+	})
+}
+
+func newRebalanceTestSyncProducer(t *testing.T, broker *rebalanceTestBroker) *rebalanceAwareSyncProducer {
+	t.Helper()
+
+	inner, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	producer, err := NewRebalanceAwareSyncProducer(inner, rebalanceTestGroup)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	rp := producer.(*rebalanceAwareSyncProducer)
+	require.Eventually(t, func() bool {
+		rp.lock.Lock()
+		defer rp.lock.Unlock()
+		return rp.assigned != nil
+	}, time.Second, time.Millisecond)
+	return rp
+}
+
+func TestRebalanceAwareSyncProducer_HoldsBackDuringRebalance(t *testing.T) {
+	broker := newRebalanceTestBroker(t)
+	producer := newRebalanceTestSyncProducer(t, broker)
+
+	broker.setState("PreparingRebalance")
+	producer.poll()
+
+	sent := make(chan error, 1)
+	go func() {
+		_, _, err := producer.SendMessage(newTestMessage())
+		sent <- err
+	}()
+	// messages for topics the group does not consume are not held back
+	require.NoError(t, producer.wait(&sarama.ProducerMessage{Topic: otherTestTopic}))
+	select {
+	case <-sent:
+		t.Fatal("message sent while the group is rebalancing")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	broker.setState("Stable")
+	producer.poll()
+	require.NoError(t, <-sent)
+}
+
+func TestRebalanceAwareSyncProducer_DescribeFailureResumes(t *testing.T) {
+	broker := newRebalanceTestBroker(t)
+	producer := newRebalanceTestSyncProducer(t, broker)
+
+	broker.setState("CompletingRebalance")
+	producer.poll()
+	producer.lock.Lock()
+	require.NotNil(t, producer.resumed)
+	producer.lock.Unlock()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"DescribeGroupsRequest": sarama.NewMockWrapper(&sarama.DescribeGroupsResponse{
+			Groups: []*sarama.GroupDescription{{GroupId: rebalanceTestGroup, Err: sarama.ErrGroupAuthorizationFailed}},
+		}),
+	})
+	producer.poll()
+	require.NoError(t, producer.wait(newTestMessage()))
+}
+
+func TestRebalanceAwareSyncProducer_CloseWhileHeldBack(t *testing.T) {
+	broker := newRebalanceTestBroker(t)
+	producer := newRebalanceTestSyncProducer(t, broker)
+
+	broker.setState("PreparingRebalance")
+	producer.poll()
+	sent := make(chan error, 1)
+	go func() {
+		_, _, err := producer.SendMessage(newTestMessage())
+		sent <- err
+	}()
+
+	require.NoError(t, producer.Close())
+	require.ErrorIs(t, <-sent, sarama.ErrShuttingDown)
+	// closed again by the cleanup of newRebalanceTestSyncProducer
+}
+
+func TestRebalanceAwareSyncProducer_SendMessageToPartitionIsHeld(t *testing.T) {
+	inner := &stubSyncProducer{}
+	rp := &rebalanceAwareSyncProducer{
+		assigned: map[string]map[int32]struct{}{testTopic: {1: {}}},
+		resumed:  make(chan struct{}),
+		closing:  make(chan struct{}),
+	}
+	rp.decorator = newDecorator(inner, rp)
+	// the producer shuts down while the group is still rebalancing
+	close(rp.closing)
+
+	_, err := rp.SendMessageToPartition(context.Background(), testTopic, 1, nil, []byte("foo"))
+	require.ErrorIs(t, err, sarama.ErrShuttingDown)
+	require.Zero(t, inner.sent.Load())
+
+	_, err = rp.SendMessageToPartition(context.Background(), testTopic, 0, nil, []byte("foo"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), inner.sent.Load())
+}
+
+func TestNewRebalanceAwareSyncProducer_RequiresCoreProducer(t *testing.T) {
+	_, err := NewRebalanceAwareSyncProducer(&stubSyncProducer{}, rebalanceTestGroup)
+	require.ErrorIs(t, err, ErrNotSupported)
+}
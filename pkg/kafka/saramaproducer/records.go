@@ -0,0 +1,282 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/IBM/sarama"
+	snappy "github.com/eapache/go-xerial-snappy"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+const (
+	// recordBatchOverhead is the size of a v2 RecordBatch header
+	recordBatchOverhead = 61
+	// magicOffset is the position of the magic byte in a RecordBatch and in a
+	// legacy message set entry
+	magicOffset = 16
+	// minRecordSize is the size of a v2 record with no key, value or
+	// headers, including its length
+	minRecordSize = 7
+
+	compressionCodecMask = 0x07
+	timestampTypeMask    = 0x08
+	transactionalMask    = 0x10
+	controlMask          = 0x20
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// rawReader reads the primitive types of the Kafka protocol from a byte slice.
+type rawReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *rawReader) fail() {
+	if r.err == nil {
+		r.err = sarama.PacketDecodingError{Info: "insufficient data"}
+	}
+}
+
+func (r *rawReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off+n > len(r.buf) {
+		r.fail()
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *rawReader) int8() int8 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (r *rawReader) int16() int16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (r *rawReader) int32() int32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (r *rawReader) int64() int64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (r *rawReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf[r.off:])
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.off += n
+	return v
+}
+
+// bytes reads a byte slice prefixed by an int32 length, -1 meaning nil.
+func (r *rawReader) bytes() []byte {
+	n := r.int32()
+	if n < 0 {
+		return nil
+	}
+	return bytes.Clone(r.next(int(n)))
+}
+
+// varintBytes reads a byte slice prefixed by a varint length, -1 meaning nil.
+func (r *rawReader) varintBytes() []byte {
+	n := r.varint()
+	if n < 0 {
+		return nil
+	}
+	return bytes.Clone(r.next(int(n)))
+}
+
+func (r *rawReader) remaining() int {
+	return len(r.buf) - r.off
+}
+
+// decodeRecordBatch decodes a v2 RecordBatch, checking its CRC.
+func decodeRecordBatch(raw []byte) (*sarama.RecordBatch, error) {
+	if len(raw) < recordBatchOverhead || raw[magicOffset] != 2 {
+		return nil, sarama.PacketEncodingError{Info: "ProduceRawBatch requires a v2 RecordBatch"}
+	}
+
+	r := &rawReader{buf: raw}
+	batch := &sarama.RecordBatch{}
+	batch.FirstOffset = r.int64()
+	length := r.int32()
+	if int(length) != len(raw)-12 {
+		return nil, sarama.PacketDecodingError{Info: fmt.Sprintf("record batch length %d does not match its %d bytes", length, len(raw)-12)}
+	}
+	batch.PartitionLeaderEpoch = r.int32()
+	batch.Version = r.int8()
+	crc := uint32(r.int32())
+	if crc32.Checksum(raw[21:], castagnoliTable) != crc {
+		return nil, sarama.PacketDecodingError{Info: "record batch CRC mismatch"}
+	}
+	attributes := r.int16()
+	batch.Codec = sarama.CompressionCodec(attributes & compressionCodecMask)
+	batch.CompressionLevel = sarama.CompressionLevelDefault
+	batch.LogAppendTime = attributes&timestampTypeMask != 0
+	batch.IsTransactional = attributes&transactionalMask != 0
+	batch.Control = attributes&controlMask != 0
+	batch.LastOffsetDelta = r.int32()
+	batch.FirstTimestamp = millisToTime(r.int64())
+	batch.MaxTimestamp = millisToTime(r.int64())
+	batch.ProducerID = r.int64()
+	batch.ProducerEpoch = r.int16()
+	batch.FirstSequence = r.int32()
+	count := r.int32()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	records, err := decompress(batch.Codec, raw[recordBatchOverhead:])
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || int(count) > len(records)/minRecordSize {
+		return nil, sarama.PacketDecodingError{Info: fmt.Sprintf("record batch count %d does not fit its %d bytes of records", count, len(records))}
+	}
+	rr := &rawReader{buf: records}
+	batch.Records = make([]*sarama.Record, 0, count)
+	for i := int32(0); i < count; i++ {
+		size := rr.varint()
+		record := &rawReader{buf: rr.next(int(size))}
+		if rr.err != nil {
+			return nil, rr.err
+		}
+		rec := &sarama.Record{}
+		rec.Attributes = record.int8()
+		rec.TimestampDelta = time.Duration(record.varint()) * time.Millisecond
+		rec.OffsetDelta = record.varint()
+		rec.Key = record.varintBytes()
+		rec.Value = record.varintBytes()
+		headers := record.varint()
+		for j := int64(0); j < headers && record.err == nil; j++ {
+			rec.Headers = append(rec.Headers, &sarama.RecordHeader{Key: record.varintBytes(), Value: record.varintBytes()})
+		}
+		if record.err != nil {
+			return nil, record.err
+		}
+		batch.Records = append(batch.Records, rec)
+	}
+	return batch, nil
+}
+
+// decodeLegacyMessage decodes a magic 0 or 1 Message, checking its CRC. A
+// compressed wrapper message is returned with its inner message set
+// decompressed into Value, so that it is compressed again when encoded, and
+// the number of messages it wraps.
+func decodeLegacyMessage(raw []byte) (*sarama.Message, int64, error) {
+	r := &rawReader{buf: raw}
+	crc := uint32(r.int32())
+	if r.err == nil && crc32.ChecksumIEEE(raw[4:]) != crc {
+		return nil, 0, sarama.PacketDecodingError{Info: "message CRC mismatch"}
+	}
+	msg := &sarama.Message{}
+	msg.Version = r.int8()
+	if msg.Version > 1 {
+		return nil, 0, sarama.PacketDecodingError{Info: fmt.Sprintf("unknown magic byte (%v)", msg.Version)}
+	}
+	attributes := r.int8()
+	msg.Codec = sarama.CompressionCodec(attributes & compressionCodecMask)
+	msg.CompressionLevel = sarama.CompressionLevelDefault
+	msg.LogAppendTime = attributes&timestampTypeMask != 0
+	if msg.Version == 1 {
+		msg.Timestamp = millisToTime(r.int64())
+	}
+	msg.Key = r.bytes()
+	msg.Value = r.bytes()
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if r.remaining() != 0 {
+		return nil, 0, sarama.PacketDecodingError{Info: "trailing bytes after message"}
+	}
+	if msg.Codec == sarama.CompressionNone {
+		return msg, 1, nil
+	}
+
+	inner, err := decompress(msg.Codec, msg.Value)
+	if err != nil {
+		return nil, 0, err
+	}
+	// a message set is a sequence of offset, size and message
+	var count int64
+	set := &rawReader{buf: inner}
+	for set.remaining() > 0 {
+		set.int64()
+		set.next(int(set.int32()))
+		if set.err != nil {
+			return nil, 0, set.err
+		}
+		count++
+	}
+	msg.Value = inner
+	return msg, count, nil
+}
+
+func millisToTime(millis int64) time.Time {
+	if millis < 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+func decompress(codec sarama.CompressionCodec, data []byte) ([]byte, error) {
+	switch codec {
+	case sarama.CompressionNone:
+		return data, nil
+	case sarama.CompressionGZIP:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(reader)
+	case sarama.CompressionSnappy:
+		return snappy.Decode(data)
+	case sarama.CompressionLZ4:
+		return io.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+	case sarama.CompressionZSTD:
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return decoder.DecodeAll(data, nil)
+	default:
+		return nil, sarama.PacketDecodingError{Info: fmt.Sprintf("invalid compression specified (%d)", codec)}
+	}
+}
@@ -0,0 +1,121 @@
+package saramaproducer
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeTestRecordBatch encodes an uncompressed v2 RecordBatch holding a
+// record per value, each with a single header.
+func encodeTestRecordBatch(first time.Time, values ...string) []byte {
+	var records []byte
+	for i, value := range values {
+		var record []byte
+		record = append(record, 0)                     // attributes
+		record = binary.AppendVarint(record, int64(i)) // timestamp delta
+		record = binary.AppendVarint(record, int64(i)) // offset delta
+		record = binary.AppendVarint(record, -1)       // nil key
+		record = binary.AppendVarint(record, int64(len(value)))
+		record = append(record, value...)
+		record = binary.AppendVarint(record, 1)
+		record = binary.AppendVarint(record, 1)
+		record = append(record, 'h')
+		record = binary.AppendVarint(record, 1)
+		record = append(record, 'v')
+		records = binary.AppendVarint(records, int64(len(record)))
+		records = append(records, record...)
+	}
+
+	// attributes up to the record count are covered by the CRC
+	var tail []byte
+	tail = binary.BigEndian.AppendUint16(tail, 0)
+	tail = binary.BigEndian.AppendUint32(tail, uint32(len(values)-1))
+	tail = binary.BigEndian.AppendUint64(tail, uint64(first.UnixMilli()))
+	tail = binary.BigEndian.AppendUint64(tail, uint64(first.UnixMilli()+int64(len(values)-1)))
+	tail = binary.BigEndian.AppendUint64(tail, ^uint64(0)) // producer id -1
+	tail = binary.BigEndian.AppendUint16(tail, ^uint16(0)) // producer epoch -1
+	tail = binary.BigEndian.AppendUint32(tail, ^uint32(0)) // first sequence -1
+	tail = binary.BigEndian.AppendUint32(tail, uint32(len(values)))
+	tail = append(tail, records...)
+
+	var batch []byte
+	batch = binary.BigEndian.AppendUint64(batch, 0) // first offset
+	batch = binary.BigEndian.AppendUint32(batch, uint32(4+1+4+len(tail)))
+	batch = binary.BigEndian.AppendUint32(batch, 0) // partition leader epoch
+	batch = append(batch, 2)                        // magic
+	batch = binary.BigEndian.AppendUint32(batch, crc32.Checksum(tail, castagnoliTable))
+	return append(batch, tail...)
+}
+
+func TestDecodeRecordBatch(t *testing.T) {
+	first := time.UnixMilli(1700000000000)
+	batch, err := decodeRecordBatch(encodeTestRecordBatch(first, "foo", "bar"))
+	require.NoError(t, err)
+
+	require.Equal(t, int8(2), batch.Version)
+	require.Equal(t, sarama.CompressionNone, batch.Codec)
+	require.Equal(t, first, batch.FirstTimestamp)
+	require.Equal(t, int32(1), batch.LastOffsetDelta)
+	require.Equal(t, int64(-1), batch.ProducerID)
+	require.Len(t, batch.Records, 2)
+	for i, value := range []string{"foo", "bar"} {
+		record := batch.Records[i]
+		require.Nil(t, record.Key)
+		require.Equal(t, value, string(record.Value))
+		require.Equal(t, int64(i), record.OffsetDelta)
+		require.Equal(t, time.Duration(i)*time.Millisecond, record.TimestampDelta)
+		require.Equal(t, []*sarama.RecordHeader{{Key: []byte("h"), Value: []byte("v")}}, record.Headers)
+	}
+}
+
+func TestDecodeRecordBatch_Invalid(t *testing.T) {
+	raw := encodeTestRecordBatch(time.Now(), "foo")
+
+	for name, corrupt := range map[string]func([]byte) []byte{
+		"truncated": func(b []byte) []byte { return b[:len(b)-1] },
+		"crc":       func(b []byte) []byte { b[len(b)-1] ^= 0xff; return b },
+		"magic":     func(b []byte) []byte { b[magicOffset] = 1; return b },
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeRecordBatch(corrupt(append([]byte(nil), raw...)))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDecodeRecordBatch_InvalidCount(t *testing.T) {
+	raw := encodeTestRecordBatch(time.Now(), "foo")
+
+	for _, count := range []int32{-1, 2, 1 << 30} {
+		corrupt := append([]byte(nil), raw...)
+		binary.BigEndian.PutUint32(corrupt[recordBatchOverhead-4:], uint32(count))
+		binary.BigEndian.PutUint32(corrupt[17:], crc32.Checksum(corrupt[21:], castagnoliTable))
+
+		_, err := decodeRecordBatch(corrupt)
+		require.ErrorAs(t, err, new(sarama.PacketDecodingError), "count %d", count)
+	}
+}
+
+func TestNewRecordBatch(t *testing.T) {
+	first := time.UnixMilli(1700000000000)
+	msgs := []*sarama.ProducerMessage{
+		{Topic: testTopic, Key: sarama.StringEncoder("k"), Value: sarama.StringEncoder("foo"), Timestamp: first},
+		{Topic: testTopic, Value: sarama.StringEncoder("bar"), Timestamp: first.Add(5 * time.Millisecond)},
+	}
+	batch, size, err := newRecordBatch(newTestConfig(), msgs)
+	require.NoError(t, err)
+
+	require.Greater(t, size, recordBatchOverhead)
+	require.Equal(t, first, batch.FirstTimestamp)
+	require.Equal(t, first.Add(5*time.Millisecond), batch.MaxTimestamp)
+	require.Equal(t, int32(1), batch.LastOffsetDelta)
+	require.Equal(t, []byte("k"), batch.Records[0].Key)
+	require.Nil(t, batch.Records[1].Key)
+	require.Equal(t, 5*time.Millisecond, batch.Records[1].TimestampDelta)
+	require.Equal(t, int64(1), batch.Records[1].OffsetDelta)
+}
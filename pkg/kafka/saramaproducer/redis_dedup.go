@@ -0,0 +1,214 @@
+package saramaproducer
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisDedupKeyPrefix namespaces the keys written by a SyncProducer created
+// with NewRedisDedupSyncProducer.
+const redisDedupKeyPrefix = "kafka:dedup:"
+
+type redisDedupSyncProducer struct {
+	decorator
+	client redis.UniversalClient
+	window time.Duration
+	logger log.Logger
+}
+
+// NewRedisDedupSyncProducer returns a SyncProducer that drops messages already
+// produced within window, possibly by another process sharing redisClient.
+// Messages are identified by the MD5 of their topic, key and value; once one
+// is produced, its partition and offset are stored in Redis under that hash
+// with a TTL of window, and a later identical message is not sent but
+// reported with the stored partition and offset.
+//
+// redisClient may be a single node, Sentinel or Cluster client. Two identical
+// messages sent concurrently may both be produced, and a message inner does
+// not report an offset for, as a buffering producer does, is not recorded. If
+// Redis cannot be reached, messages are sent without deduplication and the
+// failure is logged to the logger of inner.
+func NewRedisDedupSyncProducer(inner SyncProducer, redisClient redis.UniversalClient, window time.Duration) SyncProducer {
+	dp := &redisDedupSyncProducer{client: redisClient, window: window}
+	dp.decorator = newDecorator(inner, dp)
+	dp.logger = dp.decorator.coreLogger()
+	return dp
+}
+
+// dedupKey returns the Redis key identifying msg.
+func (dp *redisDedupSyncProducer) dedupKey(msg *sarama.ProducerMessage) (string, error) {
+	h := md5.New()
+	h.Write([]byte(msg.Topic))
+	for _, encoder := range []sarama.Encoder{msg.Key, msg.Value} {
+		if encoder == nil {
+			continue
+		}
+		b, err := encoder.Encode()
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+	}
+	return redisDedupKeyPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookup parses a value stored by remember, reporting false if val is not
+// one.
+func (dp *redisDedupSyncProducer) lookup(val interface{}) (partition int32, offset int64, ok bool) {
+	s, isString := val.(string)
+	if !isString {
+		return -1, -1, false
+	}
+	if _, err := fmt.Sscanf(s, "%d:%d", &partition, &offset); err != nil {
+		return -1, -1, false
+	}
+	return partition, offset, true
+}
+
+// remember records that the message identified by key was produced at
+// partition and offset. Nothing is recorded without an offset, as the
+// message may not have been produced yet.
+func (dp *redisDedupSyncProducer) remember(ctx context.Context, key string, topic string, partition int32, offset int64) {
+	if offset < 0 {
+		return
+	}
+	val := fmt.Sprintf("%d:%d", partition, offset)
+	if err := dp.client.Set(ctx, key, val, dp.window).Err(); err != nil {
+		level.Warn(dp.logger).Log("msg", "failed to record message for deduplication", "topic", topic, "err", err)
+	}
+}
+
+// lookupAll fetches the values stored for keys. The keys hash to different
+// slots of a Cluster, so they are fetched with one GET each, pipelined,
+// rather than with MGET; keys that cannot be fetched are reported as absent.
+func (dp *redisDedupSyncProducer) lookupAll(ctx context.Context, keys []string) []interface{} {
+	pipe := dp.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	// the error of each command is checked below
+	_, _ = pipe.Exec(ctx)
+
+	vals := make([]interface{}, len(keys))
+	var failures int
+	var lastErr error
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		switch {
+		case err == nil:
+			vals[i] = val
+		case !errors.Is(err, redis.Nil):
+			failures++
+			lastErr = err
+		}
+	}
+	if failures > 0 {
+		level.Warn(dp.logger).Log("msg", "failed to look up messages for deduplication, sending them anyway", "count", failures, "err", lastErr)
+	}
+	return vals
+}
+
+func (dp *redisDedupSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	key, err := dp.dedupKey(msg)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	ctx := context.Background()
+	val, err := dp.client.Get(ctx, key).Result()
+	switch {
+	case err == nil:
+		if partition, offset, ok := dp.lookup(val); ok {
+			msg.Partition, msg.Offset = partition, offset
+			return partition, offset, nil
+		}
+	case !errors.Is(err, redis.Nil):
+		level.Warn(dp.logger).Log("msg", "failed to look up message for deduplication, sending it anyway", "topic", msg.Topic, "err", err)
+	}
+
+	partition, offset, err = dp.SyncProducer.SendMessage(msg)
+	if err != nil {
+		return partition, offset, err
+	}
+	dp.remember(ctx, key, msg.Topic, partition, offset)
+	return partition, offset, nil
+}
+
+func (dp *redisDedupSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	var errs ProducerErrors
+	keys := make([]string, len(msgs))
+	for i, msg := range msgs {
+		key, err := dp.dedupKey(msg)
+		if err != nil {
+			errs = append(errs, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+		}
+		keys[i] = key
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	ctx := context.Background()
+	vals := dp.lookupAll(ctx, keys)
+
+	pending := make([]*sarama.ProducerMessage, 0, len(msgs))
+	positions := make([]int, 0, len(msgs))
+	first := make(map[string]*sarama.ProducerMessage, len(msgs))
+	var copies []int
+	for i, msg := range msgs {
+		if partition, offset, ok := dp.lookup(vals[i]); ok {
+			msg.Partition, msg.Offset = partition, offset
+			continue
+		}
+		if _, ok := first[keys[i]]; ok {
+			// only the first of identical messages in the batch is sent
+			copies = append(copies, i)
+			continue
+		}
+		first[keys[i]] = msg
+		pending = append(pending, msg)
+		positions = append(positions, i)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := dp.SyncProducer.SendMessages(pending)
+	var pErrs ProducerErrors
+	if err != nil && !errors.As(err, &pErrs) {
+		return err
+	}
+	failed := make(map[*sarama.ProducerMessage]error, len(pErrs))
+	for _, pErr := range pErrs {
+		failed[pErr.Msg] = pErr.Err
+	}
+
+	for j, msg := range pending {
+		if _, ok := failed[msg]; !ok {
+			dp.remember(ctx, keys[positions[j]], msg.Topic, msg.Partition, msg.Offset)
+		}
+	}
+	for _, i := range copies {
+		sent := first[keys[i]]
+		if err, ok := failed[sent]; ok {
+			errs = append(errs, &ProducerError{Msg: msgs[i], Err: err, BatchIndex: i})
+			continue
+		}
+		msgs[i].Partition, msgs[i].Offset = sent.Partition, sent.Offset
+	}
+	return mergeProducerErrors(errs, err, positions)
+}
@@ -0,0 +1,227 @@
+package saramaproducer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// testRedisServer speaks enough of the Redis protocol to serve GET and SET,
+// rejecting MGET of several keys as a Cluster does for keys in different
+// slots.
+type testRedisServer struct {
+	listener net.Listener
+
+	lock   sync.Mutex
+	values map[string]string
+	ttls   map[string]time.Duration
+}
+
+// newTestRedisClient returns a client of a testRedisServer, both closed at
+// the end of the test.
+func newTestRedisClient(t *testing.T) (*redis.Client, *testRedisServer) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &testRedisServer{listener: listener, values: make(map[string]string), ttls: make(map[string]time.Duration)}
+	go server.serve()
+	t.Cleanup(func() { _ = listener.Close() })
+
+	client := redis.NewClient(&redis.Options{Addr: listener.Addr().String(), Protocol: 2, DisableIdentity: true})
+	t.Cleanup(func() { _ = client.Close() })
+	return client, server
+}
+
+func (s *testRedisServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testRedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, s.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+// readRESPCommand reads a command sent as an array of bulk strings.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (s *testRedisServer) exec(args []string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		val, ok := s.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)
+	case "SET":
+		s.values[args[1]] = args[2]
+		if len(args) == 5 {
+			n, _ := strconv.Atoi(args[4])
+			unit := time.Second
+			if strings.EqualFold(args[3], "px") {
+				unit = time.Millisecond
+			}
+			s.ttls[args[1]] = time.Duration(n) * unit
+		}
+		return "+OK\r\n"
+	case "MGET":
+		if len(args) > 2 {
+			return "-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+		}
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (s *testRedisServer) stored() map[string]time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ttls := make(map[string]time.Duration, len(s.ttls))
+	for key, ttl := range s.ttls {
+		ttls[key] = ttl
+	}
+	return ttls
+}
+
+func TestRedisDedupSyncProducer_SendMessage(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	client, server := newTestRedisClient(t)
+	producer := NewRedisDedupSyncProducer(inner, client, time.Minute)
+
+	first := newTestMessage()
+	partition, offset, err := producer.SendMessage(first)
+	require.NoError(t, err)
+	require.True(t, recorder.wasSent(first))
+	stored := server.stored()
+	require.Len(t, stored, 1)
+	for _, ttl := range stored {
+		require.Equal(t, time.Minute, ttl)
+	}
+
+	second := newTestMessage()
+	dupPartition, dupOffset, err := producer.SendMessage(second)
+	require.NoError(t, err)
+	require.False(t, recorder.wasSent(second))
+	require.Equal(t, partition, dupPartition)
+	require.Equal(t, offset, dupOffset)
+
+	other := newTestMessage()
+	other.Key = sarama.StringEncoder("other")
+	_, _, err = producer.SendMessage(other)
+	require.NoError(t, err)
+	require.True(t, recorder.wasSent(other))
+	require.Len(t, server.stored(), 2)
+}
+
+func TestRedisDedupSyncProducer_SendMessages(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	client, server := newTestRedisClient(t)
+	producer := NewRedisDedupSyncProducer(inner, client, time.Minute)
+
+	produced := newTestMessage()
+	produced.Key = sarama.StringEncoder("produced")
+	_, _, err := producer.SendMessage(produced)
+	require.NoError(t, err)
+
+	// the keys of the batch are looked up one by one, as MGET fails on a
+	// Cluster
+	again := newTestMessage()
+	again.Key = sarama.StringEncoder("produced")
+	msgs := []*sarama.ProducerMessage{again, newTestMessage(), newTestMessage()}
+	require.NoError(t, producer.SendMessages(msgs))
+
+	require.False(t, recorder.wasSent(msgs[0]))
+	require.Equal(t, produced.Partition, msgs[0].Partition)
+	require.Equal(t, produced.Offset, msgs[0].Offset)
+	// only the first of identical messages in the batch is sent
+	require.True(t, recorder.wasSent(msgs[1]))
+	require.False(t, recorder.wasSent(msgs[2]))
+	require.Equal(t, msgs[1].Partition, msgs[2].Partition)
+	require.Equal(t, msgs[1].Offset, msgs[2].Offset)
+	require.Len(t, server.stored(), 2)
+}
+
+func TestRedisDedupSyncProducer_BufferedMessagesAreNotRecorded(t *testing.T) {
+	buffering := NewBufferingSyncProducer(&rejectingSyncProducer{}, 4, time.Hour)
+	client, server := newTestRedisClient(t)
+	producer := NewRedisDedupSyncProducer(buffering, client, time.Minute)
+
+	// the message is buffered, not produced
+	_, offset, err := producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), offset)
+	require.NoError(t, producer.SendMessages([]*sarama.ProducerMessage{{Topic: testTopic, Value: sarama.StringEncoder("bar")}}))
+	require.Empty(t, server.stored())
+	require.NoError(t, producer.Close())
+}
+
+func TestRedisDedupSyncProducer_RedisUnavailable(t *testing.T) {
+	inner, recorder := newRecordingTestSyncProducer(t)
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DisableIdentity: true})
+	t.Cleanup(func() { _ = client.Close() })
+	producer := NewRedisDedupSyncProducer(inner, client, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		msg := newTestMessage()
+		_, _, err := producer.SendMessage(msg)
+		require.NoError(t, err)
+		require.True(t, recorder.wasSent(msg))
+	}
+
+	msgs := []*sarama.ProducerMessage{newTestMessage(), newTestMessage()}
+	require.NoError(t, producer.SendMessages(msgs))
+	require.True(t, recorder.wasSent(msgs[0]))
+	require.False(t, recorder.wasSent(msgs[1]))
+}
@@ -0,0 +1,77 @@
+package saramaproducer
+
+import (
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// redpandaClusterIDPrefix starts the cluster ID that Redpanda reports in
+// metadata responses; Apache Kafka cluster IDs are bare base64 UUIDs.
+const redpandaClusterIDPrefix = "redpanda."
+
+// NewRedpandaSyncProducer creates a SyncProducer like NewSyncProducer, after
+// checking whether the cluster at addrs is Redpanda and, if so, adjusting a
+// copy of config for it. Redpanda only accepts v2 record batches, so a
+// Version below V0_11_0_0, which would make the producer send legacy message
+// sets, is raised to V2_1_0_0. Other settings, including RequiredAcks and
+// the transaction settings, behave as with Apache Kafka and are left alone.
+//
+// Since the ApiVersions response carries no vendor, the cluster is detected by
+// the "redpanda." prefix of the cluster ID returned in a metadata request to
+// the first reachable address. If no address can be reached, or the brokers
+// are too old to report a cluster ID, config is used as it is. opts are
+// passed on to NewSyncProducer, and the detection logs to the logger set
+// with WithLogger.
+func NewRedpandaSyncProducer(addrs []string, config *sarama.Config, opts ...SyncProducerOption) (SyncProducer, error) {
+	if config == nil {
+		config = sarama.NewConfig()
+		config.Producer.Return.Successes = true
+	}
+
+	// the producer is only created once the configuration is settled, so the
+	// logger is taken from the options
+	probe := &syncProducer{logger: log.NewNopLogger()}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	logger := probe.logger
+
+	if isRedpanda(addrs, config, logger) {
+		level.Info(logger).Log("msg", "detected a Redpanda cluster, adjusting configuration")
+		config = cloneConfig(config)
+		if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+			config.Version = sarama.V2_1_0_0
+		}
+	}
+
+	return NewSyncProducer(addrs, config, opts...)
+}
+
+// isRedpanda reports whether the first broker in addrs that answers a
+// metadata request belongs to a Redpanda cluster.
+func isRedpanda(addrs []string, config *sarama.Config, logger log.Logger) bool {
+	version := config.Version
+	if !version.IsAtLeast(sarama.V0_10_1_0) {
+		// the oldest version whose metadata response includes the cluster ID
+		version = sarama.V0_10_1_0
+	}
+
+	for _, addr := range addrs {
+		broker := sarama.NewBroker(addr)
+		if err := broker.Open(config); err != nil {
+			level.Warn(logger).Log("msg", "failed to open broker", "addr", addr, "err", err)
+			continue
+		}
+		metadata, err := broker.GetMetadata(sarama.NewMetadataRequest(version, nil))
+		_ = broker.Close()
+		if err != nil {
+			level.Warn(logger).Log("msg", "failed to fetch metadata", "addr", addr, "err", err)
+			continue
+		}
+		return metadata.ClusterID != nil && strings.HasPrefix(*metadata.ClusterID, redpandaClusterIDPrefix)
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package saramaproducer
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// globalProducers holds the producers created with the RegisterGlobal option.
+var globalProducers = struct {
+	lock      sync.Mutex
+	producers map[*syncProducer]struct{}
+}{producers: make(map[*syncProducer]struct{})}
+
+// ProducerStateInfo is a snapshot of the state of a SyncProducer, as returned
+// by ListProducerStates.
+type ProducerStateInfo struct {
+	// ClientID is the producer's Config.ClientID.
+	ClientID string
+	// TransactionalID is empty for non-transactional producers.
+	TransactionalID string
+	TxnStatus       sarama.ProducerTxnStatusFlag
+	// ConnectedBrokers lists the addresses of the brokers the producer's
+	// client currently has an open connection to.
+	ConnectedBrokers []string
+	// Pending is the number of messages not yet acknowledged, see
+	// SyncProducer.LocalBufferSize.
+	Pending int
+}
+
+// RegisterGlobal adds the SyncProducer to a package-level registry until it
+// is closed, so that it is included in ListProducerStates. This helps to
+// spot producers stuck in a transaction, e.g. after a recovered panic.
+func RegisterGlobal() SyncProducerOption {
+	return func(sp *syncProducer) {
+		sp.registerGlobal = true
+	}
+}
+
+func registerProducer(sp *syncProducer) {
+	globalProducers.lock.Lock()
+	defer globalProducers.lock.Unlock()
+	globalProducers.producers[sp] = struct{}{}
+}
+
+func unregisterProducer(sp *syncProducer) {
+	globalProducers.lock.Lock()
+	defer globalProducers.lock.Unlock()
+	delete(globalProducers.producers, sp)
+}
+
+// ListProducerStates returns a snapshot of every open SyncProducer created
+// with the RegisterGlobal option, in no particular order.
+func ListProducerStates() []ProducerStateInfo {
+	globalProducers.lock.Lock()
+	producers := make([]*syncProducer, 0, len(globalProducers.producers))
+	for sp := range globalProducers.producers {
+		producers = append(producers, sp)
+	}
+	globalProducers.lock.Unlock()
+
+	states := make([]ProducerStateInfo, 0, len(producers))
+	for _, sp := range producers {
+		state := ProducerStateInfo{
+			ClientID:        sp.conf.ClientID,
+			TransactionalID: sp.TransactionalID(),
+			TxnStatus:       sp.TxnStatus(),
+			Pending:         sp.LocalBufferSize(),
+		}
+		for _, broker := range sp.client.Brokers() {
+			if connected, _ := broker.Connected(); connected {
+				state.ConnectedBrokers = append(state.ConnectedBrokers, broker.Addr())
+			}
+		}
+		states = append(states, state)
+	}
+	return states
+}
@@ -0,0 +1,48 @@
+package saramaproducer
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// registeredStates returns the states listed by ListProducerStates for
+// producers with clientID, as other tests may register producers too.
+func registeredStates(clientID string) []ProducerStateInfo {
+	var states []ProducerStateInfo
+	for _, state := range ListProducerStates() {
+		if state.ClientID == clientID {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+func TestListProducerStates(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.ClientID = "registry-test"
+
+	unregistered, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, unregistered.Close()) })
+	require.Empty(t, registeredStates(config.ClientID))
+
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config, RegisterGlobal())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = producer.Close() })
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+
+	states := registeredStates(config.ClientID)
+	require.Len(t, states, 1)
+	require.Empty(t, states[0].TransactionalID)
+	require.Zero(t, states[0].TxnStatus&sarama.ProducerTxnFlagInTransaction)
+	require.Equal(t, []string{broker.Addr()}, states[0].ConnectedBrokers)
+	require.Zero(t, states[0].Pending)
+
+	// closed producers are dropped from the registry
+	require.NoError(t, producer.Close())
+	require.Empty(t, registeredStates(config.ClientID))
+}
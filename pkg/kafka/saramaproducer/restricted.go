@@ -0,0 +1,162 @@
+package saramaproducer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrTopicNotPermitted is returned by a SyncProducer created with
+// NewRestrictedSyncProducer when a message targets a topic its TopicPolicy
+// does not allow.
+var ErrTopicNotPermitted = errors.New("kafka: producing to this topic is not permitted")
+
+// TopicPolicy decides which topics a restricted SyncProducer may write to.
+// Create one with Allowlist or Denylist.
+type TopicPolicy struct {
+	allow  bool
+	topics map[string]struct{}
+}
+
+// Allowlist returns a TopicPolicy permitting only the given topics.
+func Allowlist(topics ...string) TopicPolicy {
+	return TopicPolicy{allow: true, topics: headerKeySet(topics)}
+}
+
+// Denylist returns a TopicPolicy permitting every topic except the given ones.
+func Denylist(topics ...string) TopicPolicy {
+	return TopicPolicy{allow: false, topics: headerKeySet(topics)}
+}
+
+// Permits reports whether the policy allows producing to topic.
+func (tp TopicPolicy) Permits(topic string) bool {
+	_, listed := tp.topics[topic]
+	return listed == tp.allow
+}
+
+func (tp TopicPolicy) check(topic string) error {
+	if !tp.Permits(topic) {
+		return fmt.Errorf("%w: %s", ErrTopicNotPermitted, topic)
+	}
+	return nil
+}
+
+type restrictedSyncProducer struct {
+	decorator
+	policy TopicPolicy
+}
+
+// NewRestrictedSyncProducer returns a SyncProducer that refuses to produce to
+// topics not permitted by policy, returning ErrTopicNotPermitted before any
+// network IO takes place. SendMessages and SendMessagesBatched send the
+// permitted messages and report the others as ProducerErrors.
+//
+// Every sending method is checked, including those of the transactions
+// returned by StartTransaction; all other methods are forwarded to inner
+// unchanged.
+func NewRestrictedSyncProducer(inner SyncProducer, policy TopicPolicy) SyncProducer {
+	rp := &restrictedSyncProducer{policy: policy}
+	rp.decorator = newPreSendDecorator(inner, rp)
+	return rp
+}
+
+func (rp *restrictedSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if err := rp.policy.check(msg.Topic); err != nil {
+		return -1, -1, err
+	}
+	return rp.SyncProducer.SendMessage(msg)
+}
+
+func (rp *restrictedSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var rejected ProducerErrors
+	permitted := make([]*sarama.ProducerMessage, 0, len(msgs))
+	positions := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		if err := rp.policy.check(msg.Topic); err != nil {
+			rejected = append(rejected, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+			continue
+		}
+		permitted = append(permitted, msg)
+		positions = append(positions, i)
+	}
+
+	var err error
+	if len(permitted) > 0 {
+		err = rp.SyncProducer.SendMessages(permitted)
+	}
+	return mergeProducerErrors(rejected, err, positions)
+}
+
+// The methods below look up the topic on the broker before sending, or do not
+// send through SendMessage at all, so the topic is checked upfront.
+
+func (rp *restrictedSyncProducer) SendMessageWithExpiry(msg *sarama.ProducerMessage, ttl time.Duration) (int32, int64, error) {
+	if err := rp.policy.check(msg.Topic); err != nil {
+		return -1, -1, err
+	}
+	return rp.decorator.SendMessageWithExpiry(msg, ttl)
+}
+
+func (rp *restrictedSyncProducer) SendTombstone(ctx context.Context, topic string, key []byte) (partition int32, offset int64, err error) {
+	if err := rp.policy.check(topic); err != nil {
+		return -1, -1, err
+	}
+	return rp.decorator.SendTombstone(ctx, topic, key)
+}
+
+func (rp *restrictedSyncProducer) MigrateMessages(ctx context.Context, fromTopic, toTopic string, transform func(*sarama.ConsumerMessage) (*sarama.ProducerMessage, error)) (int64, error) {
+	if err := rp.policy.check(toTopic); err != nil {
+		return 0, err
+	}
+	return rp.decorator.MigrateMessages(ctx, fromTopic, toTopic, transform)
+}
+
+func (rp *restrictedSyncProducer) ProduceRawBatch(topic string, partition int32, batch []byte) error {
+	if err := rp.policy.check(topic); err != nil {
+		return err
+	}
+	return rp.SyncProducer.ProduceRawBatch(topic, partition, batch)
+}
+
+func (rp *restrictedSyncProducer) SendMessagesBinary(rawMessages [][]byte, topic string, partition int32) ([]int64, error) {
+	if err := rp.policy.check(topic); err != nil {
+		return nil, err
+	}
+	return rp.SyncProducer.SendMessagesBinary(rawMessages, topic, partition)
+}
+
+func (rp *restrictedSyncProducer) SendMessageBatch(msgs []*sarama.ProducerMessage) ([]ProducerResult, error) {
+	// the batch is written as a whole, so one rejected message rejects it
+	for _, msg := range msgs {
+		if err := rp.policy.check(msg.Topic); err != nil {
+			return nil, err
+		}
+	}
+	return rp.SyncProducer.SendMessageBatch(msgs)
+}
+
+// mergeProducerErrors combines errs with the error returned by a SendMessages
+// style call, which is either nil, ProducerErrors or a single error. The
+// BatchIndex of the latter is translated through positions, which maps indices
+// of the forwarded messages to indices of the caller's input.
+func mergeProducerErrors(errs ProducerErrors, err error, positions []int) error {
+	if err != nil {
+		var pErrs ProducerErrors
+		if !errors.As(err, &pErrs) {
+			return err
+		}
+		for _, pErr := range pErrs {
+			if pErr.BatchIndex < len(positions) {
+				pErr.BatchIndex = positions[pErr.BatchIndex]
+			}
+		}
+		errs = append(errs, pErrs...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package saramaproducer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestrictedSyncProducer_ChecksEverySendMethod(t *testing.T) {
+	msgs := func() []*sarama.ProducerMessage {
+		return []*sarama.ProducerMessage{newTestMessage(), newTestMessage()}
+	}
+	sends := map[string]func(p SyncProducer) error{
+		"SendMessage": func(p SyncProducer) error {
+			_, _, err := p.SendMessage(newTestMessage())
+			return err
+		},
+		"SendMessageZeroCopy": func(p SyncProducer) error {
+			_, _, err := p.SendMessageZeroCopy(testTopic, 0, nil, []byte("foo"))
+			return err
+		},
+		"SendMessageToPartition": func(p SyncProducer) error {
+			_, err := p.SendMessageToPartition(context.Background(), testTopic, 0, nil, []byte("foo"))
+			return err
+		},
+		"SendMessageWithExpiry": func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithExpiry(newTestMessage(), time.Second)
+			return err
+		},
+		"SendMessageWithMetadata": func(p SyncProducer) error {
+			_, err := p.SendMessageWithMetadata(newTestMessage())
+			return err
+		},
+		"SendMessageWithSchema": func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithSchema(newTestMessage(), 1, 1)
+			return err
+		},
+		"SendMessageWithTimestamp": func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithTimestamp(newTestMessage(), time.Now())
+			return err
+		},
+		"SendMessageWithCorrelationID": func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithCorrelationID(context.Background(), newTestMessage(), "id")
+			return err
+		},
+		"SendMessageWithSLA": func(p SyncProducer) error {
+			_, _, err := p.SendMessageWithSLA(newTestMessage(), 5*time.Second)
+			return err
+		},
+		"SendMessageWithCallback": func(p SyncProducer) error {
+			done := make(chan error, 1)
+			p.SendMessageWithCallback(newTestMessage(), func(_ int32, _ int64, err error) { done <- err })
+			return <-done
+		},
+		"SendMessageWithFallback": func(p SyncProducer) error {
+			_, _, _, err := p.SendMessageWithFallback(newTestMessage(), newTestMessage())
+			return err
+		},
+		"SendTombstone": func(p SyncProducer) error {
+			_, _, err := p.SendTombstone(context.Background(), testTopic, []byte("key"))
+			return err
+		},
+		"ProduceRawBatch": func(p SyncProducer) error {
+			return p.ProduceRawBatch(testTopic, 0, []byte("raw"))
+		},
+		"SendMessagesBinary": func(p SyncProducer) error {
+			_, err := p.SendMessagesBinary([][]byte{[]byte("raw")}, testTopic, 0)
+			return err
+		},
+		"SendMessageBatch": func(p SyncProducer) error {
+			_, err := p.SendMessageBatch(msgs())
+			return err
+		},
+		"SendMessages": func(p SyncProducer) error {
+			return p.SendMessages(msgs())
+		},
+		"SendMessagesWithPartialRetry": func(p SyncProducer) error {
+			_, err := p.SendMessagesWithPartialRetry(msgs(), RetryPolicy{MaxAttempts: 2})
+			return err
+		},
+		"SendMessagesSequential": func(p SyncProducer) error {
+			return p.SendMessagesSequential(msgs())
+		},
+		"SendMessagesBatched": func(p SyncProducer) error {
+			ch := make(chan *sarama.ProducerMessage, 2)
+			for _, msg := range msgs() {
+				ch <- msg
+			}
+			close(ch)
+			return p.SendMessagesBatched(context.Background(), ch, 10, time.Millisecond)
+		},
+		"SendMessageAndConsume": func(p SyncProducer) error {
+			_, _, _, err := p.SendMessageAndConsume(newTestMessage(), nil)
+			return err
+		},
+		"MigrateMessages": func(p SyncProducer) error {
+			_, err := p.MigrateMessages(context.Background(), otherTestTopic, testTopic, nil)
+			return err
+		},
+	}
+	// transactions need a transactional inner producer
+	txnSends := map[string]func(p SyncProducer) error{
+		"StartTransaction": func(p SyncProducer) error {
+			txn, err := p.StartTransaction(context.Background())
+			require.NoError(t, err)
+			defer func() { _ = txn.Abort() }()
+			_, _, err = txn.SendMessage(newTestMessage())
+			return err
+		},
+		"ConsumeAndProduce": func(p SyncProducer) error {
+			return p.ConsumeAndProduce(context.Background(), &sarama.ConsumerMessage{Topic: otherTestTopic}, newTestMessage(), "group")
+		},
+	}
+
+	iface := reflect.TypeOf((*SyncProducer)(nil)).Elem()
+	for i := 0; i < iface.NumMethod(); i++ {
+		name := iface.Method(i).Name
+		if !strings.HasPrefix(name, "Send") && !strings.HasPrefix(name, "Produce") {
+			continue
+		}
+		_, ok := sends[name]
+		_, txnOK := txnSends[name]
+		require.True(t, ok || txnOK, "%s is not covered", name)
+	}
+
+	policy := Denylist(testTopic)
+	for name, send := range sends {
+		t.Run(name, func(t *testing.T) {
+			inner := newCountingSyncProducer(newTestSyncProducer(t))
+			requireNotPermitted(t, send(NewRestrictedSyncProducer(inner, policy)))
+			require.Zero(t, inner.sent.Load())
+		})
+	}
+	for name, send := range txnSends {
+		t.Run(name, func(t *testing.T) {
+			inner := newCountingSyncProducer(txnSyncProducer{})
+			requireNotPermitted(t, send(NewRestrictedSyncProducer(inner, policy)))
+			require.Zero(t, inner.sent.Load())
+		})
+	}
+}
+
+// requireNotPermitted asserts that err, or every error of a ProducerErrors,
+// is ErrTopicNotPermitted.
+func requireNotPermitted(t *testing.T, err error) {
+	t.Helper()
+
+	var pErrs ProducerErrors
+	if !errors.As(err, &pErrs) {
+		require.ErrorIs(t, err, ErrTopicNotPermitted)
+		return
+	}
+	require.NotEmpty(t, pErrs)
+	for _, pErr := range pErrs {
+		require.ErrorIs(t, pErr.Err, ErrTopicNotPermitted)
+	}
+}
+
+func TestRestrictedSyncProducer_SendMessages(t *testing.T) {
+	producer := NewRestrictedSyncProducer(newTestSyncProducer(t), Allowlist(testTopic))
+
+	msgs := []*sarama.ProducerMessage{
+		newTestMessage(),
+		{Topic: otherTestTopic, Value: sarama.StringEncoder("foo")},
+		newTestMessage(),
+	}
+	var pErrs ProducerErrors
+	require.ErrorAs(t, producer.SendMessages(msgs), &pErrs)
+	require.Len(t, pErrs, 1)
+	require.Equal(t, 1, pErrs[0].BatchIndex)
+	require.ErrorIs(t, pErrs[0].Err, ErrTopicNotPermitted)
+}
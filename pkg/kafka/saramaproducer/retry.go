@@ -0,0 +1,106 @@
+package saramaproducer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// RetryPolicy controls which failed messages
+// SyncProducer.SendMessagesWithPartialRetry resends and how often.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a message may be sent,
+	// including the first attempt. Values below 1 are treated as 1.
+	MaxAttempts int
+	// ShouldRetry reports whether a message that failed with err should be
+	// resent. If nil, every error is retried.
+	ShouldRetry func(err error) bool
+	// Backoff is how long to wait before each retry (default 0).
+	Backoff time.Duration
+}
+
+func (rp RetryPolicy) shouldRetry(err error) bool {
+	return rp.ShouldRetry == nil || rp.ShouldRetry(err)
+}
+
+// ProducerResult is the outcome of sending a single message with
+// SyncProducer.SendMessagesWithPartialRetry or SyncProducer.SendMessageBatch.
+type ProducerResult struct {
+	Msg       *sarama.ProducerMessage
+	Partition int32
+	Offset    int64
+	// Err is the error of the final attempt, or nil on success.
+	Err error
+	// Attempts is the number of times the message was sent.
+	Attempts int
+}
+
+// ProducerResults holds one ProducerResult per input message, in input order.
+type ProducerResults []ProducerResult
+
+func (sp *syncProducer) SendMessagesWithPartialRetry(msgs []*sarama.ProducerMessage, retryPolicy RetryPolicy) (ProducerResults, error) {
+	return sendMessagesWithPartialRetry(sp, msgs, retryPolicy)
+}
+
+// sendMessagesWithPartialRetry implements SendMessagesWithPartialRetry on
+// top of p.SendMessages.
+func sendMessagesWithPartialRetry(p SyncProducer, msgs []*sarama.ProducerMessage, retryPolicy RetryPolicy) (ProducerResults, error) {
+	results := make(ProducerResults, len(msgs))
+	pending := make([]int, len(msgs))
+	for i, msg := range msgs {
+		results[i] = ProducerResult{Msg: msg, Partition: -1, Offset: -1}
+		pending[i] = i
+	}
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		if attempt > 1 && retryPolicy.Backoff > 0 {
+			time.Sleep(retryPolicy.Backoff)
+		}
+
+		batch := make([]*sarama.ProducerMessage, len(pending))
+		for i, idx := range pending {
+			batch[i] = msgs[idx]
+			results[idx].Attempts = attempt
+		}
+
+		failed := make(map[int]error)
+		if err := p.SendMessages(batch); err != nil {
+			var pErrs ProducerErrors
+			if errors.As(err, &pErrs) {
+				for _, pErr := range pErrs {
+					failed[pending[pErr.BatchIndex]] = pErr.Err
+				}
+			} else {
+				for _, idx := range pending {
+					failed[idx] = err
+				}
+			}
+		}
+
+		var retry []int
+		for _, idx := range pending {
+			err, ok := failed[idx]
+			if !ok {
+				results[idx].Partition, results[idx].Offset, results[idx].Err = msgs[idx].Partition, msgs[idx].Offset, nil
+				continue
+			}
+			results[idx].Err = err
+			if attempt < retryPolicy.MaxAttempts && retryPolicy.shouldRetry(err) {
+				retry = append(retry, idx)
+			}
+		}
+		pending = retry
+	}
+
+	var pErrs ProducerErrors
+	for i, result := range results {
+		if result.Err != nil {
+			pErrs = append(pErrs, &ProducerError{Msg: result.Msg, Err: result.Err, BatchIndex: i})
+		}
+	}
+	if len(pErrs) > 0 {
+		return results, pErrs
+	}
+	return results, nil
+}
@@ -0,0 +1,55 @@
+package saramaproducer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func (sp *syncProducer) SendMessageAndConsume(msg *sarama.ProducerMessage, consumerConfig *sarama.Config) (int32, int64, *sarama.ConsumerMessage, error) {
+	return sp.sendMessageAndConsume(sp, msg, consumerConfig)
+}
+
+// sendMessageAndConsume implements SendMessageAndConsume, producing msg with
+// p.SendMessage and consuming it back with sp's client.
+func (sp *syncProducer) sendMessageAndConsume(p SyncProducer, msg *sarama.ProducerMessage, consumerConfig *sarama.Config) (int32, int64, *sarama.ConsumerMessage, error) {
+	partition, offset, err := p.SendMessage(msg)
+	if err != nil {
+		return -1, -1, nil, err
+	}
+
+	client := sp.client
+	if consumerConfig == nil {
+		consumerConfig = client.Config()
+	}
+	conf := cloneConfig(consumerConfig)
+	conf.Consumer.Return.Errors = true
+
+	consumer, err := sarama.NewConsumerFromClient(&configOverrideClient{Client: client, conf: conf})
+	if err != nil {
+		return partition, offset, nil, err
+	}
+	defer func() { _ = consumer.Close() }()
+
+	pc, err := consumer.ConsumePartition(msg.Topic, partition, offset)
+	if err != nil {
+		return partition, offset, nil, err
+	}
+	defer func() { _ = pc.Close() }()
+
+	timeout := time.NewTimer(conf.Net.ReadTimeout)
+	defer timeout.Stop()
+	select {
+	case consumed := <-pc.Messages():
+		if consumed.Offset != offset {
+			// the record at offset was skipped, e.g. as part of an aborted transaction
+			return partition, offset, nil, fmt.Errorf("%w: found offset %d instead of %d", ErrMessageNotConsumed, consumed.Offset, offset)
+		}
+		return partition, offset, consumed, nil
+	case consumeErr := <-pc.Errors():
+		return partition, offset, nil, consumeErr
+	case <-timeout.C:
+		return partition, offset, nil, fmt.Errorf("%w: %s/%d offset %d not received within %s", ErrMessageNotConsumed, msg.Topic, partition, offset, conf.Net.ReadTimeout)
+	}
+}
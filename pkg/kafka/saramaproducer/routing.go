@@ -0,0 +1,108 @@
+package saramaproducer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+// DefaultRoute is the key of the producer used by a routing SyncProducer when
+// the router returns a key that is not in its producers map.
+const DefaultRoute = "*"
+
+type routingSyncProducer struct {
+	decorator
+	producers map[string]SyncProducer
+	router    func(*sarama.ProducerMessage) string
+}
+
+// NewRoutingSyncProducer returns a SyncProducer that sends each message through
+// producers[router(msg)], falling back to producers[DefaultRoute] when the
+// returned key is unknown, e.g. to route events to different clusters based on
+// their content. router may change the message, for instance its topic,
+// before it is sent.
+//
+// Every method sending ProducerMessages routes them and Close closes every
+// producer; all other methods are served by the default producer.
+// NewRoutingSyncProducer returns a ConfigurationError if producers has no
+// DefaultRoute entry.
+func NewRoutingSyncProducer(producers map[string]SyncProducer, router func(*sarama.ProducerMessage) string) (SyncProducer, error) {
+	fallback, ok := producers[DefaultRoute]
+	if !ok {
+		return nil, sarama.ConfigurationError(fmt.Sprintf("NewRoutingSyncProducer requires a %q producer", DefaultRoute))
+	}
+	rp := &routingSyncProducer{
+		producers: producers,
+		router:    router,
+	}
+	rp.decorator = newDecorator(fallback, rp)
+	return rp, nil
+}
+
+func (rp *routingSyncProducer) route(msg *sarama.ProducerMessage) SyncProducer {
+	if p, ok := rp.producers[rp.router(msg)]; ok {
+		return p
+	}
+	return rp.SyncProducer
+}
+
+func (rp *routingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	return rp.route(msg).SendMessage(msg)
+}
+
+func (rp *routingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	type route struct {
+		msgs      []*sarama.ProducerMessage
+		positions []int
+	}
+	routes := make(map[SyncProducer]*route)
+	for i, msg := range msgs {
+		p := rp.route(msg)
+		r, ok := routes[p]
+		if !ok {
+			r = new(route)
+			routes[p] = r
+		}
+		r.msgs = append(r.msgs, msg)
+		r.positions = append(r.positions, i)
+	}
+
+	var failed ProducerErrors
+	for p, r := range routes {
+		err := p.SendMessages(r.msgs)
+		if err == nil {
+			continue
+		}
+		var pErrs ProducerErrors
+		if !errors.As(err, &pErrs) {
+			for i, msg := range r.msgs {
+				pErrs = append(pErrs, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+			}
+		}
+		for _, pErr := range pErrs {
+			pErr.BatchIndex = r.positions[pErr.BatchIndex]
+		}
+		failed = append(failed, pErrs...)
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+func (rp *routingSyncProducer) Close() error {
+	var errs []error
+	closed := make(map[SyncProducer]struct{}, len(rp.producers))
+	for _, p := range rp.producers {
+		if _, ok := closed[p]; ok {
+			continue
+		}
+		closed[p] = struct{}{}
+		if err := p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
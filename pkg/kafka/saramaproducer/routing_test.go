@@ -0,0 +1,69 @@
+package saramaproducer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRoutingSyncProducer_RequiresDefaultRoute(t *testing.T) {
+	_, err := NewRoutingSyncProducer(map[string]SyncProducer{"a": &stubSyncProducer{}}, func(*sarama.ProducerMessage) string { return "a" })
+	require.ErrorAs(t, err, new(sarama.ConfigurationError))
+}
+
+func TestRoutingSyncProducer_SendMessage(t *testing.T) {
+	a, fallback := &stubSyncProducer{}, &stubSyncProducer{}
+	producer, err := NewRoutingSyncProducer(map[string]SyncProducer{"a": a, DefaultRoute: fallback}, func(msg *sarama.ProducerMessage) string { return msg.Topic })
+	require.NoError(t, err)
+
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: "a"})
+	require.NoError(t, err)
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: "b"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), a.sent.Load())
+	require.Equal(t, int64(1), fallback.sent.Load())
+}
+
+func TestRoutingSyncProducer_UnknownKeyUsesDefaultRoute(t *testing.T) {
+	fallback := &stubSyncProducer{}
+	producer, err := NewRoutingSyncProducer(map[string]SyncProducer{"a": &stubSyncProducer{}, DefaultRoute: fallback}, func(*sarama.ProducerMessage) string { return "unknown" })
+	require.NoError(t, err)
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.NoError(t, err)
+	require.NoError(t, producer.SendMessages([]*sarama.ProducerMessage{newTestMessage(), newTestMessage()}))
+	require.Equal(t, int64(3), fallback.sent.Load())
+}
+
+func TestRoutingSyncProducer_SendMessagesSplitsByRoute(t *testing.T) {
+	a, fallback := &stubSyncProducer{err: errors.New("down")}, &stubSyncProducer{}
+	producer, err := NewRoutingSyncProducer(map[string]SyncProducer{"a": a, DefaultRoute: fallback}, func(msg *sarama.ProducerMessage) string { return msg.Topic })
+	require.NoError(t, err)
+
+	msgs := []*sarama.ProducerMessage{{Topic: "b"}, {Topic: "a"}, {Topic: "b"}, {Topic: "a"}}
+	err = producer.SendMessages(msgs)
+	require.Equal(t, int64(2), a.sent.Load())
+	require.Equal(t, int64(2), fallback.sent.Load())
+
+	// the errors of a route point into the whole batch
+	var pErrs ProducerErrors
+	require.ErrorAs(t, err, &pErrs)
+	require.Len(t, pErrs, 2)
+	for i, pErr := range pErrs {
+		require.Equal(t, 2*i+1, pErr.BatchIndex)
+		require.Same(t, msgs[pErr.BatchIndex], pErr.Msg)
+		require.EqualError(t, pErr.Err, "down")
+	}
+}
+
+func TestRoutingSyncProducer_CloseClosesSharedProducerOnce(t *testing.T) {
+	shared, other := &stubSyncProducer{}, &stubSyncProducer{}
+	producer, err := NewRoutingSyncProducer(map[string]SyncProducer{"a": shared, "b": other, DefaultRoute: shared}, func(*sarama.ProducerMessage) string { return "a" })
+	require.NoError(t, err)
+
+	require.NoError(t, producer.Close())
+	require.Equal(t, int64(1), shared.closed.Load())
+	require.Equal(t, int64(1), other.closed.Load())
+}
@@ -0,0 +1,86 @@
+package saramaproducer
+
+import (
+	"math/rand"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// SampleRatioHeader is the record header in which the copies sent by a
+// SyncProducer created with NewSamplingSyncProducer carry the sample rate,
+// so that consumers of the debug topic can scale what they count.
+const SampleRatioHeader = "x-sample-ratio"
+
+type samplingSyncProducer struct {
+	decorator
+	debugTopic string
+	sampleRate float64
+	ratio      []byte
+	logger     log.Logger
+}
+
+// NewSamplingSyncProducer returns a SyncProducer that, in addition to
+// sending every message to its topic, sends a copy of a random sample of them
+// to debugTopic. Each message is copied with probability sampleRate, which is
+// clamped to [0, 1]. Copies keep the key, value, timestamp and headers of the
+// original, plus a SampleRatioHeader. They are sent after the original and
+// failures to send them are only logged to the logger of inner.
+func NewSamplingSyncProducer(inner SyncProducer, debugTopic string, sampleRate float64) SyncProducer {
+	sampleRate = max(0, min(sampleRate, 1))
+	sp := &samplingSyncProducer{
+		debugTopic: debugTopic,
+		sampleRate: sampleRate,
+		ratio:      []byte(strconv.FormatFloat(sampleRate, 'g', -1, 64)),
+	}
+	sp.decorator = newDecorator(inner, sp)
+	sp.logger = sp.decorator.coreLogger()
+	return sp
+}
+
+func (sp *samplingSyncProducer) sampled() bool {
+	return sp.sampleRate > 0 && rand.Float64() < sp.sampleRate
+}
+
+func (sp *samplingSyncProducer) sample(msg *sarama.ProducerMessage) *sarama.ProducerMessage {
+	headers := make([]sarama.RecordHeader, len(msg.Headers), len(msg.Headers)+1)
+	copy(headers, msg.Headers)
+	headers = append(headers, sarama.RecordHeader{Key: []byte(SampleRatioHeader), Value: sp.ratio})
+	return &sarama.ProducerMessage{
+		Topic:     sp.debugTopic,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+func (sp *samplingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if !sp.sampled() {
+		return sp.SyncProducer.SendMessage(msg)
+	}
+	debug := sp.sample(msg)
+	partition, offset, err = sp.SyncProducer.SendMessage(msg)
+	if _, _, debugErr := sp.SyncProducer.SendMessage(debug); debugErr != nil {
+		level.Warn(sp.logger).Log("msg", "failed to send sample", "topic", msg.Topic, "debug_topic", sp.debugTopic, "err", debugErr)
+	}
+	return partition, offset, err
+}
+
+func (sp *samplingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var debug []*sarama.ProducerMessage
+	for _, msg := range msgs {
+		if sp.sampled() {
+			debug = append(debug, sp.sample(msg))
+		}
+	}
+	err := sp.SyncProducer.SendMessages(msgs)
+	if len(debug) > 0 {
+		if debugErr := sp.SyncProducer.SendMessages(debug); debugErr != nil {
+			level.Warn(sp.logger).Log("msg", "failed to send samples", "count", len(debug), "debug_topic", sp.debugTopic, "err", debugErr)
+		}
+	}
+	return err
+}
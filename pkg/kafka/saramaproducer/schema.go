@@ -0,0 +1,211 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrSchemaIncompatible is returned by a SyncProducer created with
+// WithSchemaCompatibilityCheck when the schema of a message value is rejected
+// by the schema registry.
+var ErrSchemaIncompatible = errors.New("kafka: message value schema is incompatible with the registered schemas")
+
+// CompatibilityLevel selects the registered schema versions a value schema is
+// tested against by WithSchemaCompatibilityCheck. The names follow the schema
+// registry's compatibility levels.
+type CompatibilityLevel int
+
+const (
+	// CompatibilityNone disables the check.
+	CompatibilityNone CompatibilityLevel = iota
+	// CompatibilityBackward tests against the latest version.
+	CompatibilityBackward
+	// CompatibilityBackwardTransitive tests against all versions.
+	CompatibilityBackwardTransitive
+	// CompatibilityForward tests against the latest version.
+	CompatibilityForward
+	// CompatibilityForwardTransitive tests against all versions.
+	CompatibilityForwardTransitive
+	// CompatibilityFull tests against the latest version.
+	CompatibilityFull
+	// CompatibilityFullTransitive tests against all versions.
+	CompatibilityFullTransitive
+)
+
+func (l CompatibilityLevel) transitive() bool {
+	switch l {
+	case CompatibilityBackwardTransitive, CompatibilityForwardTransitive, CompatibilityFullTransitive:
+		return true
+	}
+	return false
+}
+
+// schemaRegistryTimeout bounds each request to the schema registry.
+const schemaRegistryTimeout = 10 * time.Second
+
+type schemaCheckKey struct {
+	subject  string
+	schemaID uint32
+}
+
+// schemaRegistry is a client for the REST API of a Confluent compatible
+// schema registry.
+type schemaRegistry struct {
+	url    string
+	client *http.Client
+}
+
+func newSchemaRegistry(registryURL string) schemaRegistry {
+	return schemaRegistry{
+		url:    strings.TrimSuffix(registryURL, "/"),
+		client: &http.Client{Timeout: schemaRegistryTimeout},
+	}
+}
+
+type schemaCompatibilityChecker struct {
+	registry schemaRegistry
+	level    CompatibilityLevel
+
+	// results holds the outcome, nil or an ErrSchemaIncompatible error, of
+	// every check answered by the registry
+	results sync.Map // schemaCheckKey -> error
+}
+
+// WithSchemaCompatibilityCheck makes SendMessage and SendMessages test the
+// schema of each message value framed in the schema registry wire format,
+// as SendMessageWithSchema produces, against the versions registered for the
+// "<topic>-value" subject at registryURL. Messages whose schema is rejected
+// fail with ErrSchemaIncompatible without being sent; values not in the wire
+// format are not checked. Results are cached per subject and schema ID, so
+// the registry is only queried the first time a schema is sent to a topic.
+//
+// The registry applies the kind of compatibility configured for the subject;
+// compatibility only selects whether the latest version or all versions are
+// tested, and should match that configuration. A subject with no versions
+// accepts any schema.
+func WithSchemaCompatibilityCheck(registryURL string, compatibility CompatibilityLevel) SyncProducerOption {
+	return func(sp *syncProducer) {
+		if compatibility == CompatibilityNone {
+			return
+		}
+		sp.schemaCheck = &schemaCompatibilityChecker{
+			registry: newSchemaRegistry(registryURL),
+			level:    compatibility,
+		}
+	}
+}
+
+func (c *schemaCompatibilityChecker) check(msg *sarama.ProducerMessage) error {
+	if msg.Value == nil {
+		return nil
+	}
+	value, err := msg.Value.Encode()
+	if err != nil {
+		return err
+	}
+	if len(value) < 5 || value[0] != 0 {
+		return nil
+	}
+
+	key := schemaCheckKey{subject: msg.Topic + "-value", schemaID: binary.BigEndian.Uint32(value[1:5])}
+	if result, ok := c.results.Load(key); ok {
+		if result == nil {
+			return nil
+		}
+		return result.(error)
+	}
+
+	compatible, err := c.query(key)
+	if err != nil {
+		return err
+	}
+	var result error
+	if !compatible {
+		result = fmt.Errorf("%w: schema %d for subject %s", ErrSchemaIncompatible, key.schemaID, key.subject)
+	}
+	c.results.Store(key, result)
+	return result
+}
+
+// query asks the registry whether the schema key.schemaID is compatible with
+// the versions of key.subject.
+func (c *schemaCompatibilityChecker) query(key schemaCheckKey) (bool, error) {
+	var schema struct {
+		Schema     string            `json:"schema"`
+		SchemaType string            `json:"schemaType,omitempty"`
+		References []json.RawMessage `json:"references,omitempty"`
+	}
+	status, err := c.registry.do(context.Background(), http.MethodGet, fmt.Sprintf("/schemas/ids/%d", key.schemaID), nil, &schema)
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("kafka: schema registry returned %d for schema %d", status, key.schemaID)
+	}
+
+	body, err := json.Marshal(schema)
+	if err != nil {
+		return false, err
+	}
+	path := "/compatibility/subjects/" + url.PathEscape(key.subject) + "/versions"
+	if !c.level.transitive() {
+		path += "/latest"
+	}
+	var result struct {
+		IsCompatible bool `json:"is_compatible"`
+	}
+	status, err = c.registry.do(context.Background(), http.MethodPost, path, body, &result)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK:
+		return result.IsCompatible, nil
+	case http.StatusNotFound:
+		// nothing registered for the subject yet
+		return true, nil
+	default:
+		return false, fmt.Errorf("kafka: schema registry returned %d checking schema %d for subject %s", status, key.schemaID, key.subject)
+	}
+}
+
+// do sends a request to the registry and decodes a successful response into
+// out, returning the status code.
+func (r schemaRegistry) do(ctx context.Context, method, path string, body []byte, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
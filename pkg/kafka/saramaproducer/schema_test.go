@@ -0,0 +1,155 @@
+package saramaproducer
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// testSchemaRegistry finds schema 1 compatible with the subject of testTopic
+// and schema 2 not, has nothing registered to check schema 3 against, and
+// records the paths of the requests it receives.
+type testSchemaRegistry struct {
+	*httptest.Server
+
+	lock  sync.Mutex
+	paths []string
+}
+
+func newTestSchemaRegistry(t *testing.T) *testSchemaRegistry {
+	t.Helper()
+
+	r := &testSchemaRegistry{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.lock.Lock()
+		r.paths = append(r.paths, req.URL.Path)
+		r.lock.Unlock()
+
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/schemas/ids/"):
+			_, _ = w.Write([]byte(`{"schema": "\"string\""}`))
+		case r.lastSchemaID() == "3":
+			w.WriteHeader(http.StatusNotFound)
+		case r.lastSchemaID() == "1":
+			_, _ = w.Write([]byte(`{"is_compatible": true}`))
+		default:
+			_, _ = w.Write([]byte(`{"is_compatible": false}`))
+		}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// lastSchemaID returns the ID of the schema last fetched from r.
+func (r *testSchemaRegistry) lastSchemaID() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i := len(r.paths) - 1; i >= 0; i-- {
+		if id, ok := strings.CutPrefix(r.paths[i], "/schemas/ids/"); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+func (r *testSchemaRegistry) requests() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.paths...)
+}
+
+// newSchemaTestMessage returns a message to testTopic with a value framed in
+// the schema registry wire format.
+func newSchemaTestMessage(schemaID uint32) *sarama.ProducerMessage {
+	value := make([]byte, 5, 8)
+	binary.BigEndian.PutUint32(value[1:], schemaID)
+	return &sarama.ProducerMessage{Topic: testTopic, Value: sarama.ByteEncoder(append(value, "foo"...))}
+}
+
+func newSchemaTestSyncProducer(t *testing.T, registryURL string, compatibility CompatibilityLevel) (SyncProducer, *sendRecorder) {
+	t.Helper()
+
+	recorder := &sendRecorder{}
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config, WithSchemaCompatibilityCheck(registryURL, compatibility))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	return producer, recorder
+}
+
+func TestWithSchemaCompatibilityCheck(t *testing.T) {
+	registry := newTestSchemaRegistry(t)
+	producer, recorder := newSchemaTestSyncProducer(t, registry.URL, CompatibilityBackward)
+
+	compatible := newSchemaTestMessage(1)
+	_, _, err := producer.SendMessage(compatible)
+	require.NoError(t, err)
+	require.True(t, recorder.wasSent(compatible))
+
+	incompatible := newSchemaTestMessage(2)
+	_, _, err = producer.SendMessage(incompatible)
+	require.ErrorIs(t, err, ErrSchemaIncompatible)
+	require.False(t, recorder.wasSent(incompatible))
+
+	require.Equal(t, []string{
+		"/schemas/ids/1",
+		"/compatibility/subjects/" + testTopic + "-value/versions/latest",
+		"/schemas/ids/2",
+		"/compatibility/subjects/" + testTopic + "-value/versions/latest",
+	}, registry.requests())
+
+	// results are cached, and values not in the wire format are not checked
+	msgs := []*sarama.ProducerMessage{
+		newSchemaTestMessage(1),
+		newSchemaTestMessage(2),
+		{Topic: testTopic, Value: sarama.StringEncoder("foo")},
+	}
+	err = producer.SendMessages(msgs)
+	var pErrs ProducerErrors
+	require.True(t, errors.As(err, &pErrs))
+	require.Len(t, pErrs, 1)
+	require.Same(t, msgs[1], pErrs[0].Msg)
+	require.ErrorIs(t, pErrs[0].Err, ErrSchemaIncompatible)
+	require.True(t, recorder.wasSent(msgs[0]))
+	require.True(t, recorder.wasSent(msgs[2]))
+	require.Len(t, registry.requests(), 4)
+
+	// a subject with no versions accepts any schema
+	_, _, err = producer.SendMessage(newSchemaTestMessage(3))
+	require.NoError(t, err)
+}
+
+func TestWithSchemaCompatibilityCheck_Transitive(t *testing.T) {
+	registry := newTestSchemaRegistry(t)
+	producer, _ := newSchemaTestSyncProducer(t, registry.URL, CompatibilityFullTransitive)
+
+	_, _, err := producer.SendMessage(newSchemaTestMessage(1))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/schemas/ids/1",
+		"/compatibility/subjects/" + testTopic + "-value/versions",
+	}, registry.requests())
+}
+
+func TestWithSchemaCompatibilityCheck_RegistryUnavailable(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(registry.Close)
+	producer, recorder := newSchemaTestSyncProducer(t, registry.URL, CompatibilityBackward)
+
+	msg := newSchemaTestMessage(1)
+	_, _, err := producer.SendMessage(msg)
+	require.ErrorContains(t, err, "schema registry returned 500")
+	require.NotErrorIs(t, err, ErrSchemaIncompatible)
+	require.False(t, recorder.wasSent(msg))
+}
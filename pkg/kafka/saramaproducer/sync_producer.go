@@ -0,0 +1,2193 @@
+// Package saramaproducer provides a SyncProducer built on the public API of
+// github.com/IBM/sarama. It extends sarama.SyncProducer with per-message
+// options, topic administration and transaction helpers, and comes with
+// decorators adding behaviour such as encryption, failover or deduplication.
+//
+// Methods taking a context to send a message return ctx.Err() if ctx is done
+// before the message is acknowledged. A message handed to a producer cannot be
+// recalled, so it may still be produced in that case and must not be reused.
+//
+// Constructors return an error only when their arguments cannot make a
+// working producer: a sarama.ConfigurationError for invalid arguments, such as
+// an empty producer list, or ErrNotSupported when a decorated producer must be
+// built on this package and is not. Tuning arguments out of range, such as a
+// buffer size or a number of priority levels, are clamped or replaced by their
+// defaults instead. Decorators log to the logger set with WithLogger on the
+// producer they decorate.
+package saramaproducer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	"github.com/rcrowley/go-metrics"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ sarama.SyncProducer = SyncProducer(nil)
+
+var expectationsPool = sync.Pool{
+	New: func() interface{} {
+		return make(chan *ProducerError, 1)
+	},
+}
+
+// SyncProducer publishes Kafka messages, blocking until they have been acknowledged. It routes messages to the correct
+// broker, refreshing metadata as appropriate, and parses responses for errors. You must call Close() on a producer
+// to avoid leaks, it may not be garbage-collected automatically when it passes out of scope.
+//
+// The SyncProducer comes with two caveats: it will generally be less efficient than the AsyncProducer, and the actual
+// durability guarantee provided when a message is acknowledged depend on the configured value of `Producer.RequiredAcks`.
+// There are configurations where a message acknowledged by the SyncProducer can still sometimes be lost.
+//
+// For implementation reasons, the SyncProducer requires `Producer.Return.Errors` and `Producer.Return.Successes` to
+// be set to true in its configuration.
+//
+// A SyncProducer is also a sarama.SyncProducer. It sends messages through
+// sarama AsyncProducers sharing a single sarama.Client.
+type SyncProducer interface {
+
+	// SendMessage produces a given message, and returns only when it either has
+	// succeeded or failed to produce. It will return the partition and the offset
+	// of the produced message, or an error if the message failed to produce.
+	// If it fails with ErrNotLeaderForPartition once the producer's own
+	// retries are exhausted, the topic's metadata is refreshed and the message
+	// is sent once more, without retries, before the error is returned.
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+
+	// SendMessageZeroCopy produces key and value to topic without wrapping
+	// them in a ProducerMessage built by the caller, avoiding per-message
+	// encoder allocations on hot paths. The slices are passed by reference
+	// and must not be modified until the call returns. A non-negative
+	// partition sends the message to that partition, bypassing the
+	// partitioner; a negative one uses the configured partitioner.
+	SendMessageZeroCopy(topic string, partition int32, key, value []byte) (int32, int64, error)
+
+	// SendMessageToPartition produces key and value to the given partition of
+	// topic, bypassing the partitioner, and returns the offset of the
+	// produced message. partition must not be negative. If ctx is done
+	// before the message is acknowledged, ctx.Err() is returned but the
+	// message may still be produced.
+	SendMessageToPartition(ctx context.Context, topic string, partition int32, key, value []byte) (int64, error)
+
+	// SendMessageWithExpiry produces msg with its timestamp backdated so that
+	// time-based retention deletes it roughly ttl from now: the timestamp is
+	// set to now - retention.ms + ttl, with retention.ms read from the
+	// topic's configuration on first use and cached. Deletion remains subject
+	// to segment rolling, so messages can outlive ttl by up to
+	// segment.ms. The topic must use CreateTime timestamps, have a finite
+	// retention.ms no shorter than ttl and accept timestamps that far in the
+	// past under message.timestamp.difference.max.ms.
+	SendMessageWithExpiry(msg *sarama.ProducerMessage, ttl time.Duration) (int32, int64, error)
+
+	// SendMessageWithMetadata produces a given message like SendMessage and
+	// returns the RecordMetadata of the produced record.
+	SendMessageWithMetadata(msg *sarama.ProducerMessage) (RecordMetadata, error)
+
+	// SendMessageWithSchema frames the message value in the Confluent Schema
+	// Registry wire format, a 0x00 magic byte followed by the 4-byte
+	// big-endian schemaID, records schemaVersion in the SchemaVersionHeader
+	// header and produces the message like SendMessage. A nil value is left
+	// nil so that tombstones stay tombstones.
+	SendMessageWithSchema(msg *sarama.ProducerMessage, schemaID int, schemaVersion int) (partition int32, offset int64, err error)
+
+	// SendMessageWithTimestamp sets the record timestamp of msg to ts and then
+	// behaves like SendMessage. A zero ts keeps the default behaviour of
+	// stamping the message with the current time when it is added to a batch.
+	// The timestamp is only honoured by brokers configured with CreateTime.
+	SendMessageWithTimestamp(msg *sarama.ProducerMessage, ts time.Time) (partition int32, offset int64, err error)
+
+	// SendMessageWithCorrelationID sets the x-correlation-id header of msg to
+	// correlationID, injects the span context of ctx as W3C Trace Context
+	// headers, and then behaves like SendMessage. With WithJaegerPropagator,
+	// the OpenTracing span of ctx is injected as well. The correlation ID is
+	// logged together with the resulting partition and offset.
+	SendMessageWithCorrelationID(ctx context.Context, msg *sarama.ProducerMessage, correlationID string) (partition int32, offset int64, err error)
+
+	// SendMessageWithSLA behaves like SendMessage but gives up waiting once
+	// maxLatency has elapsed, returning ErrSLAViolated wrapping
+	// context.DeadlineExceeded. The message cannot be recalled at that point
+	// and may still be produced afterwards, so msg must not be reused.
+	// Violations are logged with the topic, key and elapsed time.
+	SendMessageWithSLA(msg *sarama.ProducerMessage, maxLatency time.Duration) (partition int32, offset int64, err error)
+
+	// SendMessageWithCallback hands msg to the producer and returns without
+	// waiting for it to be acknowledged. onComplete is called exactly once
+	// with the outcome SendMessage would have returned, from the goroutine
+	// that processes the producer's successes or errors, so it must not
+	// block; it is called before SendMessageWithCallback returns if msg is
+	// rejected up front. SendMessageWithCallback itself can still block
+	// while the producer applies back-pressure, e.g. under SetMaxInflight.
+	// Unlike SendMessage, a message failing with ErrNotLeaderForPartition is
+	// not retried.
+	SendMessageWithCallback(msg *sarama.ProducerMessage, onComplete func(partition int32, offset int64, err error))
+
+	// SendMessageWithFallback produces primary like SendMessage and, if that
+	// fails, produces fallback instead, e.g. to a dead letter topic. The
+	// returned bool reports whether the partition and offset are those of
+	// fallback. If both fail, the error is ErrBothFailed wrapping both
+	// errors.
+	SendMessageWithFallback(primary *sarama.ProducerMessage, fallback *sarama.ProducerMessage) (int32, int64, bool, error)
+
+	// SendTombstone produces a record with the given key and a null value,
+	// which deletes the key from a compacted topic. The first tombstone sent
+	// to a topic looks up its cleanup.policy and logs a warning if the topic
+	// is not compacted; the tombstone is sent either way.
+	SendTombstone(ctx context.Context, topic string, key []byte) (partition int32, offset int64, err error)
+
+	// MaxMessageBytes returns the largest message that can be produced to topic,
+	// which is the smaller of Producer.MaxMessageBytes and the topic's
+	// max.message.bytes as reported by the broker. The broker limit is fetched
+	// once per topic and cached for the lifetime of the producer.
+	MaxMessageBytes(topic string) (int, error)
+
+	// ProduceRawBatch writes a pre-encoded v2 RecordBatch to the leader of the
+	// given topic-partition in a single produce request. The partitioner,
+	// interceptors and sequence numbering are all bypassed, so the batch must
+	// already be valid for the target partition. Requires Kafka 0.11 or later
+	// and a non-transactional producer.
+	//
+	// The batch is not written byte for byte: sarama only builds produce
+	// requests from decoded record batches, which it encodes itself. The batch
+	// is therefore decoded, checking its CRC, and encoded again. Its records,
+	// timestamps, attributes, producer ID, epoch and sequence are preserved,
+	// but a compressed batch is recompressed with its codec at the default
+	// level, so the bytes stored by the broker may differ from batch.
+	ProduceRawBatch(topic string, partition int32, batch []byte) error
+
+	// SendMessagesBinary decodes each element of rawMessages as an encoded
+	// legacy (magic 0 or 1) Message and writes them as one message set to the
+	// leader of the given topic-partition in a single produce request, e.g. to
+	// replay messages from compacted storage. It returns the offset assigned
+	// to each raw message, or -1s if RequiredAcks is NoResponse. Like
+	// ProduceRawBatch it bypasses the partitioner and interceptors. It uses
+	// produce request v2 at most, which brokers from Kafka 4.0 on no longer
+	// accept, and requires a non-idempotent producer.
+	SendMessagesBinary(rawMessages [][]byte, topic string, partition int32) ([]int64, error)
+
+	// SendMessageBatch encodes msgs into a single RecordBatch and writes it
+	// to the leader of their partition in one produce request, so that they
+	// are appended together, unlike SendMessages, which may split them
+	// across batches and requests. msgs must all have the same Topic and
+	// Partition, which are used as is: the partitioner is bypassed. The
+	// batch must not exceed Producer.MaxMessageBytes. It returns one result
+	// per message, in order; if the broker rejects the batch, every result
+	// carries the error, which is also returned. Requires Kafka 0.11 or later
+	// and a non-idempotent producer.
+	SendMessageBatch(msgs []*sarama.ProducerMessage) ([]ProducerResult, error)
+
+	// UpdateFlushConfig changes the Producer.Flush.Messages, Frequency and
+	// Bytes trigger points of the running producer. The new values apply to
+	// batches started after the call. The Config the producer was created
+	// with is left untouched. Returns a ConfigurationError for negative
+	// values and ErrNotSupported if the change would require altering a
+	// setting that is fixed for the producer's lifetime.
+	UpdateFlushConfig(messages int, frequency time.Duration, bytes int) error
+
+	// UpdateBrokerList replaces the seed brokers of the underlying client with
+	// addrs and refreshes the cluster metadata from them. Existing broker
+	// connections are closed; messages in flight on them are retried
+	// according to Producer.Retry against the leaders found in the new
+	// metadata. It is safe to call concurrently with sends.
+	UpdateBrokerList(addrs []string) error
+
+	// ListConsumerGroupOffsets returns the offsets committed by groupID for
+	// the given topic-partitions, as reported by the group coordinator.
+	// Partitions without a committed offset are reported as -1. This allows
+	// read-your-writes checks by comparing against offsets returned by
+	// SendMessage.
+	ListConsumerGroupOffsets(groupID string, topics map[string][]int32) (map[string]map[int32]int64, error)
+
+	// HighWatermark returns the offset that will be assigned to the next
+	// message produced to the given topic-partition, as reported by the
+	// partition leader over the client's existing connection. The request
+	// itself cannot be cancelled; if ctx is done first its error is returned.
+	HighWatermark(ctx context.Context, topic string, partition int32) (int64, error)
+
+	// CreateTopic creates topic through the producer's client, as
+	// ClusterAdmin.CreateTopic would, without the need for a separate
+	// ClusterAdmin. If the topic already exists, ErrTopicAlreadyExists is
+	// returned unless detail.IfNotExists is set. The request itself cannot be
+	// cancelled; if ctx is done first its error is returned.
+	CreateTopic(ctx context.Context, topic string, detail TopicDetail) error
+
+	// WatchBrokerHealth probes every broker known to the client with a
+	// lightweight metadata request at the interval set by
+	// WithBrokerHealthInterval (10s by default) and emits an event whenever a
+	// broker becomes available or unavailable, starting with its state on the
+	// first probe. The channel is closed when ctx is done or the producer is
+	// closed, and must be drained to keep probing.
+	WatchBrokerHealth(ctx context.Context) <-chan BrokerHealthEvent
+
+	// DeleteTopic deletes topic through the producer's client, as
+	// ClusterAdmin.DeleteTopic would. It returns ErrTopicNotFound if the topic
+	// does not exist and ErrDeleteTopicNotEnabled if the brokers have
+	// delete.topic.enable=false; both wrap the broker's error. The request
+	// itself cannot be cancelled; if ctx is done first its error is returned.
+	DeleteTopic(ctx context.Context, topic string) error
+
+	// TopicExists reports whether topic exists according to the client's
+	// metadata. A topic missing from the cache is looked up by refreshing
+	// the metadata of all topics, which unlike a refresh of the topic alone
+	// never triggers its automatic creation. The refresh itself cannot be
+	// cancelled; if ctx is done first its error is returned.
+	TopicExists(ctx context.Context, topic string) (bool, error)
+
+	// IncrementalAlterConfig applies changes to the configuration of topic
+	// with the IncrementalAlterConfigs API, leaving settings that are not
+	// named in changes untouched. It requires Version to be at least
+	// V2_3_0_0. The request itself cannot be cancelled; if ctx is done first
+	// its error is returned.
+	IncrementalAlterConfig(ctx context.Context, topic string, changes []TopicConfigChange) error
+
+	// SetClientQuota sets the producer_byte_rate quota of the producer's
+	// ClientID to quotaBytes bytes per second with the AlterClientQuotas
+	// API. The quota applies to every client sharing the ID. It returns
+	// ErrAdminAPINotSupported if Version or the brokers are older than
+	// V2_6_0_0. The request itself cannot be cancelled; if ctx is done first
+	// its error is returned.
+	SetClientQuota(ctx context.Context, quotaBytes int64) error
+
+	// Preconnect refreshes the metadata of the given topics and opens the
+	// connections to the leaders of their partitions, so that the first
+	// messages sent to them do not wait for either. All partitions of each
+	// topic in topics are covered; for the topics in partitions, only the
+	// listed ones. It returns once every connection is established or has
+	// failed, or with ctx's error if ctx is done first.
+	Preconnect(ctx context.Context, topics []string, partitions map[string][]int32) error
+
+	// TopicLag returns, for every partition of topic, how many messages
+	// groupID has yet to consume: the high watermark minus the committed
+	// offset. For partitions without a committed offset the lag is counted
+	// from the oldest available offset. The requests cannot be cancelled; if
+	// ctx is done first its error is returned.
+	TopicLag(ctx context.Context, topic string, groupID string) (map[int32]int64, error)
+
+	// ListPartitionOffsets returns the oldest available offset and the high
+	// watermark of every partition of topic, as reported by the partition
+	// leaders. The requests cannot be cancelled; if ctx is done first its
+	// error is returned.
+	ListPartitionOffsets(ctx context.Context, topic string) (map[int32]PartitionOffsets, error)
+
+	// BrokerFor returns the broker currently leading the given
+	// topic-partition according to the client's metadata cache, refreshing
+	// the metadata if no leader is cached. It returns ErrNoBrokerForPartition
+	// if the partition has no leader. The broker is shared with the producer
+	// and must not be closed.
+	BrokerFor(topic string, partition int32) (*sarama.Broker, error)
+
+	// SetTopicConfig overrides producer settings for messages sent to topic
+	// from now on. Messages for topics with different RequiredAcks are never
+	// batched into the same produce request.
+	SetTopicConfig(topic string, overrides TopicProducerConfig) error
+
+	// Barrier sends a sentinel message, with no key or value and a
+	// BarrierHeader, to every topic-partition this producer has had a
+	// message acknowledged on, waits for all of them to be acknowledged and
+	// returns their offsets by topic and partition. As messages to a
+	// partition are acknowledged in order, every message sent to it before
+	// Barrier was called is acknowledged at an offset lower than the
+	// sentinel's, provided Producer.Idempotent is enabled or
+	// Net.MaxOpenRequests is 1. Consumers should skip sentinels. The sends
+	// cannot be cancelled; if ctx is done first its error is returned.
+	Barrier(ctx context.Context) (barrierOffset map[string]map[int32]int64, err error)
+
+	// DrainTopic blocks until every message sent to topic through this
+	// producer has been acknowledged or has failed, e.g. to flush a topic when
+	// a pod receives SIGTERM. Sends to topic that start while the drain is in
+	// progress block until it completes. If ctx is done first, the drain is
+	// abandoned, blocked sends resume and ctx.Err() is returned.
+	DrainTopic(ctx context.Context, topic string) error
+
+	// FlushPartition blocks until every message sent to topic that is in
+	// flight when it is called has been acknowledged or has failed, and
+	// returns the highest offset acknowledged on partition by this producer,
+	// or -1 if there is none. Since a message's partition is only chosen
+	// once it has been handed over, messages for the topic's other
+	// partitions are waited for too. Unlike DrainTopic, new sends are not
+	// blocked. If ctx is done first, ctx.Err() is returned.
+	FlushPartition(ctx context.Context, topic string, partition int32) (int64, error)
+
+	// CloseTopicProducer stops production to topic while leaving other
+	// topics untouched. Messages sent to topic afterwards fail with
+	// ErrTopicClosed; CloseTopicProducer blocks until the messages already
+	// in flight to it have been acknowledged or have failed, then forgets
+	// the state the producer keeps for the topic, such as its acknowledged
+	// offsets, size limits, overrides and partitioner. A closed topic cannot be reopened. Closing a
+	// topic twice is a no-op.
+	CloseTopicProducer(topic string) error
+
+	// ConfigSnapshot returns a deep copy of the configuration the producer is
+	// running with. Settings changed at runtime, such as the flush settings
+	// set by UpdateFlushConfig, are reported with their current values.
+	// Interfaces and funcs (partitioner, SASL token provider, metric registry,
+	// ...) cannot be copied and are shared with the producer. Per-topic
+	// overrides from SetTopicConfig are not part of Config and not included.
+	ConfigSnapshot() sarama.Config
+
+	// SetTopicPartitioner replaces the partitioner used for messages to topic
+	// that have not been partitioned yet, leaving other topics untouched. A
+	// nil partitioner restores Producer.Partitioner. Changing the partitioner
+	// may route a key to a different partition, breaking per-key ordering
+	// across the switch. With WithPreferLocalRack, partitioner still only
+	// places the messages that need a consistent partition.
+	SetTopicPartitioner(topic string, partitioner sarama.PartitionerConstructor) error
+
+	// SetHeaderInterceptor makes fn compute the headers of every message sent
+	// from now on: before a message is handed to the producer, its headers
+	// are replaced by fn(msg.Topic, msg.Headers). This centralizes headers
+	// such as auth tokens or schema versions instead of having every caller
+	// add them. fn must be safe for concurrent use; a nil fn removes the
+	// interceptor.
+	SetHeaderInterceptor(fn func(topic string, existing []sarama.RecordHeader) []sarama.RecordHeader)
+
+	// AddHeadersGlobally registers headers to be added to every message sent
+	// from now on, e.g. by a service mesh sidecar. A global header is skipped
+	// when the message already carries a header with the same key, so headers
+	// set by the caller win; registering a key again replaces its value. The
+	// headers are added before the interceptor set with SetHeaderInterceptor
+	// runs.
+	AddHeadersGlobally(headers ...sarama.RecordHeader)
+
+	// RemoveGlobalHeader unregisters the global header with the given key, if
+	// any.
+	RemoveGlobalHeader(key string)
+
+	// SetTopicValueSizeLimit makes SendMessage, SendMessages and the methods
+	// built on them reject messages to topic whose value is longer than
+	// maxBytes with ErrMessageTooLarge, before any network IO takes place,
+	// e.g. to protect downstream consumers with smaller buffers. A maxBytes
+	// of 0 removes the limit.
+	SetTopicValueSizeLimit(topic string, maxBytes int)
+
+	// LocalBufferSize returns the number of messages that have been handed to
+	// the producer but not yet acknowledged or failed. Callers can check it
+	// before sending to apply backpressure of their own.
+	LocalBufferSize() int
+
+	// SetMaxInflight limits the number of messages handed to the producer
+	// but not yet acknowledged or failed to n; further sends block until a
+	// message is resolved. Zero removes the limit. It returns
+	// ErrCannotReduce if more than n messages are already in flight. This
+	// limit applies on top of Net.MaxOpenRequests, which still bounds the
+	// number of produce requests per broker connection.
+	SetMaxInflight(n int) error
+
+	// SendMessages produces a given set of messages, and returns only when all
+	// messages in the set have either succeeded or failed. Note that messages
+	// can succeed and fail individually; if some succeed and some fail,
+	// SendMessages will return an error. Messages failing with
+	// ErrNotLeaderForPartition are sent once more, as with SendMessage.
+	SendMessages(msgs []*sarama.ProducerMessage) error
+
+	// SendMessagesWithPartialRetry sends msgs like SendMessages, then resends
+	// only the messages that failed with an error accepted by
+	// retryPolicy.ShouldRetry, until all succeed or retryPolicy.MaxAttempts
+	// attempts have been made. The returned results are in input order and
+	// reflect each message's final attempt; the error is a ProducerErrors for
+	// the messages that still failed.
+	SendMessagesWithPartialRetry(msgs []*sarama.ProducerMessage, retryPolicy RetryPolicy) (ProducerResults, error)
+
+	// SendMessagesSequential produces msgs one at a time, in slice order,
+	// waiting for each message to be acknowledged before handing over the
+	// next. It stops at the first failure so that no later message can
+	// overtake it, returning a ProducerErrors holding only that failure;
+	// the messages after it are not sent. This is much slower than
+	// SendMessages, which sends all messages concurrently.
+	SendMessagesSequential(msgs []*sarama.ProducerMessage) error
+
+	// SendMessagesBatched reads messages from msgs and produces them with
+	// SendMessages in batches of up to batchSize messages, sending a partial
+	// batch once maxDelay has passed since its first message arrived (zero
+	// disables the delay-based flush). It returns when msgs is closed and the
+	// last batch has been sent, or when ctx is done, in which case messages
+	// not yet handed to SendMessages are dropped. Failures do not stop the
+	// stream; all ProducerErrors are collected and returned together, joined
+	// with ctx.Err() if ctx is done.
+	SendMessagesBatched(ctx context.Context, msgs <-chan *sarama.ProducerMessage, batchSize int, maxDelay time.Duration) error
+
+	// Close shuts down the producer; you must call this function before a producer
+	// object passes out of scope, as it may otherwise leak memory.
+	// You must call this before calling Close on the underlying client.
+	// Messages that fail while the producer is shutting down are still
+	// returned to their senders, and are additionally reported in a
+	// CloseError. Calling Close again returns the result of the first call.
+	Close() error
+
+	// TxnStatus return current producer transaction status.
+	TxnStatus() sarama.ProducerTxnStatusFlag
+
+	// IsTransactional return true when current producer is transactional.
+	IsTransactional() bool
+
+	// TransactionalID returns Producer.Transaction.ID for a transactional
+	// producer, and an empty string otherwise.
+	TransactionalID() string
+
+	// BeginTxn mark current transaction as ready. It returns
+	// ErrTxnAlreadyStarted if a transaction is already in progress.
+	BeginTxn() error
+
+	// BeginTxnWithTimeout begins a transaction like BeginTxn and aborts it
+	// if ctx is done before CommitTxn or AbortTxn is called. The next
+	// transaction method called after such an abort returns ctx's error.
+	BeginTxnWithTimeout(ctx context.Context) error
+
+	// StartTransaction begins a transaction like BeginTxnWithTimeout and
+	// returns a handle to it, allowing a deferred Abort to clean up after
+	// any early return.
+	StartTransaction(ctx context.Context) (*Transaction, error)
+
+	// CommitTxn commit current transaction.
+	CommitTxn() error
+
+	// AbortTxn abort current transaction.
+	AbortTxn() error
+
+	// AbortTxnWithReason aborts the current transaction and then, outside of
+	// it, produces a JSON record {"txn_id", "reason", "timestamp"} to
+	// reasonTopic for auditing. The record is sent by a non-transactional
+	// producer sharing this producer's client, created on first use. If the
+	// abort fails, nothing is recorded.
+	AbortTxnWithReason(ctx context.Context, reason string, reasonTopic string) error
+
+	// AddOffsetsToTxn add associated offsets to current transaction.
+	AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error
+
+	// AddMessageToTxn add message offsets to current transaction.
+	AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error
+
+	// ConsumeAndProduce atomically produces output and marks input as
+	// consumed by groupID, in a transaction of its own: BeginTxn,
+	// SendMessage(output), AddMessageToTxn(input, groupID, nil), CommitTxn. If
+	// any step fails, or ctx is done between steps, the transaction is
+	// aborted and the error returned.
+	ConsumeAndProduce(ctx context.Context, input *sarama.ConsumerMessage, output *sarama.ProducerMessage, groupID string) error
+
+	// SendMessageAndConsume produces msg like SendMessage, then consumes the
+	// record back from its partition and offset with a consumer sharing this
+	// producer's client but configured by consumerConfig, or by the client's
+	// configuration if it is nil. It returns ErrMessageNotConsumed if the
+	// record cannot be read within Net.ReadTimeout, e.g. because it belongs to
+	// an uncommitted transaction and consumerConfig reads committed records
+	// only. It is meant for integration and smoke tests, not production
+	// paths.
+	SendMessageAndConsume(msg *sarama.ProducerMessage, consumerConfig *sarama.Config) (int32, int64, *sarama.ConsumerMessage, error)
+
+	// MigrateMessages copies fromTopic to toTopic, e.g. to rename a topic or
+	// change the format of its messages. Every partition of fromTopic is
+	// consumed from its oldest offset up to the high watermark it had when
+	// the partition was reached; each record is passed to transform and the
+	// message it returns is produced to toTopic, whatever its Topic field
+	// says, with SendMessages. A nil message skips the record, and a nil
+	// transform copies key, value, headers and timestamp unchanged. Messages
+	// are partitioned anew in toTopic. It returns the number of messages
+	// produced, and stops at the first error from consuming, transform or
+	// producing, or when ctx is done; the messages produced so far are kept
+	// and counted.
+	MigrateMessages(ctx context.Context, fromTopic, toTopic string, transform func(*sarama.ConsumerMessage) (*sarama.ProducerMessage, error)) (int64, error)
+}
+
+type syncProducer struct {
+	// pending is accessed atomically so must be the first word in the struct
+	pending int64
+
+	client sarama.Client
+	conf   *sarama.Config
+	// ownClient is set when the producer created client and must close it
+	ownClient bool
+	logger    log.Logger
+
+	// producers holds an async producer per combination of RequiredAcks and
+	// codec in use. Sends hold producersLock for reading while handing a
+	// message over; UpdateFlushConfig and Close hold it for writing while
+	// they replace or shut down the producers.
+	producersLock sync.RWMutex
+	producers     map[producerKey]*pooledProducer
+	flush         flushSettings
+	closed        bool
+
+	flightsLock sync.Mutex
+	flights     map[*sarama.ProducerMessage]*flight
+
+	// partitioner replaces Producer.Partitioner if set, see WithKeyHasher
+	partitioner  sarama.PartitionerConstructor
+	localRack    string
+	topicsConfig sync.RWMutex
+	topicConfigs map[string]TopicProducerConfig
+	// topicPartitioners holds the partitioner instance of every topic a
+	// message has been partitioned for, shared by all async producers
+	topicPartitioners map[string]*lockedPartitioner
+
+	wg      sync.WaitGroup
+	closing chan struct{}
+	// closeOnce runs the shutdown of Close once, closeErr is its result
+	closeOnce sync.Once
+	closeErr  error
+
+	shutdownErrorsLock sync.Mutex
+	shutdownErrors     ProducerErrors
+
+	abortReasonsLock sync.Mutex
+	abortReasons     *syncProducer
+
+	txnDeadlineLock sync.Mutex
+	txnDeadlineStop chan struct{}
+	txnExpired      error
+
+	topicsLock   sync.Mutex
+	topicPending map[string]int
+	topicDrains  map[string]*topicDrain
+	// closedTopics holds the topics closed by CloseTopicProducer
+	closedTopics map[string]struct{}
+	// activePartitions holds the highest offset acknowledged on every
+	// partition a message has been acknowledged on, for Barrier and
+	// FlushPartition
+	activePartitions map[string]map[int32]int64
+	// topicInflight holds the messages counted in topicPending
+	topicInflight map[string]map[*sarama.ProducerMessage]struct{}
+	// inflight counts the messages between input and resolved; input waits
+	// on inflightCond while it is at maxInflight, unless that is zero
+	inflight     int
+	maxInflight  int
+	inflightCond *sync.Cond
+
+	registerGlobal bool
+
+	schemaCheck   *schemaCompatibilityChecker
+	keyNormalizer func(topic string, key []byte) []byte
+
+	headerInterceptorLock sync.RWMutex
+	headerInterceptor     func(topic string, existing []sarama.RecordHeader) []sarama.RecordHeader
+
+	globalHeadersLock sync.RWMutex
+	globalHeaders     []sarama.RecordHeader
+
+	valueSizeLimits sync.Map // topic -> int
+
+	tracer       trace.Tracer
+	spanName     func(*sarama.ProducerMessage) string
+	jaegerTracer opentracing.Tracer
+
+	brokerHealthInterval time.Duration
+
+	partitionChangeInterval time.Duration
+	partitionChangeHandler  PartitionChangeHandler
+
+	maxMessageBytesLock sync.Mutex
+	maxMessageBytes     map[string]int
+
+	// compactionChecked holds the topics whose cleanup.policy has been
+	// checked by SendTombstone
+	compactionChecked sync.Map
+	// retentions caches the retention.ms of topics for SendMessageWithExpiry
+	retentions sync.Map // topic -> time.Duration
+
+	// recordErrors is the record-error-rate meter of the client registry,
+	// registered once and unregistered by Close
+	recordErrors metrics.Meter
+}
+
+// flight tracks a message from when it is handed to an async producer until
+// its outcome is known. Exactly one of expectation and callback is set.
+type flight struct {
+	expectation chan *ProducerError
+	callback    func(partition int32, offset int64, err error)
+	opts        messageOptions
+}
+
+// SyncProducerOption lets you enable optional behaviour of a SyncProducer
+// created with NewSyncProducer or NewSyncProducerFromClient.
+type SyncProducerOption func(*syncProducer)
+
+// WithLogger sets the logger the producer reports problems to that do not
+// fail a call, such as failed metadata refreshes. Nothing is logged by
+// default.
+func WithLogger(logger log.Logger) SyncProducerOption {
+	return func(sp *syncProducer) {
+		sp.logger = logger
+	}
+}
+
+// NewSyncProducer creates a new SyncProducer using the given broker addresses and configuration.
+func NewSyncProducer(addrs []string, config *sarama.Config, opts ...SyncProducerOption) (SyncProducer, error) {
+	if config == nil {
+		config = sarama.NewConfig()
+		config.Producer.Return.Successes = true
+	}
+
+	if err := verifyProducerConfig(config); err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewClient(addrs, config)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := newSyncProducer(client, true, opts...)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return sp, nil
+}
+
+// NewSyncProducerWithResolvedAddrs creates a new SyncProducer bootstrapping
+// from the given IP:port pairs, so that no DNS lookup is needed to connect,
+// e.g. behind a service mesh sidecar doing its own load balancing. Brokers
+// discovered through metadata are still dialled at their advertised
+// addresses. When TLS is enabled, set Net.TLS.Config.ServerName so that the
+// brokers' certificates can be verified without a hostname.
+func NewSyncProducerWithResolvedAddrs(addrs []net.TCPAddr, config *sarama.Config, opts ...SyncProducerOption) (SyncProducer, error) {
+	if len(addrs) == 0 {
+		return nil, sarama.ConfigurationError("at least one broker address is required")
+	}
+	if config != nil && config.Net.ResolveCanonicalBootstrapServers {
+		return nil, sarama.ConfigurationError("Net.ResolveCanonicalBootstrapServers must be false to use pre-resolved addresses")
+	}
+
+	hostports := make([]string, len(addrs))
+	for i, addr := range addrs {
+		if addr.IP == nil {
+			return nil, sarama.ConfigurationError(fmt.Sprintf("broker address %d has no IP", i))
+		}
+		hostports[i] = addr.String()
+	}
+	return NewSyncProducer(hostports, config, opts...)
+}
+
+// NewSyncProducerFromClient creates a new SyncProducer using the given client. It is still
+// necessary to call Close() on the underlying client when shutting down this producer.
+func NewSyncProducerFromClient(client sarama.Client, opts ...SyncProducerOption) (SyncProducer, error) {
+	if err := verifyProducerConfig(client.Config()); err != nil {
+		return nil, err
+	}
+	return newSyncProducer(client, false, opts...)
+}
+
+func newSyncProducer(client sarama.Client, ownClient bool, opts ...SyncProducerOption) (*syncProducer, error) {
+	conf := client.Config()
+	sp := &syncProducer{
+		client:    client,
+		conf:      conf,
+		ownClient: ownClient,
+		logger:    log.NewNopLogger(),
+		producers: make(map[producerKey]*pooledProducer),
+		flush: flushSettings{
+			bytes:     conf.Producer.Flush.Bytes,
+			messages:  conf.Producer.Flush.Messages,
+			frequency: conf.Producer.Flush.Frequency,
+		},
+		flights:           make(map[*sarama.ProducerMessage]*flight),
+		topicConfigs:      make(map[string]TopicProducerConfig),
+		topicPartitioners: make(map[string]*lockedPartitioner),
+		closing:           make(chan struct{}),
+		topicPending:      make(map[string]int),
+		topicDrains:       make(map[string]*topicDrain),
+		closedTopics:      make(map[string]struct{}),
+		activePartitions:  make(map[string]map[int32]int64),
+		topicInflight:     make(map[string]map[*sarama.ProducerMessage]struct{}),
+		maxMessageBytes:   make(map[string]int),
+	}
+	sp.inflightCond = sync.NewCond(&sp.topicsLock)
+	for _, opt := range opts {
+		opt(sp)
+	}
+	if sp.localRack != "" {
+		sp.client = &metadataClient{Client: client}
+	}
+	sp.recordErrors = metrics.GetOrRegisterMeter(recordErrorRateMetric, conf.MetricRegistry)
+
+	// the default producer is created up front, so that configuration errors
+	// surface here and transactions have a producer to run on
+	if err := sp.addProducer(sp.defaultKey()); err != nil {
+		conf.MetricRegistry.Unregister(recordErrorRateMetric)
+		return nil, err
+	}
+
+	if sp.partitionChangeHandler != nil {
+		sp.wg.Add(1)
+		go sp.watchPartitionCounts()
+	}
+	if sp.registerGlobal {
+		registerProducer(sp)
+	}
+
+	return sp, nil
+}
+
+func verifyProducerConfig(config *sarama.Config) error {
+	if !config.Producer.Return.Errors {
+		return sarama.ConfigurationError("Producer.Return.Errors must be true to be used in a SyncProducer")
+	}
+	if !config.Producer.Return.Successes {
+		return sarama.ConfigurationError("Producer.Return.Successes must be true to be used in a SyncProducer")
+	}
+	return nil
+}
+
+// prepare applies the options that rewrite or reject messages before they
+// are handed to the async producer.
+func (sp *syncProducer) prepare(msg *sarama.ProducerMessage, opts messageOptions) error {
+	sp.topicsLock.Lock()
+	_, closed := sp.closedTopics[msg.Topic]
+	sp.topicsLock.Unlock()
+	if closed {
+		return ErrTopicClosed
+	}
+
+	sp.globalHeadersLock.RLock()
+	for _, h := range sp.globalHeaders {
+		if !hasHeader(msg.Headers, h.Key) {
+			msg.Headers = append(msg.Headers, h)
+		}
+	}
+	sp.globalHeadersLock.RUnlock()
+
+	sp.headerInterceptorLock.RLock()
+	interceptor := sp.headerInterceptor
+	sp.headerInterceptorLock.RUnlock()
+	if interceptor != nil {
+		msg.Headers = interceptor(msg.Topic, msg.Headers)
+	}
+	if limit, ok := sp.valueSizeLimits.Load(msg.Topic); ok && msg.Value != nil && msg.Value.Length() > limit.(int) {
+		return fmt.Errorf("%w: value of %d bytes exceeds the limit of %d bytes for topic %s", sarama.ErrMessageTooLarge, msg.Value.Length(), limit, msg.Topic)
+	}
+	if sp.keyNormalizer != nil {
+		if err := sp.normalizeKey(msg); err != nil {
+			return err
+		}
+	}
+	if sp.schemaCheck != nil {
+		if err := sp.schemaCheck.check(msg); err != nil {
+			return err
+		}
+	}
+	if opts.encryptHeaders != nil {
+		headers, err := opts.encryptHeaders(msg.Headers)
+		if err != nil {
+			return err
+		}
+		msg.Headers = headers
+	}
+	return nil
+}
+
+func (sp *syncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	opts := takeMessageOptions(msg)
+	if opts.callback != nil {
+		// sent with SendMessageWithCallback through decorators, which do not
+		// need the outcome
+		opts.callback.taken = true
+		sp.sendMessageWithCallback(msg, opts.callback.onComplete, opts)
+		return -1, -1, nil
+	}
+	if err := sp.prepare(msg, opts); err != nil {
+		return -1, -1, err
+	}
+	if finish := sp.traceMessage(opts.ctx, msg); finish != nil {
+		defer func() { finish(err) }()
+	}
+
+	pErr := sp.produce(msg, opts)
+	if pErr != nil && errors.Is(pErr.Err, sarama.ErrNotLeaderForPartition) && !sp.IsTransactional() {
+		// the leader moved too recently for the producer's retries to catch
+		// up; a transaction has already failed, so only retry outside one.
+		// The async producer has reset the message, including its retry
+		// count, so it is resent by one that makes a single attempt.
+		if err := sp.client.RefreshMetadata(msg.Topic); err != nil {
+			level.Warn(sp.logger).Log("msg", "failed to refresh metadata after a leader change", "topic", msg.Topic, "err", err)
+		}
+		opts.singleAttempt = true
+		pErr = sp.produce(msg, opts)
+	}
+	if pErr != nil {
+		return -1, -1, pErr.Err
+	}
+
+	return msg.Partition, msg.Offset, nil
+}
+
+// produce hands msg to an async producer and waits for the outcome, or until
+// opts.sendCtx is done.
+func (sp *syncProducer) produce(msg *sarama.ProducerMessage, opts messageOptions) *ProducerError {
+	expectation := expectationsPool.Get().(chan *ProducerError)
+	sp.input(msg, &flight{expectation: expectation, opts: opts})
+	if opts.sendCtx == nil {
+		pErr := <-expectation
+		expectationsPool.Put(expectation)
+		return pErr
+	}
+	select {
+	case pErr := <-expectation:
+		expectationsPool.Put(expectation)
+		return pErr
+	case <-opts.sendCtx.Done():
+		// the flight still delivers its outcome to the buffered expectation,
+		// which is left to the garbage collector rather than reused
+		return &ProducerError{Msg: msg, Err: opts.sendCtx.Err()}
+	}
+}
+
+func (sp *syncProducer) SendMessageZeroCopy(topic string, partition int32, key, value []byte) (int32, int64, error) {
+	return sendMessageZeroCopy(sp, topic, partition, key, value)
+}
+
+// sendMessageZeroCopy implements SendMessageZeroCopy on top of p.SendMessage.
+func sendMessageZeroCopy(p SyncProducer, topic string, partition int32, key, value []byte) (int32, int64, error) {
+	msg := &sarama.ProducerMessage{Topic: topic}
+	if key != nil {
+		msg.Key = noCopyEncoder(key)
+	}
+	if value != nil {
+		msg.Value = noCopyEncoder(value)
+	}
+	if partition >= 0 {
+		setManualPartition(msg, partition)
+		defer takeMessageOptions(msg)
+	}
+	return p.SendMessage(msg)
+}
+
+func (sp *syncProducer) SendMessageToPartition(ctx context.Context, topic string, partition int32, key, value []byte) (int64, error) {
+	return sendMessageToPartition(ctx, sp, topic, partition, key, value)
+}
+
+// sendMessageToPartition implements SendMessageToPartition on top of
+// p.SendMessage.
+func sendMessageToPartition(ctx context.Context, p SyncProducer, topic string, partition int32, key, value []byte) (int64, error) {
+	if partition < 0 {
+		return -1, sarama.ConfigurationError(fmt.Sprintf("invalid partition %d for SendMessageToPartition", partition))
+	}
+	msg := &sarama.ProducerMessage{Topic: topic}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	if value != nil {
+		msg.Value = sarama.ByteEncoder(value)
+	}
+	setManualPartition(msg, partition)
+	_, offset, err := sendMessageWithContext(ctx, p, msg)
+	if err != nil {
+		return -1, err
+	}
+	return offset, nil
+}
+
+// SchemaVersionHeader is the record header in which
+// SyncProducer.SendMessageWithSchema stores the schema version.
+const SchemaVersionHeader = "x-schema-version"
+
+func (sp *syncProducer) SendMessageWithSchema(msg *sarama.ProducerMessage, schemaID int, schemaVersion int) (partition int32, offset int64, err error) {
+	return sendMessageWithSchema(sp, msg, schemaID, schemaVersion)
+}
+
+// sendMessageWithSchema implements SendMessageWithSchema on top of
+// p.SendMessage.
+func sendMessageWithSchema(p SyncProducer, msg *sarama.ProducerMessage, schemaID int, schemaVersion int) (partition int32, offset int64, err error) {
+	if schemaID < 0 || schemaID > math.MaxInt32 {
+		return -1, -1, sarama.ConfigurationError(fmt.Sprintf("schema ID %d does not fit the wire format", schemaID))
+	}
+
+	if msg.Value != nil {
+		value, err := msg.Value.Encode()
+		if err != nil {
+			return -1, -1, err
+		}
+		framed := make([]byte, 5+len(value))
+		binary.BigEndian.PutUint32(framed[1:], uint32(schemaID))
+		copy(framed[5:], value)
+		msg.Value = sarama.ByteEncoder(framed)
+	}
+
+	version := []byte(strconv.Itoa(schemaVersion))
+	for i, h := range msg.Headers {
+		if string(h.Key) == SchemaVersionHeader {
+			msg.Headers[i].Value = version
+			return p.SendMessage(msg)
+		}
+	}
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(SchemaVersionHeader), Value: version})
+	return p.SendMessage(msg)
+}
+
+// RecordMetadata describes a record produced by
+// SyncProducer.SendMessageWithMetadata, mirroring the Java client's class of
+// the same name.
+type RecordMetadata struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	// Timestamp is the record's CreateTime, or its LogAppendTime if the topic
+	// is configured with message.timestamp.type=LogAppendTime.
+	Timestamp time.Time
+	// SerializedKeySize and SerializedValueSize are the lengths of the
+	// encoded key and value, or -1 if they are nil.
+	SerializedKeySize   int
+	SerializedValueSize int
+}
+
+func (sp *syncProducer) SendMessageWithMetadata(msg *sarama.ProducerMessage) (RecordMetadata, error) {
+	return sendMessageWithMetadata(sp, msg)
+}
+
+// sendMessageWithMetadata implements SendMessageWithMetadata on top of
+// p.SendMessage.
+func sendMessageWithMetadata(p SyncProducer, msg *sarama.ProducerMessage) (RecordMetadata, error) {
+	// assign the CreateTime here, as the one picked by the produce set is not
+	// reported back on the message
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now().Truncate(time.Millisecond)
+	}
+
+	partition, offset, err := p.SendMessage(msg)
+	if err != nil {
+		return RecordMetadata{}, err
+	}
+
+	metadata := RecordMetadata{
+		Topic:               msg.Topic,
+		Partition:           partition,
+		Offset:              offset,
+		Timestamp:           msg.Timestamp,
+		SerializedKeySize:   -1,
+		SerializedValueSize: -1,
+	}
+	if msg.Key != nil {
+		metadata.SerializedKeySize = msg.Key.Length()
+	}
+	if msg.Value != nil {
+		metadata.SerializedValueSize = msg.Value.Length()
+	}
+	return metadata, nil
+}
+
+func (sp *syncProducer) SendMessageWithTimestamp(msg *sarama.ProducerMessage, ts time.Time) (partition int32, offset int64, err error) {
+	msg.Timestamp = ts
+	return sp.SendMessage(msg)
+}
+
+func (sp *syncProducer) SendMessageWithCallback(msg *sarama.ProducerMessage, onComplete func(partition int32, offset int64, err error)) {
+	sp.sendMessageWithCallback(msg, onComplete, takeMessageOptions(msg))
+}
+
+// sendMessageWithCallback hands msg to an async producer, with onComplete
+// called from the goroutine resolving its flight.
+func (sp *syncProducer) sendMessageWithCallback(msg *sarama.ProducerMessage, onComplete func(partition int32, offset int64, err error), opts messageOptions) {
+	if err := sp.prepare(msg, opts); err != nil {
+		onComplete(-1, -1, err)
+		return
+	}
+	if finish := sp.traceMessage(opts.ctx, msg); finish != nil {
+		done := onComplete
+		onComplete = func(partition int32, offset int64, err error) {
+			finish(err)
+			done(partition, offset, err)
+		}
+	}
+
+	sp.input(msg, &flight{callback: onComplete, opts: opts})
+}
+
+func (sp *syncProducer) SendMessageWithFallback(primary *sarama.ProducerMessage, fallback *sarama.ProducerMessage) (int32, int64, bool, error) {
+	return sendMessageWithFallback(sp, primary, fallback, sp.logger)
+}
+
+// sendMessageWithFallback implements SendMessageWithFallback on top of
+// p.SendMessage.
+func sendMessageWithFallback(p SyncProducer, primary *sarama.ProducerMessage, fallback *sarama.ProducerMessage, logger log.Logger) (int32, int64, bool, error) {
+	partition, offset, err := p.SendMessage(primary)
+	if err == nil {
+		return partition, offset, false, nil
+	}
+	level.Warn(logger).Log("msg", "failed to produce message, sending fallback message", "topic", primary.Topic, "fallback_topic", fallback.Topic, "err", err)
+
+	partition, offset, fallbackErr := p.SendMessage(fallback)
+	if fallbackErr != nil {
+		return -1, -1, true, sarama.Wrap(ErrBothFailed, err, fallbackErr)
+	}
+	return partition, offset, true, nil
+}
+
+func (sp *syncProducer) SendMessageWithSLA(msg *sarama.ProducerMessage, maxLatency time.Duration) (partition int32, offset int64, err error) {
+	return sendMessageWithSLA(sp, msg, maxLatency, sp.logger)
+}
+
+// sendMessageWithSLA implements SendMessageWithSLA on top of p.SendMessage.
+func sendMessageWithSLA(p SyncProducer, msg *sarama.ProducerMessage, maxLatency time.Duration, logger log.Logger) (partition int32, offset int64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), maxLatency)
+	defer cancel()
+
+	type result struct {
+		partition int32
+		offset    int64
+		err       error
+	}
+	start := time.Now()
+	done := make(chan result, 1)
+	go func() {
+		partition, offset, err := p.SendMessage(msg)
+		done <- result{partition: partition, offset: offset, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.partition, res.offset, res.err
+	case <-ctx.Done():
+		level.Warn(logger).Log("msg", "produce SLA violated", "sla", maxLatency, "topic", msg.Topic, "key", keyBytes(msg), "elapsed", time.Since(start))
+		return -1, -1, sarama.Wrap(ErrSLAViolated, ctx.Err())
+	}
+}
+
+func (sp *syncProducer) SendTombstone(ctx context.Context, topic string, key []byte) (partition int32, offset int64, err error) {
+	return sp.sendTombstone(ctx, sp, topic, key)
+}
+
+// sendTombstone implements SendTombstone, sending the tombstone with
+// p.SendMessage.
+func (sp *syncProducer) sendTombstone(ctx context.Context, p SyncProducer, topic string, key []byte) (partition int32, offset int64, err error) {
+	if key == nil {
+		return -1, -1, sarama.ConfigurationError("a tombstone requires a non-nil key")
+	}
+	if _, checked := sp.compactionChecked.LoadOrStore(topic, struct{}{}); !checked {
+		sp.warnIfNotCompacted(topic)
+	}
+	return sendMessageWithContext(ctx, p, &sarama.ProducerMessage{Topic: topic, Key: sarama.ByteEncoder(key)})
+}
+
+func (sp *syncProducer) warnIfNotCompacted(topic string) {
+	configs, err := sp.describeTopicConfig(topic, "cleanup.policy")
+	if err != nil {
+		level.Warn(sp.logger).Log("msg", "unable to check cleanup.policy of topic", "topic", topic, "err", err)
+		return
+	}
+	for _, policy := range strings.Split(configs["cleanup.policy"], ",") {
+		if strings.TrimSpace(policy) == "compact" {
+			return
+		}
+	}
+	level.Warn(sp.logger).Log("msg", "topic is not compacted, tombstones will not delete keys", "topic", topic, "cleanup_policy", configs["cleanup.policy"])
+}
+
+func (sp *syncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	opts := make([]messageOptions, len(msgs))
+	for i, msg := range msgs {
+		opts[i] = takeMessageOptions(msg)
+	}
+
+	var rejected ProducerErrors
+	for i, msg := range msgs {
+		if err := sp.prepare(msg, opts[i]); err != nil {
+			rejected = append(rejected, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+		}
+	}
+	if len(rejected) == 0 {
+		return sp.sendMessages(msgs, opts)
+	}
+
+	checked := make([]*sarama.ProducerMessage, 0, len(msgs)-len(rejected))
+	checkedOpts := make([]messageOptions, 0, len(msgs)-len(rejected))
+	positions := make([]int, 0, len(msgs)-len(rejected))
+	next := 0
+	for i, msg := range msgs {
+		if next < len(rejected) && rejected[next].BatchIndex == i {
+			next++
+			continue
+		}
+		checked = append(checked, msg)
+		checkedOpts = append(checkedOpts, opts[i])
+		positions = append(positions, i)
+	}
+	var err error
+	if len(checked) > 0 {
+		err = sp.sendMessages(checked, checkedOpts)
+	}
+	return mergeProducerErrors(rejected, err, positions)
+}
+
+func (sp *syncProducer) SendMessagesSequential(msgs []*sarama.ProducerMessage) error {
+	return sendMessagesSequential(sp, msgs)
+}
+
+// sendMessagesSequential implements SendMessagesSequential on top of
+// p.SendMessage.
+func sendMessagesSequential(p SyncProducer, msgs []*sarama.ProducerMessage) error {
+	for i, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			return ProducerErrors{&ProducerError{Msg: msg, Err: err, BatchIndex: i}}
+		}
+	}
+	return nil
+}
+
+func (sp *syncProducer) sendMessages(msgs []*sarama.ProducerMessage, opts []messageOptions) error {
+	var finishers []func(error)
+	if sp.tracer != nil || sp.jaegerTracer != nil {
+		finishers = make([]func(error), len(msgs))
+		for i, msg := range msgs {
+			finishers[i] = sp.traceMessage(opts[i].ctx, msg)
+		}
+	}
+
+	expectations := make([]chan *ProducerError, len(msgs))
+	indices := make(chan int, len(msgs))
+	go func() {
+		for i, msg := range msgs {
+			expectations[i] = expectationsPool.Get().(chan *ProducerError)
+			sp.input(msg, &flight{expectation: expectations[i], opts: opts[i]})
+			indices <- i
+		}
+		close(indices)
+	}()
+
+	results := make([]*ProducerError, len(msgs))
+	for i := range indices {
+		results[i] = <-expectations[i]
+		expectationsPool.Put(expectations[i])
+	}
+	sp.resendNotLeader(msgs, opts, results)
+
+	var errors ProducerErrors
+	for i, pErr := range results {
+		if finishers != nil {
+			var err error
+			if pErr != nil {
+				err = pErr.Err
+			}
+			finishers[i](err)
+		}
+		if pErr != nil {
+			pErr.BatchIndex = i
+			errors = append(errors, pErr)
+		}
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// resendNotLeader resends the messages of msgs that failed with
+// ErrNotLeaderForPartition once, without retries, as SendMessage does, and
+// replaces their results with the outcome.
+func (sp *syncProducer) resendNotLeader(msgs []*sarama.ProducerMessage, opts []messageOptions, results []*ProducerError) {
+	if sp.IsTransactional() {
+		return
+	}
+	var resend []int
+	topics := make(map[string]struct{})
+	for i, pErr := range results {
+		if pErr != nil && errors.Is(pErr.Err, sarama.ErrNotLeaderForPartition) {
+			resend = append(resend, i)
+			topics[msgs[i].Topic] = struct{}{}
+		}
+	}
+	if len(resend) == 0 {
+		return
+	}
+	for topic := range topics {
+		if err := sp.client.RefreshMetadata(topic); err != nil {
+			level.Warn(sp.logger).Log("msg", "failed to refresh metadata after a leader change", "topic", topic, "err", err)
+		}
+	}
+
+	expectations := make([]chan *ProducerError, len(resend))
+	sent := make(chan int, len(resend))
+	go func() {
+		for j, i := range resend {
+			opts[i].singleAttempt = true
+			expectations[j] = expectationsPool.Get().(chan *ProducerError)
+			sp.input(msgs[i], &flight{expectation: expectations[j], opts: opts[i]})
+			sent <- j
+		}
+		close(sent)
+	}()
+	for j := range sent {
+		results[resend[j]] = <-expectations[j]
+		expectationsPool.Put(expectations[j])
+	}
+}
+
+func (sp *syncProducer) MaxMessageBytes(topic string) (int, error) {
+	sp.maxMessageBytesLock.Lock()
+	limit, ok := sp.maxMessageBytes[topic]
+	sp.maxMessageBytesLock.Unlock()
+	if ok {
+		return limit, nil
+	}
+
+	// the lock is not held across the request, so that a slow broker does not
+	// block lookups of other topics; concurrent misses may each describe topic
+
+	limit = sp.conf.Producer.MaxMessageBytes
+	configs, err := sp.describeTopicConfig(topic, "max.message.bytes")
+	if err != nil {
+		return 0, err
+	}
+	if value, ok := configs["max.message.bytes"]; ok {
+		brokerLimit, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, err
+		}
+		if brokerLimit < limit {
+			limit = brokerLimit
+		}
+	}
+
+	sp.maxMessageBytesLock.Lock()
+	sp.maxMessageBytes[topic] = limit
+	sp.maxMessageBytesLock.Unlock()
+	return limit, nil
+}
+
+func (sp *syncProducer) SendMessageWithExpiry(msg *sarama.ProducerMessage, ttl time.Duration) (int32, int64, error) {
+	return sp.sendMessageWithExpiry(sp, msg, ttl)
+}
+
+// sendMessageWithExpiry implements SendMessageWithExpiry, sending msg with
+// p.SendMessage once its timestamp has been set.
+func (sp *syncProducer) sendMessageWithExpiry(p SyncProducer, msg *sarama.ProducerMessage, ttl time.Duration) (int32, int64, error) {
+	if ttl <= 0 {
+		return -1, -1, sarama.ConfigurationError("ttl must be > 0")
+	}
+	retention, err := sp.retention(msg.Topic)
+	if err != nil {
+		return -1, -1, err
+	}
+	if retention < 0 {
+		return -1, -1, sarama.ConfigurationError(fmt.Sprintf("topic %s has no time-based retention", msg.Topic))
+	}
+	if ttl > retention {
+		return -1, -1, sarama.ConfigurationError(fmt.Sprintf("ttl %s exceeds the %s retention of topic %s", ttl, retention, msg.Topic))
+	}
+	msg.Timestamp = time.Now().Add(ttl - retention)
+	return p.SendMessage(msg)
+}
+
+// retention returns the retention.ms of topic, or a negative duration if the
+// topic is kept forever.
+func (sp *syncProducer) retention(topic string) (time.Duration, error) {
+	if retention, ok := sp.retentions.Load(topic); ok {
+		return retention.(time.Duration), nil
+	}
+	configs, err := sp.describeTopicConfig(topic, "retention.ms")
+	if err != nil {
+		return 0, err
+	}
+	value, ok := configs["retention.ms"]
+	if !ok {
+		return 0, fmt.Errorf("kafka: retention.ms of topic %s not found", topic)
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	retention := time.Duration(ms) * time.Millisecond
+	sp.retentions.Store(topic, retention)
+	return retention, nil
+}
+
+func (sp *syncProducer) ProduceRawBatch(topic string, partition int32, batch []byte) error {
+	conf := sp.conf
+	if !conf.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return sarama.ConfigurationError("ProduceRawBatch requires Kafka at least v0.11")
+	}
+	if sp.IsTransactional() {
+		return sarama.ConfigurationError("ProduceRawBatch cannot be used with a transactional producer")
+	}
+	// a ProduceRequest cannot carry encoded records, so the batch is decoded
+	// and AddBatch encodes it again
+	records, err := decodeRecordBatch(batch)
+	if err != nil {
+		return err
+	}
+
+	leader, err := sp.client.Leader(topic, partition)
+	if err != nil {
+		return err
+	}
+
+	request := newProduceRequest(conf)
+	request.AddBatch(topic, partition, records)
+
+	response, err := leader.Produce(request)
+	if err != nil {
+		return err
+	}
+	if response == nil {
+		// RequiredAcks is NoResponse
+		return nil
+	}
+
+	block := response.GetBlock(topic, partition)
+	if block == nil {
+		return sarama.ErrIncompleteResponse
+	}
+	if !errors.Is(block.Err, sarama.ErrNoError) {
+		return block.Err
+	}
+	return nil
+}
+
+func (sp *syncProducer) SendMessagesBinary(rawMessages [][]byte, topic string, partition int32) ([]int64, error) {
+	conf := sp.conf
+	if conf.Producer.Idempotent {
+		return nil, sarama.ConfigurationError("SendMessagesBinary cannot be used with an idempotent producer")
+	}
+	if len(rawMessages) == 0 {
+		return nil, nil
+	}
+
+	request := newProduceRequest(conf)
+	if request.Version > 2 {
+		// message sets were replaced by record batches in v3
+		request.Version = 2
+	}
+	counts := make([]int64, len(rawMessages))
+	for i, raw := range rawMessages {
+		// a compressed wrapper message is assigned one offset per inner message
+		msg, count, err := decodeLegacyMessage(raw)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Version == 1 && request.Version < 2 {
+			return nil, sarama.ConfigurationError("SendMessagesBinary requires Kafka at least v0.10 for magic 1 messages")
+		}
+		counts[i] = count
+		request.AddMessage(topic, partition, msg)
+	}
+
+	leader, err := sp.client.Leader(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	response, err := leader.Produce(request)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]int64, len(rawMessages))
+	if response == nil {
+		// RequiredAcks is NoResponse
+		for i := range offsets {
+			offsets[i] = -1
+		}
+		return offsets, nil
+	}
+
+	block := response.GetBlock(topic, partition)
+	if block == nil {
+		return nil, sarama.ErrIncompleteResponse
+	}
+	if !errors.Is(block.Err, sarama.ErrNoError) {
+		return nil, block.Err
+	}
+	next := block.Offset
+	for i, count := range counts {
+		offsets[i] = next
+		next += count
+	}
+	return offsets, nil
+}
+
+func (sp *syncProducer) UpdateFlushConfig(messages int, frequency time.Duration, bytes int) error {
+	switch {
+	case messages < 0:
+		return sarama.ConfigurationError("Producer.Flush.Messages must be >= 0")
+	case frequency < 0:
+		return sarama.ConfigurationError("Producer.Flush.Frequency must be >= 0")
+	case bytes < 0:
+		return sarama.ConfigurationError("Producer.Flush.Bytes must be >= 0")
+	}
+	if maxMessages := sp.conf.Producer.Flush.MaxMessages; maxMessages > 0 && maxMessages < messages {
+		return sarama.Wrap(ErrNotSupported, sarama.ConfigurationError(fmt.Sprintf(
+			"Producer.Flush.Messages %d exceeds Producer.Flush.MaxMessages %d, which cannot be changed live", messages, maxMessages)))
+	}
+
+	if sp.IsTransactional() {
+		// replacing the producer would fence the transaction in progress
+		return sarama.Wrap(ErrNotSupported, sarama.ConfigurationError("the flush settings of a transactional producer cannot be changed live"))
+	}
+
+	return sp.setFlushSettings(flushSettings{
+		bytes:     bytes,
+		messages:  messages,
+		frequency: frequency,
+	})
+}
+
+func (sp *syncProducer) UpdateBrokerList(addrs []string) error {
+	if len(addrs) == 0 {
+		return sarama.ConfigurationError("You must provide at least one broker address")
+	}
+	if err := sp.client.RefreshBrokers(addrs); err != nil {
+		return err
+	}
+	return sp.client.RefreshMetadata()
+}
+
+func (sp *syncProducer) ListConsumerGroupOffsets(groupID string, topics map[string][]int32) (map[string]map[int32]int64, error) {
+	coordinator, err := sp.client.Coordinator(groupID)
+	if err != nil {
+		return nil, err
+	}
+	response, err := coordinator.FetchOffset(sarama.NewOffsetFetchRequest(sp.conf.Version, groupID, topics))
+	if err != nil {
+		return nil, err
+	}
+	if !errors.Is(response.Err, sarama.ErrNoError) {
+		if errors.Is(response.Err, sarama.ErrNotCoordinatorForConsumer) {
+			_ = sp.client.RefreshCoordinator(groupID)
+		}
+		return nil, response.Err
+	}
+
+	offsets := make(map[string]map[int32]int64, len(topics))
+	for topic, partitions := range topics {
+		offsets[topic] = make(map[int32]int64, len(partitions))
+		for _, partition := range partitions {
+			block := response.GetBlock(topic, partition)
+			if block == nil {
+				return nil, sarama.ErrIncompleteResponse
+			}
+			if !errors.Is(block.Err, sarama.ErrNoError) {
+				return nil, block.Err
+			}
+			offsets[topic][partition] = block.Offset
+		}
+	}
+	return offsets, nil
+}
+
+func (sp *syncProducer) HighWatermark(ctx context.Context, topic string, partition int32) (int64, error) {
+	var offset int64
+	err := runWithContext(ctx, func() (err error) {
+		offset, err = sp.client.GetOffset(topic, partition, sarama.OffsetNewest)
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return offset, nil
+}
+
+// runWithContext runs fn, returning early with the context error if ctx is
+// done first. fn keeps running in the background in that case, so it must
+// only write to state that the caller no longer reads once ctx is done.
+func runWithContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TopicDetail describes a topic created by SyncProducer.CreateTopic.
+type TopicDetail struct {
+	sarama.TopicDetail
+	// IfNotExists makes CreateTopic succeed if the topic already exists,
+	// whatever its settings.
+	IfNotExists bool
+}
+
+func (sp *syncProducer) CreateTopic(ctx context.Context, topic string, detail TopicDetail) error {
+	return runWithContext(ctx, func() error {
+		admin, err := sp.admin()
+		if err != nil {
+			return err
+		}
+		err = admin.CreateTopic(topic, &detail.TopicDetail, false)
+		if detail.IfNotExists && errors.Is(err, sarama.ErrTopicAlreadyExists) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (sp *syncProducer) TopicExists(ctx context.Context, topic string) (bool, error) {
+	var exists bool
+	err := runWithContext(ctx, func() error {
+		client := sp.client
+		var err error
+		if exists, err = hasTopic(client, topic); err != nil || exists {
+			return err
+		}
+		if err := client.RefreshMetadata(); err != nil {
+			return err
+		}
+		exists, err = hasTopic(client, topic)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// hasTopic reports whether topic is in the metadata cache of client.
+func hasTopic(client sarama.Client, topic string) (bool, error) {
+	topics, err := client.Topics()
+	if err != nil {
+		return false, err
+	}
+	for _, t := range topics {
+		if t == topic {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (sp *syncProducer) DeleteTopic(ctx context.Context, topic string) error {
+	return runWithContext(ctx, func() error {
+		admin, err := sp.admin()
+		if err != nil {
+			return err
+		}
+		err = admin.DeleteTopic(topic)
+		switch {
+		case errors.Is(err, sarama.ErrUnknownTopicOrPartition):
+			return sarama.Wrap(ErrTopicNotFound, err)
+		case errors.Is(err, sarama.ErrTopicDeletionDisabled):
+			return sarama.Wrap(ErrDeleteTopicNotEnabled, err)
+		}
+		return err
+	})
+}
+
+// TopicConfigChange is a change to a single topic setting made by
+// SyncProducer.IncrementalAlterConfig. Value is ignored by
+// IncrementalAlterConfigsOperationDelete; Append and Subtract apply to
+// list-valued settings such as cleanup.policy.
+type TopicConfigChange struct {
+	Name  string
+	Value string
+	Op    sarama.IncrementalAlterConfigsOperation
+}
+
+func (sp *syncProducer) IncrementalAlterConfig(ctx context.Context, topic string, changes []TopicConfigChange) error {
+	if !sp.conf.Version.IsAtLeast(sarama.V2_3_0_0) {
+		return sarama.ConfigurationError("IncrementalAlterConfig requires Version >= V2_3_0_0")
+	}
+	entries := make(map[string]sarama.IncrementalAlterConfigsEntry, len(changes))
+	for _, change := range changes {
+		if _, ok := entries[change.Name]; ok {
+			return sarama.ConfigurationError(fmt.Sprintf("topic config %s is changed more than once", change.Name))
+		}
+		entry := sarama.IncrementalAlterConfigsEntry{Operation: change.Op}
+		if change.Op != sarama.IncrementalAlterConfigsOperationDelete {
+			value := change.Value
+			entry.Value = &value
+		}
+		entries[change.Name] = entry
+	}
+
+	return runWithContext(ctx, func() error {
+		admin, err := sp.admin()
+		if err != nil {
+			return err
+		}
+		return admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, false)
+	})
+}
+
+func (sp *syncProducer) SetClientQuota(ctx context.Context, quotaBytes int64) error {
+	if !sp.conf.Version.IsAtLeast(sarama.V2_6_0_0) {
+		return fmt.Errorf("%w: AlterClientQuotas requires Version >= V2_6_0_0", ErrAdminAPINotSupported)
+	}
+	if quotaBytes <= 0 {
+		return sarama.ConfigurationError(fmt.Sprintf("invalid client quota %d, must be > 0", quotaBytes))
+	}
+
+	entity := []sarama.QuotaEntityComponent{{
+		EntityType: sarama.QuotaEntityClientID,
+		MatchType:  sarama.QuotaMatchExact,
+		Name:       sp.conf.ClientID,
+	}}
+	op := sarama.ClientQuotasOp{Key: "producer_byte_rate", Value: float64(quotaBytes)}
+	err := runWithContext(ctx, func() error {
+		admin, err := sp.admin()
+		if err != nil {
+			return err
+		}
+		return admin.AlterClientQuotas(entity, op, false)
+	})
+	if errors.Is(err, sarama.ErrUnsupportedVersion) {
+		return fmt.Errorf("%w: the brokers do not support AlterClientQuotas", ErrAdminAPINotSupported)
+	}
+	return err
+}
+
+func (sp *syncProducer) TopicLag(ctx context.Context, topic string, groupID string) (map[int32]int64, error) {
+	var lag map[int32]int64
+	err := runWithContext(ctx, func() error {
+		client := sp.client
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return err
+		}
+		committed, err := sp.ListConsumerGroupOffsets(groupID, map[string][]int32{topic: partitions})
+		if err != nil {
+			return err
+		}
+
+		lag = make(map[int32]int64, len(partitions))
+		for _, partition := range partitions {
+			highWatermark, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return err
+			}
+			offset := committed[topic][partition]
+			if offset < 0 {
+				if offset, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+					return err
+				}
+			}
+			lag[partition] = highWatermark - offset
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lag, nil
+}
+
+// PartitionOffsets are the offset bounds of a partition returned by
+// SyncProducer.ListPartitionOffsets. Oldest is the offset of the oldest
+// message still retained and Newest the offset that will be assigned to the
+// next message; they are equal if the partition is empty.
+type PartitionOffsets struct {
+	Oldest int64
+	Newest int64
+}
+
+func (sp *syncProducer) ListPartitionOffsets(ctx context.Context, topic string) (map[int32]PartitionOffsets, error) {
+	var offsets map[int32]PartitionOffsets
+	err := runWithContext(ctx, func() error {
+		client := sp.client
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return err
+		}
+
+		offsets = make(map[int32]PartitionOffsets, len(partitions))
+		for _, partition := range partitions {
+			oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return err
+			}
+			newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return err
+			}
+			offsets[partition] = PartitionOffsets{Oldest: oldest, Newest: newest}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+func (sp *syncProducer) BrokerFor(topic string, partition int32) (*sarama.Broker, error) {
+	leader, err := sp.client.Leader(topic, partition)
+	if errors.Is(err, sarama.ErrLeaderNotAvailable) || errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+		return nil, sarama.Wrap(ErrNoBrokerForPartition, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if leader == nil {
+		return nil, ErrNoBrokerForPartition
+	}
+	return leader, nil
+}
+
+func (sp *syncProducer) Preconnect(ctx context.Context, topics []string, partitions map[string][]int32) error {
+	return runWithContext(ctx, func() error {
+		client := sp.client
+		wanted := make(map[string][]int32, len(topics)+len(partitions))
+		for topic, ps := range partitions {
+			wanted[topic] = ps
+		}
+		for _, topic := range topics {
+			wanted[topic] = nil
+		}
+		if len(wanted) == 0 {
+			return nil
+		}
+
+		refresh := make([]string, 0, len(wanted))
+		for topic := range wanted {
+			refresh = append(refresh, topic)
+		}
+		if err := client.RefreshMetadata(refresh...); err != nil {
+			return err
+		}
+
+		leaders := make(map[int32]*sarama.Broker)
+		for topic, ps := range wanted {
+			if ps == nil {
+				all, err := client.Partitions(topic)
+				if err != nil {
+					return err
+				}
+				ps = all
+			}
+			for _, partition := range ps {
+				leader, err := sp.BrokerFor(topic, partition)
+				if err != nil {
+					return err
+				}
+				leaders[leader.ID()] = leader
+			}
+		}
+
+		// the client has started opening each leader while looking it up;
+		// Connected waits for the attempt to complete
+		var errs []error
+		for _, leader := range leaders {
+			if err := leader.Open(client.Config()); err != nil && !errors.Is(err, sarama.ErrAlreadyConnected) {
+				errs = append(errs, fmt.Errorf("kafka: failed to connect to broker %s: %w", leader.Addr(), err))
+				continue
+			}
+			connected, err := leader.Connected()
+			if err == nil && !connected {
+				err = sarama.ErrNotConnected
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("kafka: failed to connect to broker %s: %w", leader.Addr(), err))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// sendMessageWithContext sends msg through p, returning ctx.Err() if ctx is
+// done before msg is acknowledged. If msg reaches a core producer through
+// p, ctx is passed down with it so that nothing is left waiting for the
+// acknowledgement; otherwise p.SendMessage runs in a goroutine that ends with
+// it. The options attached to msg are taken off once the send has returned.
+func sendMessageWithContext(ctx context.Context, p SyncProducer, msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	return sendWithContext(ctx, p, msg, p.SendMessage)
+}
+
+// sendWithContext is sendMessageWithContext sending msg with send, a method
+// of p that ends with p.SendMessage.
+func sendWithContext(ctx context.Context, p SyncProducer, msg *sarama.ProducerMessage, send func(*sarama.ProducerMessage) (int32, int64, error)) (partition int32, offset int64, err error) {
+	if err := ctx.Err(); err != nil {
+		takeMessageOptions(msg)
+		return -1, -1, err
+	}
+	if reachesCore(p) {
+		updateMessageOptions(msg, func(opts *messageOptions) { opts.sendCtx = ctx })
+		defer takeMessageOptions(msg)
+		return send(msg)
+	}
+
+	partition, offset = -1, -1
+	err = runWithContext(ctx, func() error {
+		defer takeMessageOptions(msg)
+		var err error
+		partition, offset, err = send(msg)
+		return err
+	})
+	if err != nil {
+		return -1, -1, err
+	}
+	return partition, offset, nil
+}
+
+func (sp *syncProducer) SetTopicConfig(topic string, overrides TopicProducerConfig) error {
+	return sp.setTopicConfig(topic, overrides)
+}
+
+func (sp *syncProducer) SetTopicPartitioner(topic string, partitioner sarama.PartitionerConstructor) error {
+	if topic == "" {
+		return sarama.ConfigurationError("a topic is required to set its partitioner")
+	}
+	sp.setTopicPartitioner(topic, partitioner)
+	return nil
+}
+
+func (sp *syncProducer) SetHeaderInterceptor(fn func(topic string, existing []sarama.RecordHeader) []sarama.RecordHeader) {
+	sp.headerInterceptorLock.Lock()
+	defer sp.headerInterceptorLock.Unlock()
+	sp.headerInterceptor = fn
+}
+
+func (sp *syncProducer) AddHeadersGlobally(headers ...sarama.RecordHeader) {
+	sp.globalHeadersLock.Lock()
+	defer sp.globalHeadersLock.Unlock()
+
+	global := make([]sarama.RecordHeader, 0, len(sp.globalHeaders)+len(headers))
+	for _, h := range sp.globalHeaders {
+		if !hasHeader(headers, h.Key) {
+			global = append(global, h)
+		}
+	}
+	for _, h := range headers {
+		h = sarama.RecordHeader{Key: bytes.Clone(h.Key), Value: bytes.Clone(h.Value)}
+		if !hasHeader(global, h.Key) {
+			global = append(global, h)
+		}
+	}
+	sp.globalHeaders = global
+}
+
+func (sp *syncProducer) RemoveGlobalHeader(key string) {
+	sp.globalHeadersLock.Lock()
+	defer sp.globalHeadersLock.Unlock()
+
+	global := make([]sarama.RecordHeader, 0, len(sp.globalHeaders))
+	for _, h := range sp.globalHeaders {
+		if string(h.Key) != key {
+			global = append(global, h)
+		}
+	}
+	sp.globalHeaders = global
+}
+
+func (sp *syncProducer) SetTopicValueSizeLimit(topic string, maxBytes int) {
+	if maxBytes <= 0 {
+		sp.valueSizeLimits.Delete(topic)
+		return
+	}
+	sp.valueSizeLimits.Store(topic, maxBytes)
+}
+
+func (sp *syncProducer) ConfigSnapshot() sarama.Config {
+	conf := cloneConfig(sp.conf)
+	flush := sp.flushSettings()
+	conf.Producer.Flush.Bytes = flush.bytes
+	conf.Producer.Flush.Messages = flush.messages
+	conf.Producer.Flush.Frequency = flush.frequency
+	return *conf
+}
+
+func (sp *syncProducer) SetMaxInflight(n int) error {
+	if n < 0 {
+		return sarama.ConfigurationError("the in-flight limit must be >= 0")
+	}
+
+	sp.topicsLock.Lock()
+	defer sp.topicsLock.Unlock()
+
+	if n > 0 && n < sp.inflight {
+		return fmt.Errorf("%w: %d messages in flight, limit %d requested", ErrCannotReduce, sp.inflight, n)
+	}
+	sp.maxInflight = n
+	sp.inflightCond.Broadcast()
+	return nil
+}
+
+// LocalBufferSize counts messages from just before they are written to
+// Input() until their expectation is resolved, so messages still queued in the
+// input channel are included without reading len(Input()) separately.
+func (sp *syncProducer) LocalBufferSize() int {
+	return int(atomic.LoadInt64(&sp.pending))
+}
+
+// admin returns a ClusterAdmin sharing the producer's client. It must not be
+// closed, as that would close the client out from under the producer.
+func (sp *syncProducer) admin() (sarama.ClusterAdmin, error) {
+	return sarama.NewClusterAdminFromClient(sp.client)
+}
+
+// describeTopicConfig fetches the named configuration entries of topic.
+func (sp *syncProducer) describeTopicConfig(topic string, names ...string) (map[string]string, error) {
+	admin, err := sp.admin()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.TopicResource,
+		Name:        topic,
+		ConfigNames: names,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		configs[entry.Name] = entry.Value
+	}
+	return configs, nil
+}
+
+func (sp *syncProducer) SendMessagesBatched(ctx context.Context, msgs <-chan *sarama.ProducerMessage, batchSize int, maxDelay time.Duration) error {
+	return sendMessagesBatched(ctx, sp, msgs, batchSize, maxDelay)
+}
+
+// sendMessagesBatched implements SendMessagesBatched on top of
+// p.SendMessages.
+func sendMessagesBatched(ctx context.Context, p SyncProducer, msgs <-chan *sarama.ProducerMessage, batchSize int, maxDelay time.Duration) error {
+	if batchSize <= 0 {
+		return sarama.ConfigurationError("batchSize must be > 0")
+	}
+
+	var (
+		failed ProducerErrors
+		sent   int
+		batch  = make([]*sarama.ProducerMessage, 0, batchSize)
+		timer  *time.Timer
+		expiry <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expiry = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.SendMessages(batch); err != nil {
+			var pErrs ProducerErrors
+			if !errors.As(err, &pErrs) {
+				for i, msg := range batch {
+					pErrs = append(pErrs, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+				}
+			}
+			// report positions in the stream rather than in the batch
+			for _, pErr := range pErrs {
+				pErr.BatchIndex += sent
+			}
+			failed = append(failed, pErrs...)
+		}
+		sent += len(batch)
+		batch = make([]*sarama.ProducerMessage, 0, batchSize)
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				flush()
+				if len(failed) > 0 {
+					return failed
+				}
+				return nil
+			}
+			batch = append(batch, msg)
+			if len(batch) >= batchSize {
+				flush()
+			} else if timer == nil && maxDelay > 0 {
+				timer = time.NewTimer(maxDelay)
+				expiry = timer.C
+			}
+		case <-expiry:
+			timer, expiry = nil, nil
+			flush()
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			if len(failed) > 0 {
+				return errors.Join(ctx.Err(), failed)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// CloseError is returned by SyncProducer.Close when messages failed to be
+// delivered while the producer was shutting down, for example because the
+// final flush timed out or a broker disconnected.
+type CloseError struct {
+	Errors ProducerErrors
+}
+
+func (ce CloseError) Error() string {
+	return fmt.Sprintf("kafka: %d messages failed during producer shutdown", len(ce.Errors))
+}
+
+func (ce CloseError) Unwrap() error {
+	return ce.Errors
+}
+
+func (sp *syncProducer) Close() error {
+	sp.closeOnce.Do(func() { sp.closeErr = sp.close() })
+	return sp.closeErr
+}
+
+func (sp *syncProducer) close() error {
+	unregisterProducer(sp)
+	close(sp.closing)
+	sp.closeAbortReasons()
+	sp.stopTxnDeadline()
+	sp.closeProducers()
+	sp.wg.Wait()
+	sp.conf.MetricRegistry.Unregister(recordErrorRateMetric)
+	if sp.ownClient {
+		if err := sp.client.Close(); err != nil {
+			level.Warn(sp.logger).Log("msg", "failed to close client", "err", err)
+		}
+	}
+	if len(sp.shutdownErrors) > 0 {
+		return CloseError{Errors: sp.shutdownErrors}
+	}
+	return nil
+}
+
+func (sp *syncProducer) IsTransactional() bool {
+	return sp.conf.Producer.Transaction.ID != ""
+}
+
+func (sp *syncProducer) TransactionalID() string {
+	return sp.conf.Producer.Transaction.ID
+}
+
+// txnProducer returns the async producer transactions run on, or
+// sarama.ErrNonTransactedProducer for a producer without a transactional id.
+func (sp *syncProducer) txnProducer() (sarama.AsyncProducer, error) {
+	if !sp.IsTransactional() {
+		return nil, sarama.ErrNonTransactedProducer
+	}
+	p := sp.defaultProducer()
+	if p == nil {
+		return nil, sarama.ErrShuttingDown
+	}
+	return p, nil
+}
+
+func (sp *syncProducer) BeginTxn() error {
+	if err := sp.takeTxnExpired(false); err != nil {
+		return err
+	}
+	p, err := sp.txnProducer()
+	if err != nil {
+		return err
+	}
+	if p.TxnStatus()&sarama.ProducerTxnFlagInTransaction != 0 {
+		return ErrTxnAlreadyStarted
+	}
+	return p.BeginTxn()
+}
+
+func (sp *syncProducer) CommitTxn() error {
+	if err := sp.takeTxnExpired(true); err != nil {
+		return err
+	}
+	p, err := sp.txnProducer()
+	if err != nil {
+		return err
+	}
+	return p.CommitTxn()
+}
+
+func (sp *syncProducer) AbortTxn() error {
+	if err := sp.takeTxnExpired(true); err != nil {
+		return err
+	}
+	p, err := sp.txnProducer()
+	if err != nil {
+		return err
+	}
+	return p.AbortTxn()
+}
+
+func (sp *syncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
+	if err := sp.takeTxnExpired(false); err != nil {
+		return err
+	}
+	p, err := sp.txnProducer()
+	if err != nil {
+		return err
+	}
+	return p.AddOffsetsToTxn(offsets, groupId)
+}
+
+func (sp *syncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error {
+	if err := sp.takeTxnExpired(false); err != nil {
+		return err
+	}
+	p, err := sp.txnProducer()
+	if err != nil {
+		return err
+	}
+	return p.AddMessageToTxn(msg, groupId, metadata)
+}
+
+func (sp *syncProducer) ConsumeAndProduce(ctx context.Context, input *sarama.ConsumerMessage, output *sarama.ProducerMessage, groupID string) error {
+	return consumeAndProduce(ctx, sp, input, output, groupID)
+}
+
+// consumeAndProduce implements ConsumeAndProduce on top of p's transaction
+// methods and p.SendMessage.
+func consumeAndProduce(ctx context.Context, p SyncProducer, input *sarama.ConsumerMessage, output *sarama.ProducerMessage, groupID string) error {
+	if !p.IsTransactional() {
+		return sarama.ErrNonTransactedProducer
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := p.BeginTxn(); err != nil {
+		return err
+	}
+
+	steps := []func() error{
+		func() error {
+			_, _, err := p.SendMessage(output)
+			return err
+		},
+		func() error {
+			return p.AddMessageToTxn(input, groupID, nil)
+		},
+	}
+	for _, step := range steps {
+		err := ctx.Err()
+		if err == nil {
+			err = step()
+		}
+		if err != nil {
+			if abortErr := p.AbortTxn(); abortErr != nil {
+				return sarama.Wrap(err, abortErr)
+			}
+			return err
+		}
+	}
+
+	if err := p.CommitTxn(); err != nil {
+		if abortErr := p.AbortTxn(); abortErr != nil {
+			return sarama.Wrap(err, abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (sp *syncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	p := sp.defaultProducer()
+	if p == nil {
+		return sarama.ProducerTxnFlagUninitialized
+	}
+	return p.TxnStatus()
+}
@@ -0,0 +1,474 @@
+package saramaproducer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+const testTopic = "my_topic"
+
+// newTestBroker returns a mock broker leading partitions 0 and 1 of
+// testTopic and acknowledging every produce request with produce.
+func newTestBroker(t *testing.T, produce *sarama.MockProduceResponse) *sarama.MockBroker {
+	t.Helper()
+
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()).
+			SetLeader(testTopic, 1, broker.BrokerID()),
+		"ProduceRequest": produce,
+	})
+	return broker
+}
+
+func newTestConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = 0
+	config.Metadata.Retry.Max = 0
+	return config
+}
+
+// newTestSyncProducer returns a producer connected to a mock broker that
+// acknowledges every message, closed at the end of the test.
+func newTestSyncProducer(t *testing.T, opts ...SyncProducerOption) SyncProducer {
+	t.Helper()
+
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	return producer
+}
+
+// sendRecorder records the messages handed to the async producer.
+type sendRecorder struct {
+	sent sync.Map // *sarama.ProducerMessage -> struct{}
+
+	lock  sync.Mutex
+	order []*sarama.ProducerMessage
+}
+
+func (sr *sendRecorder) OnSend(msg *sarama.ProducerMessage) {
+	sr.sent.Store(msg, struct{}{})
+	sr.lock.Lock()
+	sr.order = append(sr.order, msg)
+	sr.lock.Unlock()
+}
+
+func (sr *sendRecorder) wasSent(msg *sarama.ProducerMessage) bool {
+	_, ok := sr.sent.Load(msg)
+	return ok
+}
+
+// messages returns the messages sent so far, in the order they were sent.
+func (sr *sendRecorder) messages() []*sarama.ProducerMessage {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	return append([]*sarama.ProducerMessage(nil), sr.order...)
+}
+
+// consumed returns msg as a consumer would receive it.
+func consumed(t *testing.T, msg *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	t.Helper()
+
+	cm := &sarama.ConsumerMessage{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+	var err error
+	if msg.Key != nil {
+		cm.Key, err = msg.Key.Encode()
+		require.NoError(t, err)
+	}
+	if msg.Value != nil {
+		cm.Value, err = msg.Value.Encode()
+		require.NoError(t, err)
+	}
+	for _, h := range msg.Headers {
+		cm.Headers = append(cm.Headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return cm
+}
+
+// newRecordingTestSyncProducer returns a producer like newTestSyncProducer
+// along with a recorder of the messages it sends.
+func newRecordingTestSyncProducer(t *testing.T) (SyncProducer, *sendRecorder) {
+	t.Helper()
+
+	recorder := &sendRecorder{}
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	return producer, recorder
+}
+
+func TestNewSyncProducer_RequiresReturnSuccesses(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	config := newTestConfig()
+	config.Producer.Return.Successes = false
+
+	_, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.ErrorAs(t, err, new(sarama.ConfigurationError))
+}
+
+func TestSyncProducer_SendMessage(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	msg := &sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foo")}
+	partition, offset, err := producer.SendMessage(msg)
+	require.NoError(t, err)
+	require.Contains(t, []int32{0, 1}, partition)
+	require.Equal(t, int64(0), offset)
+	require.Equal(t, partition, msg.Partition)
+	require.Equal(t, 0, producer.LocalBufferSize())
+}
+
+func TestSyncProducer_SendMessageZeroCopy(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	for i := 0; i < 10; i++ {
+		partition, _, err := producer.SendMessageZeroCopy(testTopic, 1, []byte("key"), []byte("value"))
+		require.NoError(t, err)
+		require.Equal(t, int32(1), partition)
+	}
+}
+
+func TestSyncProducer_SendMessageZeroCopy_InvalidPartition(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	_, _, err := producer.SendMessageZeroCopy(testTopic, 2, nil, []byte("value"))
+	require.ErrorIs(t, err, sarama.ErrInvalidPartition)
+}
+
+func TestSyncProducer_SendMessages(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	msgs := make([]*sarama.ProducerMessage, 10)
+	for i := range msgs {
+		msgs[i] = &sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foo")}
+	}
+	require.NoError(t, producer.SendMessages(msgs))
+	for _, msg := range msgs {
+		require.Contains(t, []int32{0, 1}, msg.Partition)
+	}
+}
+
+func TestSyncProducer_SendMessages_PartialFailure(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t).SetError(testTopic, 1, sarama.ErrInvalidMessage))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, producer.Close()) }()
+
+	msgs := []*sarama.ProducerMessage{
+		{Topic: testTopic, Value: sarama.StringEncoder("ok")},
+		{Topic: testTopic, Value: sarama.StringEncoder("rejected")},
+	}
+	setManualPartition(msgs[0], 0)
+	setManualPartition(msgs[1], 1)
+
+	err = producer.SendMessages(msgs)
+	var pErrs ProducerErrors
+	require.ErrorAs(t, err, &pErrs)
+	require.Len(t, pErrs, 1)
+	require.Equal(t, 1, pErrs[0].BatchIndex)
+	require.ErrorIs(t, pErrs[0].Err, sarama.ErrInvalidMessage)
+	require.ErrorIs(t, err, sarama.ErrInvalidMessage)
+}
+
+func TestSyncProducer_SendMessageWithCallback(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	done := make(chan error, 1)
+	producer.SendMessageWithCallback(&sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foo")}, func(_ int32, _ int64, err error) {
+		done <- err
+	})
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback was not called")
+	}
+}
+
+func TestSyncProducer_UpdateFlushConfig(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("before")})
+	require.NoError(t, err)
+
+	require.NoError(t, producer.UpdateFlushConfig(1, time.Millisecond, 0))
+	snapshot := producer.ConfigSnapshot()
+	require.Equal(t, 1, snapshot.Producer.Flush.Messages)
+	require.Equal(t, time.Millisecond, snapshot.Producer.Flush.Frequency)
+
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("after")})
+	require.NoError(t, err)
+}
+
+func TestSyncProducer_SetTopicConfig(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	require.NoError(t, producer.SetTopicConfig(testTopic, TopicProducerConfig{RequiredAcks: sarama.WaitForLocal}))
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foo")})
+	require.NoError(t, err)
+
+	require.ErrorAs(t, producer.SetTopicConfig(testTopic, TopicProducerConfig{RequiredAcks: -2}), new(sarama.ConfigurationError))
+}
+
+func TestCodecSyncProducer_SendCompressedMessage(t *testing.T) {
+	producer := NewCodecSyncProducer(newTestSyncProducer(t))
+
+	msg := &CompressedProducerMessage{
+		ProducerMessage: sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foo")},
+		Codec:           sarama.CompressionZSTD,
+	}
+	_, _, err := producer.SendCompressedMessage(msg)
+	require.NoError(t, err)
+	_, ok := pendingOptions.Load(&msg.ProducerMessage)
+	require.False(t, ok)
+}
+
+func TestCodecSyncProducer_SendCompressedMessage_Rejected(t *testing.T) {
+	// the restricted producer fails the message before it reaches the core
+	producer := NewCodecSyncProducer(NewRestrictedSyncProducer(newTestSyncProducer(t), Allowlist("other")))
+
+	msg := &CompressedProducerMessage{
+		ProducerMessage: sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foo")},
+		Codec:           sarama.CompressionZSTD,
+	}
+	_, _, err := producer.SendCompressedMessage(msg)
+	require.ErrorIs(t, err, ErrTopicNotPermitted)
+	_, ok := pendingOptions.Load(&msg.ProducerMessage)
+	require.False(t, ok)
+}
+
+func TestSyncProducer_TransactionMethodsRequireTransactionalID(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	require.False(t, producer.IsTransactional())
+	require.Equal(t, sarama.ProducerTxnFlagUninitialized, producer.TxnStatus())
+	require.ErrorIs(t, producer.BeginTxn(), sarama.ErrNonTransactedProducer)
+	require.ErrorIs(t, producer.CommitTxn(), sarama.ErrNonTransactedProducer)
+}
+
+func TestSyncProducer_SendAfterClose(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foo")})
+	require.ErrorIs(t, err, sarama.ErrShuttingDown)
+}
+
+func TestSyncProducer_CloseTwice(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, producer.Close())
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, producer.Close())
+}
+
+func TestSyncProducer_SendMessageBatch(t *testing.T) {
+	producer := newTestSyncProducer(t)
+
+	msgs := []*sarama.ProducerMessage{
+		{Topic: testTopic, Partition: 1, Value: sarama.StringEncoder("foo")},
+		{Topic: testTopic, Partition: 1, Value: sarama.StringEncoder("bar")},
+	}
+	results, err := producer.SendMessageBatch(msgs)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for i, result := range results {
+		require.NoError(t, result.Err)
+		require.Equal(t, int32(1), result.Partition)
+		require.Equal(t, int64(i), result.Offset)
+	}
+}
+
+func TestSendMessagesBatched_ContextDoneKeepsErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	inner := &stubSyncProducer{err: errors.New("down")}
+	msgs := make(chan *sarama.ProducerMessage, 1)
+	msgs <- newTestMessage()
+
+	done := make(chan error, 1)
+	go func() { done <- sendMessagesBatched(ctx, inner, msgs, 1, 0) }()
+	require.Eventually(t, func() bool { return inner.sent.Load() == 1 }, time.Second, time.Millisecond)
+	cancel()
+
+	err := <-done
+	require.ErrorIs(t, err, context.Canceled)
+	var pErrs ProducerErrors
+	require.ErrorAs(t, err, &pErrs)
+	require.Len(t, pErrs, 1)
+}
+
+func TestSyncProducer_SendMessageRetriesLeaderChangeOnce(t *testing.T) {
+	produce := sarama.NewMockProduceResponse(t).
+		SetError(testTopic, 0, sarama.ErrNotLeaderForPartition).
+		SetError(testTopic, 1, sarama.ErrNotLeaderForPartition)
+	broker := newTestBroker(t, produce)
+	config := newTestConfig()
+	config.Producer.Retry.Max = 2
+	config.Producer.Retry.Backoff = time.Millisecond
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.ErrorIs(t, err, sarama.ErrNotLeaderForPartition)
+
+	var produced int
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			produced++
+		}
+	}
+	// the first send and its 2 retries, then a single attempt
+	require.Equal(t, 4, produced)
+}
+
+func TestSyncProducer_SendMessagesRetriesLeaderChangeOnce(t *testing.T) {
+	produce := sarama.NewMockProduceResponse(t).
+		SetError(testTopic, 0, sarama.ErrNotLeaderForPartition).
+		SetError(testTopic, 1, sarama.ErrNotLeaderForPartition)
+	broker := newTestBroker(t, produce)
+	config := newTestConfig()
+	config.Producer.Retry.Max = 2
+	config.Producer.Retry.Backoff = time.Millisecond
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	err = producer.SendMessages([]*sarama.ProducerMessage{newTestMessage()})
+	var pErrs ProducerErrors
+	require.ErrorAs(t, err, &pErrs)
+	require.Len(t, pErrs, 1)
+	require.ErrorIs(t, pErrs[0].Err, sarama.ErrNotLeaderForPartition)
+
+	var produced int
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			produced++
+		}
+	}
+	// the first send and its 2 retries, then a single attempt
+	require.Equal(t, 4, produced)
+}
+
+func TestSendMessageWithContext_CancelStopsCoreWaiting(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+	decorated := NewRestrictedSyncProducer(producer, Allowlist(testTopic))
+	require.True(t, reachesCore(decorated))
+
+	_, _, err = sendMessageWithContext(context.Background(), decorated, newTestMessage())
+	require.NoError(t, err)
+
+	broker.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	msg := newTestMessage()
+	start := time.Now()
+	_, _, err = sendMessageWithContext(ctx, decorated, msg)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+	_, ok := pendingOptions.Load(msg)
+	require.False(t, ok)
+}
+
+func TestSyncProducer_ListConsumerGroupOffsets(t *testing.T) {
+	const group = "test-group"
+
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()).
+			SetLeader(testTopic, 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, group, broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, testTopic, 0, 42, "", sarama.ErrNoError).
+			SetOffset(group, testTopic, 1, -1, "", sarama.ErrNoError),
+	})
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	offsets, err := producer.ListConsumerGroupOffsets(group, map[string][]int32{testTopic: {0, 1}})
+	require.NoError(t, err)
+	require.Equal(t, map[string]map[int32]int64{testTopic: {0: 42, 1: -1}}, offsets)
+
+	_, err = producer.ListConsumerGroupOffsets(group, map[string][]int32{testTopic: {2}})
+	require.ErrorIs(t, err, sarama.ErrIncompleteResponse)
+}
+
+func TestSyncProducer_TopicExists(t *testing.T) {
+	broker := newTestBroker(t, sarama.NewMockProduceResponse(t))
+	producer, err := NewSyncProducer([]string{broker.Addr()}, newTestConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	exists, err := producer.TopicExists(context.Background(), testTopic)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// the refresh for an unknown topic outlives ctx
+	broker.SetLatency(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	exists, err = producer.TopicExists(ctx, "unknown")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.False(t, exists)
+}
+
+func TestSyncProducer_SetTopicValueSizeLimit(t *testing.T) {
+	producer, recorder := newRecordingTestSyncProducer(t)
+	producer.SetTopicValueSizeLimit(testTopic, 3)
+
+	atLimit := newTestMessage()
+	_, _, err := producer.SendMessage(atLimit)
+	require.NoError(t, err)
+
+	tooLarge := &sarama.ProducerMessage{Topic: testTopic, Value: sarama.StringEncoder("foob")}
+	_, _, err = producer.SendMessage(tooLarge)
+	require.ErrorIs(t, err, sarama.ErrMessageTooLarge)
+	require.False(t, recorder.wasSent(tooLarge))
+
+	msgs := []*sarama.ProducerMessage{newTestMessage(), {Topic: testTopic, Value: sarama.StringEncoder("foob")}}
+	var pErrs ProducerErrors
+	require.ErrorAs(t, producer.SendMessages(msgs), &pErrs)
+	require.Len(t, pErrs, 1)
+	require.Same(t, msgs[1], pErrs[0].Msg)
+	require.ErrorIs(t, pErrs[0].Err, sarama.ErrMessageTooLarge)
+	require.True(t, recorder.wasSent(msgs[0]))
+
+	// a limit of 0 removes it
+	producer.SetTopicValueSizeLimit(testTopic, 0)
+	_, _, err = producer.SendMessage(tooLarge)
+	require.NoError(t, err)
+}
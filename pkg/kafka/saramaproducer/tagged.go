@@ -0,0 +1,83 @@
+package saramaproducer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrMissingTopicTag is returned by TopicTaggedSyncProducer.SendStruct when
+// the type of the value carries no kafka:"topic=..." struct tag.
+var ErrMissingTopicTag = errors.New("kafka: no kafka:\"topic=...\" struct tag found")
+
+// TopicTaggedSyncProducer is a SyncProducer that can also produce structs to
+// the topic named in their type's struct tags.
+type TopicTaggedSyncProducer struct {
+	SyncProducer
+	topics sync.Map // reflect.Type -> string
+}
+
+// NewTopicTaggedSyncProducer wraps inner so that structs can be sent with
+// SendStruct. All SyncProducer methods are forwarded to inner unchanged.
+func NewTopicTaggedSyncProducer(inner SyncProducer) *TopicTaggedSyncProducer {
+	return &TopicTaggedSyncProducer{SyncProducer: inner}
+}
+
+// SendStruct JSON-encodes v and produces it, without a key, to the topic named
+// by the kafka:"topic=..." tag of one of the fields of v's struct type, e.g.
+//
+//	type OrderPlaced struct {
+//		_  struct{} `kafka:"topic=orders"`
+//		ID string   `json:"id"`
+//	}
+//
+// v may be a struct or a pointer to one.
+func (tp *TopicTaggedSyncProducer) SendStruct(ctx context.Context, v interface{}) (partition int32, offset int64, err error) {
+	topic, err := tp.topicFor(reflect.TypeOf(v))
+	if err != nil {
+		return -1, -1, err
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	return sendMessageWithContext(ctx, tp.SyncProducer, &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(value)})
+}
+
+func (tp *TopicTaggedSyncProducer) topicFor(typ reflect.Type) (string, error) {
+	if typ == nil {
+		return "", ErrMissingTopicTag
+	}
+	if topic, ok := tp.topics.Load(typ); ok {
+		return topic.(string), nil
+	}
+
+	structType := typ
+	for structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return "", fmt.Errorf("%w: %s is not a struct", ErrMissingTopicTag, typ)
+	}
+	for i := 0; i < structType.NumField(); i++ {
+		tag, ok := structType.Field(i).Tag.Lookup("kafka")
+		if !ok {
+			continue
+		}
+		for _, option := range strings.Split(tag, ",") {
+			option = strings.TrimSpace(option)
+			if topic := strings.TrimPrefix(option, "topic="); topic != option && topic != "" {
+				tp.topics.Store(typ, topic)
+				return topic, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrMissingTopicTag, typ)
+}
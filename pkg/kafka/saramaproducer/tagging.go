@@ -0,0 +1,63 @@
+package saramaproducer
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/IBM/sarama"
+)
+
+type taggingSyncProducer struct {
+	decorator
+	tags []sarama.RecordHeader
+}
+
+// NewTaggingSyncProducer returns a SyncProducer that adds every entry of tags
+// as a record header to each message before handing it to inner, e.g. to
+// allow cost allocation by topic and tag downstream. A tag is skipped when the
+// message already carries a header with the same key, so headers set by the
+// caller always win. Tags are appended in key order.
+func NewTaggingSyncProducer(inner SyncProducer, tags map[string]string) SyncProducer {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	headers := make([]sarama.RecordHeader, 0, len(keys))
+	for _, key := range keys {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(tags[key])})
+	}
+	tp := &taggingSyncProducer{tags: headers}
+	tp.decorator = newPreSendDecorator(inner, tp)
+	return tp
+}
+
+func (tp *taggingSyncProducer) tag(msg *sarama.ProducerMessage) {
+	for _, tag := range tp.tags {
+		if !hasHeader(msg.Headers, tag.Key) {
+			msg.Headers = append(msg.Headers, tag)
+		}
+	}
+}
+
+func hasHeader(headers []sarama.RecordHeader, key []byte) bool {
+	for _, h := range headers {
+		if bytes.Equal(h.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tp *taggingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	tp.tag(msg)
+	return tp.SyncProducer.SendMessage(msg)
+}
+
+func (tp *taggingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		tp.tag(msg)
+	}
+	return tp.SyncProducer.SendMessages(msgs)
+}
@@ -0,0 +1,91 @@
+package saramaproducer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCertificate returns a PEM-encoded self-signed certificate for
+// 127.0.0.1, valid from notBefore to notAfter, and its private key.
+func newTestCertificate(t *testing.T, notBefore, notAfter time.Time) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestNewSyncProducerWithTLS(t *testing.T) {
+	certPEM, keyPEM := newTestCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	// the broker presents the same certificate and requires it from clients
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(certPEM))
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	require.NoError(t, err)
+	broker := sarama.NewMockBrokerListener(t, 1, listener)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	config := newTestConfig()
+	producer, err := NewSyncProducerWithTLS([]string{broker.Addr()}, config, certPEM, keyPEM, certPEM)
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+	// the TLS settings are applied to a copy
+	require.False(t, config.Net.TLS.Enable)
+	require.Nil(t, config.Net.TLS.Config)
+}
+
+func TestNewSyncProducerWithTLS_InvalidCertificate(t *testing.T) {
+	for name, validity := range map[string][2]time.Time{
+		"expired":       {time.Now().Add(-2 * time.Hour), time.Now().Add(-time.Hour)},
+		"not yet valid": {time.Now().Add(time.Hour), time.Now().Add(2 * time.Hour)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			certPEM, keyPEM := newTestCertificate(t, validity[0], validity[1])
+			_, err := NewSyncProducerWithTLS([]string{"127.0.0.1:1"}, newTestConfig(), certPEM, keyPEM, nil)
+			require.ErrorAs(t, err, new(sarama.ConfigurationError))
+			require.ErrorContains(t, err, "is only valid from")
+		})
+	}
+
+	certPEM, keyPEM := newTestCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	_, err := NewSyncProducerWithTLS([]string{"127.0.0.1:1"}, newTestConfig(), certPEM, keyPEM, []byte("not a certificate"))
+	require.ErrorAs(t, err, new(sarama.ConfigurationError))
+}
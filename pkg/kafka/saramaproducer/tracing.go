@@ -0,0 +1,124 @@
+package saramaproducer
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// CorrelationIDHeader is the record header used by
+// SyncProducer.SendMessageWithCorrelationID.
+const CorrelationIDHeader = "x-correlation-id"
+
+// producerMessageCarrier adapts the headers of a ProducerMessage to an
+// OpenTelemetry TextMapCarrier and an OpenTracing TextMap carrier. Set
+// replaces any existing header with the same key.
+type producerMessageCarrier struct {
+	msg *sarama.ProducerMessage
+}
+
+var (
+	_ propagation.TextMapCarrier = producerMessageCarrier{}
+	_ opentracing.TextMapReader  = producerMessageCarrier{}
+	_ opentracing.TextMapWriter  = producerMessageCarrier{}
+)
+
+func (c producerMessageCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c producerMessageCarrier) Set(key, value string) {
+	for i, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c producerMessageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
+func (c producerMessageCarrier) ForeachKey(handler func(key, val string) error) error {
+	for _, h := range c.msg.Headers {
+		if err := handler(string(h.Key), string(h.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMessageContext makes the span of ctx the parent of the spans started for
+// msg by WithOTelTracing and WithJaegerPropagator, taking precedence over the
+// trace context carried in its headers. It applies to the next send of msg,
+// through any SyncProducer of this package and with any of its methods.
+// Call release once that send has returned: a decorator may return before
+// msg reaches the core producer, which would otherwise keep ctx.
+func SetMessageContext(ctx context.Context, msg *sarama.ProducerMessage) (release func()) {
+	updateMessageOptions(msg, func(opts *messageOptions) { opts.ctx = ctx })
+	return func() { takeMessageOptions(msg) }
+}
+
+// traceMessage starts the spans configured by WithOTelTracing and
+// WithJaegerPropagator for msg and returns a function ending them with the
+// outcome of the send, or nil if neither option is set. ctx, if not nil,
+// carries their parent.
+func (sp *syncProducer) traceMessage(ctx context.Context, msg *sarama.ProducerMessage) func(err error) {
+	switch {
+	case sp.tracer != nil && sp.jaegerTracer != nil:
+		span := sp.startSpan(ctx, msg)
+		jaegerSpan := sp.startJaegerSpan(ctx, msg)
+		return func(err error) {
+			endSpan(span, msg, err)
+			endJaegerSpan(jaegerSpan, msg, err)
+		}
+	case sp.tracer != nil:
+		span := sp.startSpan(ctx, msg)
+		return func(err error) { endSpan(span, msg, err) }
+	case sp.jaegerTracer != nil:
+		span := sp.startJaegerSpan(ctx, msg)
+		return func(err error) { endJaegerSpan(span, msg, err) }
+	}
+	return nil
+}
+
+func (sp *syncProducer) SendMessageWithCorrelationID(ctx context.Context, msg *sarama.ProducerMessage, correlationID string) (partition int32, offset int64, err error) {
+	return sp.sendMessageWithCorrelationID(ctx, sp, msg, correlationID)
+}
+
+// sendMessageWithCorrelationID implements SendMessageWithCorrelationID,
+// sending msg with p.SendMessage once the headers have been injected.
+func (sp *syncProducer) sendMessageWithCorrelationID(ctx context.Context, p SyncProducer, msg *sarama.ProducerMessage, correlationID string) (partition int32, offset int64, err error) {
+	carrier := producerMessageCarrier{msg: msg}
+	carrier.Set(CorrelationIDHeader, correlationID)
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if sp.jaegerTracer != nil {
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			if err := sp.jaegerTracer.Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+				level.Debug(sp.logger).Log("msg", "failed to inject span into message", "topic", msg.Topic, "err", err)
+			}
+		}
+	}
+
+	partition, offset, err = p.SendMessage(msg)
+	if err != nil {
+		level.Debug(sp.logger).Log("msg", "failed to produce message", "correlation_id", correlationID, "topic", msg.Topic, "err", err)
+		return partition, offset, err
+	}
+	level.Debug(sp.logger).Log("msg", "produced message", "correlation_id", correlationID, "topic", msg.Topic, "partition", partition, "offset", offset)
+	return partition, offset, nil
+}
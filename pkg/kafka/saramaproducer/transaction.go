@@ -0,0 +1,84 @@
+package saramaproducer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// Transaction is a transaction started with SyncProducer.StartTransaction. As
+// with database/sql, defer Abort right after starting it and call Commit once
+// all messages have been sent; Abort then does nothing but return ErrTxnDone:
+//
+//	txn, err := producer.StartTransaction(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer txn.Abort()
+//	if _, _, err := txn.SendMessage(msg); err != nil {
+//		return err
+//	}
+//	return txn.Commit()
+//
+// A Transaction is finished once Commit succeeds or Abort is called; after
+// that, all its methods return ErrTxnDone. A failed Commit leaves it open so
+// that the deferred Abort aborts it.
+type Transaction struct {
+	producer SyncProducer
+
+	lock sync.Mutex
+	done bool
+}
+
+func (sp *syncProducer) StartTransaction(ctx context.Context) (*Transaction, error) {
+	return startTransaction(ctx, sp)
+}
+
+// startTransaction implements StartTransaction, returning a Transaction that
+// sends through p.
+func startTransaction(ctx context.Context, p SyncProducer) (*Transaction, error) {
+	if err := p.BeginTxnWithTimeout(ctx); err != nil {
+		return nil, err
+	}
+	return &Transaction{producer: p}, nil
+}
+
+// SendMessage produces msg as part of the transaction.
+func (txn *Transaction) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	txn.lock.Lock()
+	done := txn.done
+	txn.lock.Unlock()
+	if done {
+		return -1, -1, ErrTxnDone
+	}
+	return txn.producer.SendMessage(msg)
+}
+
+// Commit commits the transaction.
+func (txn *Transaction) Commit() error {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if txn.done {
+		return ErrTxnDone
+	}
+	if err := txn.producer.CommitTxn(); err != nil {
+		return err
+	}
+	txn.done = true
+	return nil
+}
+
+// Abort aborts the transaction. It returns ErrTxnDone without doing anything
+// if the transaction has already been committed or aborted.
+func (txn *Transaction) Abort() error {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if txn.done {
+		return ErrTxnDone
+	}
+	txn.done = true
+	return txn.producer.AbortTxn()
+}
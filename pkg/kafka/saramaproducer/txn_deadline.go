@@ -0,0 +1,83 @@
+package saramaproducer
+
+import (
+	"context"
+
+	"github.com/go-kit/log/level"
+)
+
+func (sp *syncProducer) BeginTxnWithTimeout(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := sp.BeginTxn(); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	sp.txnDeadlineLock.Lock()
+	sp.txnDeadlineStop = stop
+	sp.txnDeadlineLock.Unlock()
+
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+			sp.expireTxn(stop, ctx.Err())
+		}
+	}()
+	return nil
+}
+
+// expireTxn aborts the transaction watched by stop, unless CommitTxn or
+// AbortTxn has been called for it in the meantime, and records err for the
+// next transaction method. The lock is held during the abort so that a
+// concurrent CommitTxn waits for it and reports err.
+func (sp *syncProducer) expireTxn(stop chan struct{}, err error) {
+	sp.txnDeadlineLock.Lock()
+	defer sp.txnDeadlineLock.Unlock()
+
+	if sp.txnDeadlineStop != stop {
+		return
+	}
+	sp.txnDeadlineStop = nil
+
+	p, abortErr := sp.txnProducer()
+	if abortErr == nil {
+		abortErr = p.AbortTxn()
+	}
+	if abortErr != nil {
+		level.Warn(sp.logger).Log("msg", "failed to abort expired transaction", "err", abortErr)
+	}
+	sp.txnExpired = err
+}
+
+// takeTxnExpired returns and clears the error recorded by an expired
+// BeginTxnWithTimeout. When ending is set, the caller is about to commit or
+// abort, so the deadline of the current transaction is also cancelled.
+func (sp *syncProducer) takeTxnExpired(ending bool) error {
+	sp.txnDeadlineLock.Lock()
+	defer sp.txnDeadlineLock.Unlock()
+
+	if err := sp.txnExpired; err != nil {
+		sp.txnExpired = nil
+		return err
+	}
+	if ending {
+		sp.stopTxnDeadlineLocked()
+	}
+	return nil
+}
+
+func (sp *syncProducer) stopTxnDeadline() {
+	sp.txnDeadlineLock.Lock()
+	defer sp.txnDeadlineLock.Unlock()
+	sp.stopTxnDeadlineLocked()
+}
+
+func (sp *syncProducer) stopTxnDeadlineLocked() {
+	if sp.txnDeadlineStop != nil {
+		close(sp.txnDeadlineStop)
+		sp.txnDeadlineStop = nil
+	}
+}
@@ -0,0 +1,70 @@
+package saramaproducer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/IBM/sarama"
+)
+
+// SerdeEncoderFunc converts a value of a registered Go type into an Encoder
+// that can be used as the Key or Value of a ProducerMessage.
+type SerdeEncoderFunc func(v interface{}) (sarama.Encoder, error)
+
+// SerdeRegistry maps Go types to the SerdeEncoderFunc used to encode them. It
+// is not safe for concurrent modification; register all types before handing
+// the registry to NewTypedSyncProducer.
+type SerdeRegistry map[reflect.Type]SerdeEncoderFunc
+
+// Register associates the dynamic type of sample with fn.
+func (r SerdeRegistry) Register(sample interface{}, fn SerdeEncoderFunc) {
+	r[reflect.TypeOf(sample)] = fn
+}
+
+// encode looks up the encoder for the dynamic type of v. Values that already
+// implement Encoder are used as-is and nil values encode to a nil Encoder.
+func (r SerdeRegistry) encode(v interface{}) (sarama.Encoder, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if enc, ok := v.(sarama.Encoder); ok {
+		return enc, nil
+	}
+	fn, ok := r[reflect.TypeOf(v)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrSerdeNotRegistered, v)
+	}
+	return fn(v)
+}
+
+// ErrSerdeNotRegistered is returned by TypedSyncProducer.SendTyped when no
+// encoder has been registered for the type of the key or value.
+var ErrSerdeNotRegistered = errors.New("kafka: no encoder registered for type")
+
+// TypedSyncProducer is a SyncProducer that can also produce arbitrary Go values,
+// encoding them according to a SerdeRegistry.
+type TypedSyncProducer struct {
+	SyncProducer
+	registry SerdeRegistry
+}
+
+// NewTypedSyncProducer wraps inner so that typed keys and values can be sent
+// with SendTyped. All SyncProducer methods are forwarded to inner unchanged.
+func NewTypedSyncProducer(inner SyncProducer, registry SerdeRegistry) *TypedSyncProducer {
+	return &TypedSyncProducer{SyncProducer: inner, registry: registry}
+}
+
+// SendTyped encodes key and value using the encoders registered for their
+// types and produces the result to topic.
+func (tp *TypedSyncProducer) SendTyped(topic string, key interface{}, value interface{}) (partition int32, offset int64, err error) {
+	keyEnc, err := tp.registry.encode(key)
+	if err != nil {
+		return -1, -1, err
+	}
+	valueEnc, err := tp.registry.encode(value)
+	if err != nil {
+		return -1, -1, err
+	}
+	return tp.SendMessage(&sarama.ProducerMessage{Topic: topic, Key: keyEnc, Value: valueEnc})
+}
@@ -0,0 +1,154 @@
+package saramaproducer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+// Kafka API keys of the requests a producer depends on.
+const (
+	apiKeyProduce            int16 = 0
+	apiKeyInitProducerID     int16 = 22
+	apiKeyAddPartitionsToTxn int16 = 24
+	apiKeyEndTxn             int16 = 26
+)
+
+// ValidationError is a problem found by SyncProducerValidator.DryRun.
+type ValidationError struct {
+	// Setting is the Config field at fault, e.g. "Producer.Compression".
+	Setting string
+	// Broker is the address of the broker the problem was found on, or empty
+	// if it applies to the whole configuration.
+	Broker string
+	Reason string
+}
+
+func (e ValidationError) Error() string {
+	if e.Broker == "" {
+		return fmt.Sprintf("kafka: invalid %s: %s", e.Setting, e.Reason)
+	}
+	return fmt.Sprintf("kafka: invalid %s for broker %s: %s", e.Setting, e.Broker, e.Reason)
+}
+
+// SyncProducerValidator checks a SyncProducer configuration against a live
+// cluster without producing anything.
+type SyncProducerValidator struct {
+	addrs  []string
+	config *sarama.Config
+}
+
+// NewSyncProducerValidator returns a validator for the SyncProducer that
+// NewSyncProducer(addrs, config) would create.
+func NewSyncProducerValidator(addrs []string, config *sarama.Config) *SyncProducerValidator {
+	return &SyncProducerValidator{addrs: addrs, config: config}
+}
+
+// DryRun validates the configuration locally, connects to the cluster and
+// asks every broker for the API versions it supports, then checks that the
+// brokers can serve the configured Version, RequiredAcks, Compression,
+// Idempotent and Transaction.ID. It returns every problem found, or nil if
+// there is none. If ctx is done, the problems found so far are returned
+// together with one for ctx's error.
+func (v *SyncProducerValidator) DryRun(ctx context.Context) []ValidationError {
+	config := v.config
+	if config == nil {
+		config = sarama.NewConfig()
+		config.Producer.Return.Successes = true
+	}
+
+	if err := verifyProducerConfig(config); err != nil {
+		return []ValidationError{{Setting: "Producer.Return", Reason: err.Error()}}
+	}
+	if err := config.Validate(); err != nil {
+		return []ValidationError{{Setting: "Config", Reason: err.Error()}}
+	}
+
+	var errs []ValidationError
+	if config.Producer.RequiredAcks > sarama.WaitForLocal {
+		errs = append(errs, ValidationError{
+			Setting: "Producer.RequiredAcks",
+			Reason:  fmt.Sprintf("brokers only accept -1, 0 and 1, got %d", config.Producer.RequiredAcks),
+		})
+	}
+
+	var client sarama.Client
+	err := runWithContext(ctx, func() error {
+		var err error
+		client, err = sarama.NewClient(v.addrs, config)
+		return err
+	})
+	if err != nil {
+		if !errors.Is(err, ctx.Err()) {
+			err = fmt.Errorf("failed to connect: %w", err)
+		}
+		return append(errs, ValidationError{Setting: "Net", Reason: err.Error()})
+	}
+	defer func() { _ = client.Close() }()
+
+	for _, broker := range client.Brokers() {
+		if err := ctx.Err(); err != nil {
+			return append(errs, ValidationError{Setting: "Net", Reason: err.Error()})
+		}
+		errs = append(errs, validateBroker(config, broker)...)
+	}
+	return errs
+}
+
+// validateBroker checks the API versions supported by broker against config.
+func validateBroker(config *sarama.Config, broker *sarama.Broker) []ValidationError {
+	if err := broker.Open(config); err != nil && !errors.Is(err, sarama.ErrAlreadyConnected) {
+		return []ValidationError{{Setting: "Net", Broker: broker.Addr(), Reason: err.Error()}}
+	}
+	resp, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return []ValidationError{{Setting: "Net", Broker: broker.Addr(), Reason: fmt.Sprintf("ApiVersions request failed: %v", err)}}
+	}
+	if resp.ErrorCode != int16(sarama.ErrNoError) {
+		return []ValidationError{{Setting: "Net", Broker: broker.Addr(), Reason: fmt.Sprintf("ApiVersions request failed: %v", sarama.KError(resp.ErrorCode))}}
+	}
+
+	maxVersions := make(map[int16]int16, len(resp.ApiKeys))
+	for _, key := range resp.ApiKeys {
+		maxVersions[key.ApiKey] = key.MaxVersion
+	}
+	supports := func(key int16, version int16) bool {
+		maxVersion, ok := maxVersions[key]
+		return ok && maxVersion >= version
+	}
+
+	var errs []ValidationError
+	add := func(setting, reason string, args ...interface{}) {
+		errs = append(errs, ValidationError{Setting: setting, Broker: broker.Addr(), Reason: fmt.Sprintf(reason, args...)})
+	}
+
+	produce := apiKeyProduce
+	if required := newProduceRequest(config).Version; !supports(produce, required) {
+		add("Version", "Version %s sends Produce v%d, the broker supports up to v%d", config.Version, required, maxVersions[produce])
+	}
+	if config.Producer.Compression == sarama.CompressionZSTD && !supports(produce, 7) {
+		add("Producer.Compression", "zstd requires Produce v7, the broker supports up to v%d", maxVersions[produce])
+	}
+
+	initProducerID := apiKeyInitProducerID
+	if config.Producer.Idempotent && !supports(initProducerID, 0) {
+		add("Producer.Idempotent", "the broker does not support InitProducerId")
+	}
+	if config.Producer.Transaction.ID != "" {
+		for _, api := range []struct {
+			name string
+			key  int16
+		}{
+			{"InitProducerId", initProducerID},
+			{"AddPartitionsToTxn", apiKeyAddPartitionsToTxn},
+			{"EndTxn", apiKeyEndTxn},
+		} {
+			if !supports(api.key, 0) {
+				add("Producer.Transaction.ID", "transactions require %s, which the broker does not support", api.name)
+			}
+		}
+	}
+	return errs
+}
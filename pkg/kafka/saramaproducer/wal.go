@@ -0,0 +1,416 @@
+package saramaproducer
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	walEntryPut    int8 = 1
+	walEntryCommit int8 = 2
+
+	// walFrameHeaderSize is the length and CRC32C preceding each entry
+	walFrameHeaderSize = 8
+	// walCompactSize is the file size above which the WAL is truncated once
+	// no entries are pending
+	walCompactSize = 64 << 20
+)
+
+// walEntry is a single record of the write-ahead log. Put entries carry a
+// message, commit entries only the id of the put entry they settle.
+type walEntry struct {
+	kind      int8
+	id        int64
+	topic     string
+	key       []byte
+	value     []byte
+	headers   []sarama.RecordHeader
+	timestamp int64
+	// manual is set if partition was chosen by the caller, e.g. with
+	// SendMessageToPartition, rather than by the partitioner
+	manual    bool
+	partition int32
+}
+
+// encode serializes e with the primitive types of the Kafka protocol.
+func (e *walEntry) encode() []byte {
+	buf := []byte{byte(e.kind)}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(e.id))
+	if e.kind != walEntryPut {
+		return buf
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(e.topic)))
+	buf = append(buf, e.topic...)
+	buf = appendWALBytes(buf, e.key)
+	buf = appendWALBytes(buf, e.value)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(e.timestamp))
+	if e.manual {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(e.partition))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(e.headers)))
+	for _, h := range e.headers {
+		buf = appendWALBytes(buf, h.Key)
+		buf = appendWALBytes(buf, h.Value)
+	}
+	return buf
+}
+
+// appendWALBytes appends b prefixed by its int32 length, -1 meaning nil.
+func appendWALBytes(buf, b []byte) []byte {
+	if b == nil {
+		return binary.BigEndian.AppendUint32(buf, math.MaxUint32)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(b)))
+	return append(buf, b...)
+}
+
+func (e *walEntry) decode(raw []byte) error {
+	r := &rawReader{buf: raw}
+	e.kind = r.int8()
+	e.id = r.int64()
+	if e.kind != walEntryPut {
+		return r.err
+	}
+
+	e.topic = string(r.next(int(r.int16())))
+	e.key = r.bytes()
+	e.value = r.bytes()
+	e.timestamp = r.int64()
+	e.manual = r.int8() != 0
+	e.partition = r.int32()
+	n := r.int32()
+	if r.err == nil && (n < 0 || int(n) > r.remaining()) {
+		r.fail()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	e.headers = make([]sarama.RecordHeader, n)
+	for i := range e.headers {
+		e.headers[i].Key = r.bytes()
+		e.headers[i].Value = r.bytes()
+	}
+	return r.err
+}
+
+// message returns the message of a put entry, sent to the same partition if
+// the caller chose it. The partition is attached as a message option, which
+// the caller must take off once the message has been sent.
+func (e *walEntry) message() *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{Topic: e.topic, Headers: e.headers}
+	if e.key != nil {
+		msg.Key = sarama.ByteEncoder(e.key)
+	}
+	if e.value != nil {
+		msg.Value = sarama.ByteEncoder(e.value)
+	}
+	if e.timestamp != 0 {
+		msg.Timestamp = time.UnixMilli(e.timestamp)
+	}
+	if e.manual {
+		setManualPartition(msg, e.partition)
+	}
+	return msg
+}
+
+type walSyncProducer struct {
+	decorator
+
+	lock    sync.Mutex
+	file    *os.File
+	size    int64
+	nextID  int64
+	pending map[int64]struct{}
+
+	logger log.Logger
+}
+
+// NewWALSyncProducer returns a SyncProducer that appends every message to the
+// write-ahead log at walPath, and syncs it to disk, before handing it to
+// inner. Once inner has acknowledged the message its entry is marked as
+// committed. Entries left uncommitted by a crash are resent through inner, in
+// their original order, when the next producer is created for walPath. If one
+// of them fails with an error that may go away, such as the cluster being
+// unreachable, NewWALSyncProducer returns the error and leaves the log
+// untouched; other failures, such as a deleted topic or a message too large,
+// would fail every replay, so those messages are logged and dropped.
+// Delivery is at-least-once: a crash between the acknowledgement and the
+// commit leads to the message being sent again.
+//
+// Messages that fail to send are reported to the caller and committed like
+// delivered ones, so that only the messages in flight when the process
+// stopped are resent. Messages sent with a partition chosen by the caller
+// are resent to that partition. SendMessages logs its messages with a single
+// sync; if it fails to encode a message, that message and all those after it
+// are reported as failed without being sent, and if it fails to write the
+// log, all of them are. The log is truncated after a successful replay and
+// whenever it has grown large with no entries pending. Replays and failures
+// to update the log are logged to the logger of inner. Close closes the log
+// after inner.
+func NewWALSyncProducer(inner SyncProducer, walPath string) (SyncProducer, error) {
+	file, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	wp := &walSyncProducer{
+		file:    file,
+		pending: make(map[int64]struct{}),
+	}
+	wp.decorator = newDecorator(inner, wp)
+	wp.logger = wp.decorator.coreLogger()
+	if err := wp.replay(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return wp, nil
+}
+
+// replay resends the uncommitted entries of the log and truncates it. It
+// stops at the first entry failing with a transient error, so that the log is
+// replayed again by the next producer.
+func (wp *walSyncProducer) replay() error {
+	raw, err := io.ReadAll(wp.file)
+	if err != nil {
+		return err
+	}
+
+	var (
+		puts []*walEntry
+		done = make(map[int64]struct{})
+		off  int
+	)
+	for off+walFrameHeaderSize <= len(raw) {
+		length := int(binary.BigEndian.Uint32(raw[off:]))
+		checksum := binary.BigEndian.Uint32(raw[off+4:])
+		end := off + walFrameHeaderSize + length
+		if end > len(raw) || crc32.Checksum(raw[off+walFrameHeaderSize:end], castagnoliTable) != checksum {
+			// a torn write at the tail left by a crash
+			break
+		}
+		entry := new(walEntry)
+		if err := entry.decode(raw[off+walFrameHeaderSize : end]); err != nil {
+			return err
+		}
+		switch entry.kind {
+		case walEntryPut:
+			puts = append(puts, entry)
+		case walEntryCommit:
+			done[entry.id] = struct{}{}
+		}
+		off = end
+	}
+	if off < len(raw) {
+		level.Warn(wp.logger).Log("msg", "discarding incomplete entries at the end of the write-ahead log", "bytes", len(raw)-off, "path", wp.file.Name())
+	}
+
+	var replayed, dropped int
+	for _, entry := range puts {
+		if _, ok := done[entry.id]; ok {
+			continue
+		}
+		msg := entry.message()
+		_, _, err := wp.SyncProducer.SendMessage(msg)
+		takeMessageOptions(msg)
+		if err != nil {
+			if isTransientProduceError(err) {
+				return err
+			}
+			level.Warn(wp.logger).Log("msg", "dropping write-ahead log entry that failed to be replayed", "id", entry.id, "topic", entry.topic, "path", wp.file.Name(), "err", err)
+			dropped++
+			continue
+		}
+		replayed++
+	}
+	if replayed+dropped > 0 {
+		level.Info(wp.logger).Log("msg", "replayed uncommitted messages from the write-ahead log", "count", replayed, "dropped", dropped, "path", wp.file.Name())
+	}
+
+	return wp.truncate()
+}
+
+func (wp *walSyncProducer) truncate() error {
+	if err := wp.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := wp.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	wp.size = 0
+	return wp.file.Sync()
+}
+
+// append writes entries to the log in a single write, syncing it to disk if
+// durable.
+func (wp *walSyncProducer) append(durable bool, entries ...*walEntry) error {
+	var frames []byte
+	for _, entry := range entries {
+		payload := entry.encode()
+		frames = binary.BigEndian.AppendUint32(frames, uint32(len(payload)))
+		frames = binary.BigEndian.AppendUint32(frames, crc32.Checksum(payload, castagnoliTable))
+		frames = append(frames, payload...)
+	}
+
+	n, err := wp.file.Write(frames)
+	wp.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if durable {
+		return wp.file.Sync()
+	}
+	return nil
+}
+
+// putEntry returns the put entry recording msg, without an id.
+func putEntry(msg *sarama.ProducerMessage) (*walEntry, error) {
+	entry := &walEntry{kind: walEntryPut, topic: msg.Topic, headers: msg.Headers}
+	var err error
+	if msg.Key != nil {
+		if entry.key, err = msg.Key.Encode(); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Value != nil {
+		if entry.value, err = msg.Value.Encode(); err != nil {
+			return nil, err
+		}
+	}
+	if !msg.Timestamp.IsZero() {
+		entry.timestamp = msg.Timestamp.UnixMilli()
+	}
+	if isManualPartition(msg) {
+		entry.manual = true
+		entry.partition = msg.Partition
+	}
+	return entry, nil
+}
+
+// log durably records entries, assigning their ids, with a single sync. If
+// that fails, none of them is pending.
+func (wp *walSyncProducer) log(entries ...*walEntry) error {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+
+	for _, entry := range entries {
+		entry.id = wp.nextID
+		wp.nextID++
+	}
+	if err := wp.append(true, entries...); err != nil {
+		// entries that made it to the file must not be replayed, as the
+		// caller is told they were not sent
+		commits := make([]*walEntry, len(entries))
+		for i, entry := range entries {
+			commits[i] = &walEntry{kind: walEntryCommit, id: entry.id}
+		}
+		if commitErr := wp.append(false, commits...); commitErr != nil {
+			level.Warn(wp.logger).Log("msg", "failed to commit write-ahead log entries that failed to be logged", "path", wp.file.Name(), "err", commitErr)
+		}
+		return err
+	}
+	for _, entry := range entries {
+		wp.pending[entry.id] = struct{}{}
+	}
+	return nil
+}
+
+// commit marks the put entry id as settled, whether its message was
+// delivered or failed and reported to the caller, and drops it from the
+// pending entries. Errors are only logged, as the worst outcome is a
+// duplicate on replay.
+func (wp *walSyncProducer) commit(id int64) {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+
+	delete(wp.pending, id)
+	if err := wp.append(false, &walEntry{kind: walEntryCommit, id: id}); err != nil {
+		level.Warn(wp.logger).Log("msg", "failed to commit write-ahead log entry", "id", id, "path", wp.file.Name(), "err", err)
+		return
+	}
+
+	if len(wp.pending) == 0 && wp.size > walCompactSize {
+		if err := wp.truncate(); err != nil {
+			level.Warn(wp.logger).Log("msg", "failed to truncate write-ahead log", "path", wp.file.Name(), "err", err)
+		}
+	}
+}
+
+func (wp *walSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	entry, err := putEntry(msg)
+	if err != nil {
+		return -1, -1, err
+	}
+	if err := wp.log(entry); err != nil {
+		return -1, -1, err
+	}
+	defer wp.commit(entry.id)
+	return wp.SyncProducer.SendMessage(msg)
+}
+
+func (wp *walSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	// messages are sent up to the first one that fails to be encoded, and
+	// not at all if the log cannot be written
+	entries := make([]*walEntry, 0, len(msgs))
+	var logErr error
+	for _, msg := range msgs {
+		entry, err := putEntry(msg)
+		if err != nil {
+			logErr = err
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) > 0 {
+		if err := wp.log(entries...); err != nil {
+			logErr = err
+			entries = entries[:0]
+		}
+	}
+
+	var errs ProducerErrors
+	if len(entries) > 0 {
+		logged := msgs[:len(entries)]
+		err := wp.SyncProducer.SendMessages(logged)
+		for _, entry := range entries {
+			wp.commit(entry.id)
+		}
+		if err != nil && !errors.As(err, &errs) {
+			for i, msg := range logged {
+				errs = append(errs, &ProducerError{Msg: msg, Err: err, BatchIndex: i})
+			}
+		}
+	}
+	for i := len(entries); i < len(msgs); i++ {
+		errs = append(errs, &ProducerError{Msg: msgs[i], Err: logErr, BatchIndex: i})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (wp *walSyncProducer) Close() error {
+	err := wp.SyncProducer.Close()
+
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	if closeErr := wp.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
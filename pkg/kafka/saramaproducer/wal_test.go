@@ -0,0 +1,175 @@
+package saramaproducer
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALEntry_EncodeDecode(t *testing.T) {
+	for name, entry := range map[string]walEntry{
+		"put": {
+			kind:      walEntryPut,
+			id:        42,
+			topic:     testTopic,
+			key:       []byte("key"),
+			value:     []byte("value"),
+			headers:   []sarama.RecordHeader{{Key: []byte("h"), Value: []byte("v")}},
+			timestamp: 1700000000000,
+		},
+		"put to partition": {
+			kind:      walEntryPut,
+			id:        7,
+			topic:     testTopic,
+			value:     []byte("value"),
+			headers:   []sarama.RecordHeader{},
+			manual:    true,
+			partition: 3,
+		},
+		"put without key": {
+			kind:    walEntryPut,
+			id:      1,
+			topic:   testTopic,
+			value:   []byte("value"),
+			headers: []sarama.RecordHeader{},
+		},
+		"commit": {kind: walEntryCommit, id: 42},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var decoded walEntry
+			require.NoError(t, decoded.decode(entry.encode()))
+			require.Equal(t, entry, decoded)
+		})
+	}
+}
+
+func TestWALEntry_DecodeTruncated(t *testing.T) {
+	entry := walEntry{kind: walEntryPut, id: 1, topic: testTopic, value: []byte("value")}
+	raw := entry.encode()
+
+	var decoded walEntry
+	require.Error(t, decoded.decode(raw[:len(raw)-1]))
+}
+
+// failingEncoder is a sarama.Encoder that always fails to encode.
+type failingEncoder struct{}
+
+func (failingEncoder) Encode() ([]byte, error) { return nil, errors.New("encode failed") }
+func (failingEncoder) Length() int             { return 0 }
+
+func TestWALSyncProducer_FailedMessagesAreNotReplayed(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "wal")
+	inner := &stubSyncProducer{err: errors.New("down")}
+	producer, err := NewWALSyncProducer(inner, walPath)
+	require.NoError(t, err)
+
+	_, _, err = producer.SendMessage(newTestMessage())
+	require.Error(t, err)
+	require.Error(t, producer.SendMessages([]*sarama.ProducerMessage{newTestMessage(), newTestMessage()}))
+	require.Empty(t, producer.(*walSyncProducer).pending)
+	require.NoError(t, producer.Close())
+
+	next := &stubSyncProducer{}
+	producer, err = NewWALSyncProducer(next, walPath)
+	require.NoError(t, err)
+	require.Zero(t, next.sent.Load())
+	require.NoError(t, producer.Close())
+}
+
+func TestWALSyncProducer_SendMessagesReportsUnloggedMessages(t *testing.T) {
+	inner := &stubSyncProducer{}
+	producer, err := NewWALSyncProducer(inner, filepath.Join(t.TempDir(), "wal"))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, producer.Close()) })
+
+	msgs := []*sarama.ProducerMessage{
+		newTestMessage(),
+		{Topic: testTopic, Value: failingEncoder{}},
+		newTestMessage(),
+	}
+	err = producer.SendMessages(msgs)
+
+	var pErrs ProducerErrors
+	require.ErrorAs(t, err, &pErrs)
+	require.Len(t, pErrs, 2)
+	require.Same(t, msgs[1], pErrs[0].Msg)
+	require.Equal(t, 1, pErrs[0].BatchIndex)
+	require.Same(t, msgs[2], pErrs[1].Msg)
+	require.Equal(t, 2, pErrs[1].BatchIndex)
+	require.Equal(t, int64(1), inner.sent.Load())
+	require.Empty(t, producer.(*walSyncProducer).pending)
+}
+
+// partitionSyncProducer records the partition chosen by the caller for each
+// message it is sent.
+type partitionSyncProducer struct {
+	stubSyncProducer
+	partitions []int32
+}
+
+func (pp *partitionSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	partition := int32(-1)
+	if isManualPartition(msg) {
+		partition = msg.Partition
+	}
+	pp.partitions = append(pp.partitions, partition)
+	return pp.stubSyncProducer.SendMessage(msg)
+}
+
+func TestWALSyncProducer_ReplaysToManualPartition(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "wal")
+	producer, err := NewWALSyncProducer(&stubSyncProducer{}, walPath)
+	require.NoError(t, err)
+
+	// log the messages without committing them, as if the process crashed
+	// while they were in flight
+	wp := producer.(*walSyncProducer)
+	toPartition := newTestMessage()
+	setManualPartition(toPartition, 1)
+	var entries []*walEntry
+	for _, msg := range []*sarama.ProducerMessage{toPartition, newTestMessage()} {
+		entry, err := putEntry(msg)
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	takeMessageOptions(toPartition)
+	require.NoError(t, wp.log(entries...))
+	require.NoError(t, wp.file.Close())
+
+	next := &partitionSyncProducer{}
+	producer, err = NewWALSyncProducer(next, walPath)
+	require.NoError(t, err)
+	require.Equal(t, []int32{1, -1}, next.partitions)
+	require.NoError(t, producer.Close())
+}
+
+func TestWALSyncProducer_ReplayFailures(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "wal")
+	producer, err := NewWALSyncProducer(&stubSyncProducer{}, walPath)
+	require.NoError(t, err)
+	// an uncommitted entry, as if the process crashed while it was in flight
+	entry, err := putEntry(newTestMessage())
+	require.NoError(t, err)
+	require.NoError(t, producer.(*walSyncProducer).log(entry))
+	require.NoError(t, producer.(*walSyncProducer).file.Close())
+
+	// transient errors fail the producer and keep the entry
+	_, err = NewWALSyncProducer(&stubSyncProducer{err: sarama.ErrLeaderNotAvailable}, walPath)
+	require.ErrorIs(t, err, sarama.ErrLeaderNotAvailable)
+
+	// other errors would fail every replay, so the entry is dropped
+	failing := &stubSyncProducer{err: sarama.ErrMessageSizeTooLarge}
+	producer, err = NewWALSyncProducer(failing, walPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), failing.sent.Load())
+	require.NoError(t, producer.Close())
+
+	next := &stubSyncProducer{}
+	producer, err = NewWALSyncProducer(next, walPath)
+	require.NoError(t, err)
+	require.Zero(t, next.sent.Load())
+	require.NoError(t, producer.Close())
+}